@@ -1,20 +1,41 @@
 package config
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"github.com/joho/godotenv"
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
 var DB *gorm.DB
 
 // ConnectDatabase connects to the database and returns the DB instance.
+// DB_DRIVER selects MySQL ("mysql", the default) or PostgreSQL ("postgres").
 func ConnectDatabase() *gorm.DB {
-	dsn := "root:@tcp(127.0.0.1:3306)/farmers_market_db?charset=utf8mb4&parseTime=True&loc=Local"
-	database, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
+	host := GetEnv("DB_HOST", "127.0.0.1")
+	user := GetEnv("DB_USER", "root")
+	password := os.Getenv("DB_PASSWORD")
+	name := GetEnv("DB_NAME", "farmers_market_db")
+
+	var dialector gorm.Dialector
+	switch driver := GetEnv("DB_DRIVER", "mysql"); driver {
+	case "mysql":
+		dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
+			user, password, host, GetEnv("DB_PORT", "3306"), name)
+		dialector = mysql.Open(dsn)
+	case "postgres":
+		dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=%s TimeZone=UTC",
+			host, user, password, name, GetEnv("DB_PORT", "5432"), GetEnv("DB_SSLMODE", "disable"))
+		dialector = postgres.Open(dsn)
+	default:
+		log.Fatalf("Unsupported DB_DRIVER %q, use mysql or postgres", driver)
+	}
+	database, err := gorm.Open(dialector, &gorm.Config{})
 
 	if err != nil {
 		log.Fatal("Failed to connect to the database!")
@@ -32,9 +53,9 @@ func LoadEnv() {
 		log.Println("Warning: No .env file found. Loading environment variables from system.")
 	}
 
-	// Check if JWT_SECRET_KEY environment variable is set
-	if os.Getenv("JWT_SECRET") == "" {
-		log.Fatal("Error: JWT_SECRET environment variable not set. Exiting with status 1.")
+	// Check if a token signing key is set
+	if os.Getenv("JWT_SECRET") == "" && os.Getenv("JWT_KEYS") == "" {
+		log.Fatal("Error: JWT_SECRET or JWT_KEYS environment variable not set. Exiting with status 1.")
 		os.Exit(1)
 	}
 }
@@ -48,3 +69,14 @@ func GetEnv(key, fallback string) string {
 	}
 	return value
 }
+
+// GetEnvDuration retrieves an environment variable as a duration (e.g. "30m")
+// or returns a fallback value if it is missing or invalid
+func GetEnvDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(GetEnv(key, fallback.String()))
+	if err != nil {
+		log.Printf("Warning: %s is not a valid duration. Using fallback value: %s", key, fallback)
+		return fallback
+	}
+	return value
+}
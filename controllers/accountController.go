@@ -0,0 +1,98 @@
+// controllers/accountController.go
+package controllers
+
+import (
+	"errors"
+	"farmers_market_backend/config"
+	"farmers_market_backend/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// VerifyEmail confirms the email address of a user with the token from the
+// verification email
+func VerifyEmail(c *gin.Context) {
+	var input struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	if _, err := services.VerifyEmail(config.DB, input.Token); err != nil {
+		respondAccountTokenError(c, err, "Failed to verify email address")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email address verified successfully"})
+}
+
+// ResendVerificationEmail sends a new verification link. It responds the same
+// whether or not the address has an account.
+func ResendVerificationEmail(c *gin.Context) {
+	var input struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	if err := services.ResendEmailVerification(config.DB, input.Email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If the address has an unverified account, a verification email is on its way"})
+}
+
+// ForgotPassword emails a password reset link. It responds the same whether
+// or not the address has an account.
+func ForgotPassword(c *gin.Context) {
+	var input struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	if err := services.RequestPasswordReset(config.DB, input.Email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send password reset email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If the address has an account, a password reset email is on its way"})
+}
+
+// ResetPassword sets a new password with the token from the password reset
+// email and logs the user out on all devices
+func ResetPassword(c *gin.Context) {
+	var input struct {
+		Token    string `json:"token" binding:"required"`
+		Password string `json:"password" binding:"required,min=6,max=255"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	if err := services.ResetPassword(config.DB, input.Token, input.Password); err != nil {
+		respondAccountTokenError(c, err, "Failed to reset password")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully, please log in again"})
+}
+
+// respondAccountTokenError writes the response for a failure to use an
+// emailed token
+func respondAccountTokenError(c *gin.Context, err error, message string) {
+	if errors.Is(err, services.ErrInvalidToken) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The link is invalid, expired or already used"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+}
@@ -1,18 +1,17 @@
 package controllers
 
 import (
+	"errors"
 	"farmers_market_backend/config"
-	"farmers_market_backend/middleware"
 	"farmers_market_backend/models"
-	"farmers_market_backend/utils"
+	"farmers_market_backend/services"
 	"log"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v4"
-	"github.com/jinzhu/gorm"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 // Register registers a new user
@@ -31,8 +30,9 @@ import (
 // If the password hashing fails, the function returns a 500 Internal Server
 // Error response with an error message.
 //
-// If the user is saved to the database successfully, the function returns
-// a 201 Created response with a success message.
+// If the user is saved to the database successfully, the function emails
+// them a link to verify their address and returns a 201 Created response
+// with a success message.
 func Register(c *gin.Context) {
 	// Input struct to store the user's registration data
 	var input struct {
@@ -57,7 +57,7 @@ func Register(c *gin.Context) {
 	// Create a new user with the input data
 	user := models.User{
 		Username: input.Username,
-		Email:    input.Email,
+		Email:    &input.Email,
 		Password: string(hashedPassword),
 	}
 
@@ -67,8 +67,13 @@ func Register(c *gin.Context) {
 		return
 	}
 
+	// Ask the user to confirm the email address; they can request a new link if this fails
+	if err := services.SendEmailVerification(config.DB, user); err != nil {
+		log.Printf("Failed to send verification email to user %d: %v", user.ID, err)
+	}
+
 	// Return a success message if the user is registered successfully
-	c.JSON(http.StatusCreated, gin.H{"message": "User registered successfully"})
+	c.JSON(http.StatusCreated, gin.H{"message": "User registered successfully, check your email to confirm your address"})
 }
 
 func Login(c *gin.Context) {
@@ -83,25 +88,31 @@ func Login(c *gin.Context) {
 	}
 
 	var user models.User
-	config.DB.Where("email = ?", input.Email).First(&user)
+	config.DB.Preload("Roles").Where("email = ?", input.Email).First(&user)
 
 	if user.ID == 0 || bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password)) != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
+	if user.EmailVerifiedAt == nil && services.EmailVerificationRequired() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Email address not verified"})
+		return
+	}
 
-	token, err := utils.GenerateJWT(user.ID)
+	// Each login starts a session on the client's device
+	pair, err := services.StartSession(config.DB, user, c.Request.UserAgent(), c.ClientIP())
 	if err != nil {
+		log.Printf("Failed to start session: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"token": token})
+	c.JSON(http.StatusOK, gin.H{"token": pair.AccessToken, "refresh_token": pair.RefreshToken, "session_id": pair.SessionID})
 }
 
 // UpdateUser updates an existing user by ID
 func UpdateUser(c *gin.Context) {
-	userID := c.Param("userID")
+	userID := c.Param("id")
 	var updatedUser models.User
 
 	// Bind JSON data to the updatedUser struct
@@ -112,8 +123,8 @@ func UpdateUser(c *gin.Context) {
 
 	// Find the existing user
 	var user models.User
-	if err := db.First(&user, userID).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
+	if err := config.DB.First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		} else {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
@@ -124,30 +135,39 @@ func UpdateUser(c *gin.Context) {
 	// Update user fields
 	user.Name = updatedUser.Name
 	user.ImageURL = updatedUser.ImageURL // Update the image URL if provided
-	user.IsSeller = updatedUser.IsSeller // Update the isSeller status
+	user.FarmName = updatedUser.FarmName // Update the public farm name
+	user.FarmAddress = updatedUser.FarmAddress
+	user.FarmLatitude = updatedUser.FarmLatitude
+	user.FarmLongitude = updatedUser.FarmLongitude
+	if !services.ValidCoordinates(user.FarmLatitude, user.FarmLongitude) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Farm latitude and longitude must be given together and in range"})
+		return
+	}
 
 	// Save the updated user
-	if err := db.Save(&user).Error; err != nil {
+	if err := config.DB.Save(&user).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
 		return
 	}
 
+	// Products are found by their seller's name, so their search text changes with it
+	if err := services.RefreshSearchText(config.DB, "seller_id = ?", user.ID); err != nil {
+		log.Printf("Failed to refresh product search text of seller %d: %v", user.ID, err)
+	}
+
 	c.JSON(http.StatusOK, gin.H{"user": user})
 }
 
-// GetUsers retrieves all users
+// GetUsers retrieves a page of users
 func GetUsers(c *gin.Context) {
-	var users []models.User
-	if err := config.DB.Preload("District").Find(&users).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to retrieve users"})
-		return
-	}
-	c.JSON(http.StatusOK, users)
+	respondPage(c, config.DB.Model(&models.User{}), "users.id", false,
+		func(user models.User) uint { return user.ID }, preloadDistrict)
 }
 
-// GetUser fetches a user by ID. Requires either admin rights or self-ownership of the profile.
+// GetUser fetches a user by ID. The route requires the users:read permission,
+// which every user holds for their own profile.
 func GetUser(c *gin.Context) {
-	// Parse user ID from URL or middleware
+	// Parse user ID from URL
 	userIDParam := c.Param("id")
 	requestedUserID, err := strconv.ParseUint(userIDParam, 10, 32)
 	if err != nil {
@@ -155,22 +175,9 @@ func GetUser(c *gin.Context) {
 		return
 	}
 
-	// Get the user ID from the context set by middleware (e.g., after token validation)
-	authUserID, exists := c.Get("user_id") // Ensure middleware sets user_id in context
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authorized"})
-		return
-	}
-
-	// Check if the requested user is either the authenticated user or the user is an admin
-	if uint(authUserID.(uint)) != uint(requestedUserID) && !c.GetBool("is_admin") {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Unauthorized access"})
-		return
-	}
-
 	// Retrieve user details from database
 	var user models.User
-	if err := config.DB.Preload("District").First(&user, requestedUserID).Error; err != nil {
+	if err := config.DB.Preload("District").Preload("Roles").First(&user, requestedUserID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
@@ -188,6 +195,7 @@ func GetUser(c *gin.Context) {
 		"delivery_address": user.DeliveryAddress,
 		"mobile_number":    user.MobileNumber,
 		"is_admin":         user.IsAdmin,
+		"roles":            user.RoleNames(),
 		"created_at":       user.CreatedAt,
 		"updated_at":       user.UpdatedAt,
 	})
@@ -195,10 +203,10 @@ func GetUser(c *gin.Context) {
 
 // DeleteUser deletes a user by ID
 func DeleteUser(c *gin.Context) {
-	userID := c.Param("userID")
+	userID := c.Param("id")
 
 	// Delete the user by ID
-	if err := db.Delete(&models.User{}, userID).Error; err != nil {
+	if err := config.DB.Delete(&models.User{}, userID).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
 		return
 	}
@@ -210,7 +218,7 @@ func DeleteUser(c *gin.Context) {
 func IsAdmin(c *gin.Context) {
 	userID := c.Param("id")
 	var user models.User
-	if err := config.DB.First(&user, userID).Error; err != nil {
+	if err := db.First(&user, userID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
@@ -229,35 +237,20 @@ func RefreshToken(c *gin.Context) {
 		return
 	}
 
-	log.Printf("Refresh token: %v", input.RefreshToken)
-
-	// Validate the refresh token
-	token, err := jwt.Parse(input.RefreshToken, func(token *jwt.Token) (interface{}, error) {
-		// Use the JWTSecretKey variable instead of the hardcoded secret key
-		return middleware.JWTSecretKey, nil
-	})
-
-	if err != nil || !token.Valid {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
-		return
-	}
-
-	// Extract claims from the token
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok || !token.Valid {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid claims"})
-		return
-	}
-
-	// Extract user ID from claims
-	userID := uint(claims["sub"].(float64)) // Ensure "sub" is set correctly in your token
-
-	// Generate a new access token
-	newAccessToken, err := utils.GenerateJWT(userID)
+	// Exchange the refresh token for a new pair; it cannot be used again
+	pair, err := services.RotateRefreshToken(config.DB, input.RefreshToken, c.ClientIP())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate new access token"})
+		switch {
+		case errors.Is(err, services.ErrRefreshTokenReused):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token was already used, please log in again"})
+		case errors.Is(err, services.ErrInvalidToken):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		default:
+			log.Printf("Failed to rotate refresh token: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate new access token"})
+		}
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"access_token": newAccessToken})
+	c.JSON(http.StatusOK, gin.H{"access_token": pair.AccessToken, "refresh_token": pair.RefreshToken})
 }
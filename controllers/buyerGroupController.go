@@ -0,0 +1,167 @@
+// controllers/buyerGroupController.go
+package controllers
+
+import (
+	"errors"
+	"farmers_market_backend/config"
+	"farmers_market_backend/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// CreateBuyerGroup creates a new buyer group
+func CreateBuyerGroup(c *gin.Context) {
+	var input struct {
+		Name        string `json:"name" binding:"required"`
+		Description string `json:"description"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	group := models.BuyerGroup{Name: input.Name, Description: input.Description}
+	if err := config.DB.Create(&group).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create buyer group"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"buyer_group": group})
+}
+
+// GetBuyerGroups retrieves all buyer groups
+func GetBuyerGroups(c *gin.Context) {
+	var groups []models.BuyerGroup
+	if err := config.DB.Order("name").Find(&groups).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve buyer groups"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"buyer_groups": groups})
+}
+
+// GetBuyerGroup retrieves a buyer group with its members and price list
+func GetBuyerGroup(c *gin.Context) {
+	var group models.BuyerGroup
+	if err := config.DB.Preload("Members").Preload("Prices", orderByMinQuantity).First(&group, c.Param("groupID")).Error; err != nil {
+		respondBuyerGroupNotFound(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"buyer_group": group})
+}
+
+// UpdateBuyerGroup updates the name and description of a buyer group
+func UpdateBuyerGroup(c *gin.Context) {
+	var input struct {
+		Name        string `json:"name" binding:"required"`
+		Description string `json:"description"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	var group models.BuyerGroup
+	if err := config.DB.First(&group, c.Param("groupID")).Error; err != nil {
+		respondBuyerGroupNotFound(c, err)
+		return
+	}
+
+	group.Name = input.Name
+	group.Description = input.Description
+	if err := config.DB.Save(&group).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update buyer group"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"buyer_group": group})
+}
+
+// DeleteBuyerGroup deletes a buyer group together with its members and price list
+func DeleteBuyerGroup(c *gin.Context) {
+	var group models.BuyerGroup
+	if err := config.DB.First(&group, c.Param("groupID")).Error; err != nil {
+		respondBuyerGroupNotFound(c, err)
+		return
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&group).Association("Members").Clear(); err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("buyer_group_id = ?", group.ID).Delete(&models.BuyerGroupPrice{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&group).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete buyer group"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Buyer group deleted successfully"})
+}
+
+// AddBuyerGroupMember adds a user to a buyer group
+func AddBuyerGroupMember(c *gin.Context) {
+	var input struct {
+		UserID uint `json:"user_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	var group models.BuyerGroup
+	if err := config.DB.First(&group, c.Param("groupID")).Error; err != nil {
+		respondBuyerGroupNotFound(c, err)
+		return
+	}
+
+	var user models.User
+	if err := config.DB.First(&user, input.UserID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := config.DB.Model(&group).Association("Members").Append(&user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add buyer group member"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member added successfully"})
+}
+
+// RemoveBuyerGroupMember removes a user from a buyer group
+func RemoveBuyerGroupMember(c *gin.Context) {
+	var group models.BuyerGroup
+	if err := config.DB.First(&group, c.Param("groupID")).Error; err != nil {
+		respondBuyerGroupNotFound(c, err)
+		return
+	}
+
+	var user models.User
+	if err := config.DB.First(&user, c.Param("userID")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if err := config.DB.Model(&group).Association("Members").Delete(&user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove buyer group member"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed successfully"})
+}
+
+// respondBuyerGroupNotFound writes the HTTP response for an error loading a buyer group
+func respondBuyerGroupNotFound(c *gin.Context, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Buyer group not found"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve buyer group"})
+}
@@ -0,0 +1,247 @@
+// controllers/cartController.go
+package controllers
+
+import (
+	"errors"
+	"farmers_market_backend/config"
+	"farmers_market_backend/middleware"
+	"farmers_market_backend/models"
+	"farmers_market_backend/services"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// GetCart retrieves the cart of the authenticated user
+func GetCart(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authorized"})
+		return
+	}
+
+	cart, err := findOrCreateCart(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve cart"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"cart": cart})
+}
+
+// AddCartItem adds a product variant to the cart of the authenticated user. If
+// the variant is already in the cart its quantity is increased. The variant may
+// be left out for products that have a single variant, and the quantity may be
+// given in any unit of the same dimension as the variant's unit.
+func AddCartItem(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authorized"})
+		return
+	}
+
+	var input struct {
+		ProductID       uint    `json:"product_id"`
+		VariantID       uint    `json:"variant_id"`
+		Quantity        float64 `json:"quantity" binding:"required,gt=0"`
+		UnitOfMeasureID uint    `json:"unit_of_measure_id"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	variant, ok := resolveVariant(c, input.ProductID, input.VariantID)
+	if !ok {
+		return
+	}
+	if variant.Product.DelistedAt != nil || !services.InSeason(*variant.Product, time.Now()) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Product is out of season"})
+		return
+	}
+	quantity, ok := variantQuantity(c, variant, input.Quantity, input.UnitOfMeasureID)
+	if !ok {
+		return
+	}
+
+	cart, err := findOrCreateCart(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve cart"})
+		return
+	}
+
+	var item models.CartItem
+	err = config.DB.Where("cart_id = ? AND variant_id = ?", cart.ID, variant.ID).First(&item).Error
+	switch {
+	case err == nil:
+		item.Quantity += quantity
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		item = models.CartItem{CartID: cart.ID, ProductID: variant.ProductID, VariantID: variant.ID, Quantity: quantity}
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve cart item"})
+		return
+	}
+	if reason := services.CheckQuantity(variant, item.Quantity); reason != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid quantity", "reason": reason})
+		return
+	}
+	item.UnitPrice, err = services.QuoteUnitPrice(config.DB, userID, *variant.Product, variant, item.Quantity)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to price cart item"})
+		return
+	}
+
+	if err := config.DB.Save(&item).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add item to cart"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"item": item})
+}
+
+// UpdateCartItem changes the quantity of an item in the cart of the
+// authenticated user. The quantity may be given in any unit of the same
+// dimension as the variant's unit.
+func UpdateCartItem(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authorized"})
+		return
+	}
+
+	var input struct {
+		Quantity        float64 `json:"quantity" binding:"required,gt=0"`
+		UnitOfMeasureID uint    `json:"unit_of_measure_id"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	item, err := findCartItem(userID, c.Param("itemID"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cart item not found"})
+		return
+	}
+
+	// Quantity price tiers may give the new quantity a different unit price
+	var variant models.ProductVariant
+	if err := config.DB.Preload("Product").Preload("UnitOfMeasure").First(&variant, item.VariantID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product variant not found"})
+		return
+	}
+	item.Quantity, ok = variantQuantity(c, variant, input.Quantity, input.UnitOfMeasureID)
+	if !ok {
+		return
+	}
+	if reason := services.CheckQuantity(variant, item.Quantity); reason != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid quantity", "reason": reason})
+		return
+	}
+	item.UnitPrice, err = services.QuoteUnitPrice(config.DB, userID, *variant.Product, variant, item.Quantity)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to price cart item"})
+		return
+	}
+
+	if err := config.DB.Save(&item).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update cart item"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"item": item})
+}
+
+// RemoveCartItem removes an item from the cart of the authenticated user
+func RemoveCartItem(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authorized"})
+		return
+	}
+
+	item, err := findCartItem(userID, c.Param("itemID"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cart item not found"})
+		return
+	}
+
+	if err := config.DB.Unscoped().Delete(&item).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove cart item"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Cart item removed successfully"})
+}
+
+// ClearCart removes all items from the cart of the authenticated user
+func ClearCart(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authorized"})
+		return
+	}
+
+	cart, err := findOrCreateCart(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve cart"})
+		return
+	}
+
+	if err := config.DB.Unscoped().Where("cart_id = ?", cart.ID).Delete(&models.CartItem{}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear cart"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Cart cleared successfully"})
+}
+
+// findOrCreateCart returns the user's cart with its items, creating an empty cart if needed
+func findOrCreateCart(userID uint) (models.Cart, error) {
+	var cart models.Cart
+	err := config.DB.Preload("Items.Product").Preload("Items.Variant").Where(models.Cart{UserID: userID}).FirstOrCreate(&cart).Error
+	return cart, err
+}
+
+// findCartItem returns a cart item by ID if it belongs to the user's cart
+func findCartItem(userID uint, itemID string) (models.CartItem, error) {
+	var item models.CartItem
+	err := config.DB.Joins("JOIN carts ON carts.id = cart_items.cart_id").
+		Where("cart_items.id = ? AND carts.user_id = ?", itemID, userID).
+		First(&item).Error
+	return item, err
+}
+
+// resolveVariant finds the variant a buyer chose, writing an error response if
+// it cannot be determined
+func resolveVariant(c *gin.Context, productID, variantID uint) (models.ProductVariant, bool) {
+	variant, err := services.ResolveVariant(config.DB, productID, variantID)
+	switch {
+	case err == nil:
+		return variant, true
+	case errors.Is(err, services.ErrVariantNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product variant not found"})
+	case errors.Is(err, services.ErrVariantRequired):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Product has several variants, variant_id is required"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve product variant"})
+	}
+	return variant, false
+}
+
+// variantQuantity converts a quantity to the unit of the variant, writing an
+// error response if the units are not compatible
+func variantQuantity(c *gin.Context, variant models.ProductVariant, quantity float64, unitID uint) (float64, bool) {
+	converted, err := services.QuantityInVariantUnit(config.DB, variant, quantity, unitID)
+	switch {
+	case err == nil:
+		return converted, true
+	case errors.Is(err, services.ErrIncompatibleUnits):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity cannot be converted to " + variant.UnitOfMeasure.Name})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to convert quantity"})
+	}
+	return 0, false
+}
@@ -2,7 +2,9 @@
 package controllers
 
 import (
+	"farmers_market_backend/config"
 	"farmers_market_backend/models"
+	"farmers_market_backend/services"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -26,15 +28,10 @@ func CreateCategory(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"category": category})
 }
 
-// GetCategories retrieves all categories
+// GetCategories retrieves a page of categories
 func GetCategories(c *gin.Context) {
-	var categories []models.Category
-	if err := db.Find(&categories).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve categories"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{"categories": categories})
+	respondPage(c, config.DB.Model(&models.Category{}), "categories.id", false,
+		func(category models.Category) uint { return category.ID })
 }
 
 // UpdateCategory updates an existing category
@@ -48,7 +45,7 @@ func UpdateCategory(c *gin.Context) {
 	}
 
 	var category models.Category
-	if err := db.First(&category, categoryID).Error; err != nil {
+	if err := config.DB.First(&category, categoryID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
 		} else {
@@ -60,7 +57,14 @@ func UpdateCategory(c *gin.Context) {
 	category.Name = updatedCategory.Name
 	category.Description = updatedCategory.Description
 
-	if err := db.Save(&category).Error; err != nil {
+	// Products are found by their category name, so their search text changes with it
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&category).Error; err != nil {
+			return err
+		}
+		return services.RefreshSearchText(tx, "category_id = ?", category.ID)
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update category"})
 		return
 	}
@@ -0,0 +1,85 @@
+// controllers/certificationController.go
+package controllers
+
+import (
+	"errors"
+	"farmers_market_backend/config"
+	"farmers_market_backend/middleware"
+	"farmers_market_backend/models"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// GetCertifications lists the certifications of the authenticated seller's farm
+func GetCertifications(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authorized"})
+		return
+	}
+
+	var certifications []models.Certification
+	if err := config.DB.Where("seller_id = ?", userID).Order("name").Find(&certifications).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve certifications"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"certifications": certifications})
+}
+
+// CreateCertification adds a certification to the authenticated seller's farm
+func CreateCertification(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authorized"})
+		return
+	}
+
+	var certification models.Certification
+	if err := c.ShouldBindJSON(&certification); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	certification.ID = 0
+	certification.SellerID = userID
+	certification.Name = strings.TrimSpace(certification.Name)
+	if certification.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Certification name is required"})
+		return
+	}
+	if certification.ValidFrom != nil && certification.ValidUntil != nil && certification.ValidUntil.Before(*certification.ValidFrom) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Certification cannot end before it starts"})
+		return
+	}
+
+	if err := config.DB.Create(&certification).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create certification"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"certification": certification})
+}
+
+// DeleteCertification removes a certification of the authenticated seller's
+// farm. The route checks the user may manage the certification.
+func DeleteCertification(c *gin.Context) {
+	var certification models.Certification
+	if err := config.DB.First(&certification, c.Param("certificationID")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Certification not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve certification"})
+		}
+		return
+	}
+
+	if err := config.DB.Delete(&certification).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete certification"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Certification deleted successfully"})
+}
@@ -2,6 +2,8 @@
 package controllers
 
 import (
+	"farmers_market_backend/config"
+	"farmers_market_backend/middleware"
 	"farmers_market_backend/models"
 	"net/http"
 
@@ -11,8 +13,15 @@ import (
 
 var db *gorm.DB // Ensure this is initialized in main.go
 
-// SendMessage handles sending a message (text, image, voice, file)
+// SendMessage handles sending a message (text, image, voice, file) from the
+// authenticated user
 func SendMessage(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authorized"})
+		return
+	}
+
 	var message models.Message
 
 	// Bind JSON data to the message struct
@@ -20,9 +29,11 @@ func SendMessage(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
 		return
 	}
+	message.ID = 0
+	message.SenderID = userID
 
 	// Save the message to the database
-	if err := db.Create(&message).Error; err != nil {
+	if err := config.DB.Create(&message).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
 		return
 	}
@@ -30,19 +41,15 @@ func SendMessage(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": message})
 }
 
-// GetMessages retrieves messages between two users
+// GetMessages retrieves a page of messages between two users, newest first
 func GetMessages(c *gin.Context) {
 	senderID := c.Query("sender_id")
 	receiverID := c.Query("receiver_id")
 
-	var messages []models.Message
-	// Retrieve messages from the database
-	if err := db.Where("(sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?)", senderID, receiverID, receiverID, senderID).Find(&messages).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve messages"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{"messages": messages})
+	// Page through the conversation from the latest message back
+	query := config.DB.Model(&models.Message{}).
+		Where("(sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?)", senderID, receiverID, receiverID, senderID)
+	respondPage(c, query, "messages.id", true, func(message models.Message) uint { return message.ID })
 }
 
 // Existing methods...
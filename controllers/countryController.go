@@ -5,14 +5,13 @@ import (
 	"farmers_market_backend/models"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
-// GetCountries retrieves all countries
+// GetCountries retrieves a page of countries
 func GetCountries(c *gin.Context) {
-	var countries []models.Country
-
 	// Ensure db is initialized
 	if config.DB == nil {
 		log.Println("Database connection is nil")
@@ -20,20 +19,8 @@ func GetCountries(c *gin.Context) {
 		return
 	}
 
-	// Initialize countries slice to avoid nil pointer dereference
-	if len(countries) == 0 {
-		countries = []models.Country{}
-	}
-
-	// Fetch countries from the database
-	if err := config.DB.Find(&countries).Error; err != nil {
-		log.Printf("Error fetching countries: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching countries"})
-		return
-	}
-
-	// Return the list of countries
-	c.JSON(http.StatusOK, countries)
+	respondPage(c, config.DB.Model(&models.Country{}), "countries.id", false,
+		func(country models.Country) uint { return country.ID })
 }
 
 // GetCountry retrieves a country by ID
@@ -57,6 +44,10 @@ func CreateCountry(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input format"})
 		return
 	}
+	if !validDialingCodes(country) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Calling code must be 1 to 3 digits and trunk prefix up to 2 digits"})
+		return
+	}
 
 	// Attempt to create the country in the database with detailed error logging
 	if err := config.DB.Create(&country).Error; err != nil {
@@ -80,7 +71,11 @@ func UpdateCountry(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
 		return
 	}
-	db.Save(&country)
+	if !validDialingCodes(country) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Calling code must be 1 to 3 digits and trunk prefix up to 2 digits"})
+		return
+	}
+	config.DB.Save(&country)
 	c.JSON(http.StatusOK, country)
 }
 
@@ -93,3 +88,20 @@ func DeleteCountry(c *gin.Context) {
 	}
 	c.JSON(http.StatusNoContent, nil)
 }
+
+// validDialingCodes checks the calling code and trunk prefix mobile numbers
+// of the country are normalised with
+func validDialingCodes(country models.Country) bool {
+	digits := func(value string, max int) bool {
+		if len(value) > max {
+			return false
+		}
+		for _, r := range value {
+			if r < '0' || r > '9' {
+				return false
+			}
+		}
+		return true
+	}
+	return digits(country.CallingCode, 3) && digits(country.TrunkPrefix, 2) && !strings.HasPrefix(country.CallingCode, "0")
+}
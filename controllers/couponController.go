@@ -0,0 +1,256 @@
+// controllers/couponController.go
+package controllers
+
+import (
+	"errors"
+	"farmers_market_backend/config"
+	"farmers_market_backend/middleware"
+	"farmers_market_backend/models"
+	"farmers_market_backend/services"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// couponInput is the request body for creating or updating a coupon
+type couponInput struct {
+	Code           string       `json:"code"`
+	Description    string       `json:"description"`
+	Type           string       `json:"type" binding:"required,oneof=percentage fixed"`
+	Percent        float64      `json:"percent"`
+	Amount         models.Money `json:"amount"`
+	MinBasket      models.Money `json:"min_basket"`
+	UsageLimit     int          `json:"usage_limit"`
+	PerUserLimit   int          `json:"per_user_limit"`
+	StartsAt       *time.Time   `json:"starts_at"`
+	EndsAt         *time.Time   `json:"ends_at"`
+	FirstOrderOnly bool         `json:"first_order_only"`
+	CategoryID     *uint        `json:"category_id"`
+	SellerID       *uint        `json:"seller_id"`
+	ProductID      *uint        `json:"product_id"`
+	IsActive       *bool        `json:"is_active"`
+}
+
+// CreateCoupon creates a coupon. Admins may scope coupons freely, sellers'
+// coupons always apply to their own products only.
+func CreateCoupon(c *gin.Context) {
+	userID, isAdmin, ok := couponManager(c)
+	if !ok {
+		return
+	}
+
+	var input couponInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+	input.Code = services.NormalizeCouponCode(input.Code)
+	if input.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Coupon code is required"})
+		return
+	}
+
+	coupon := models.Coupon{Code: input.Code, CreatedByID: userID, IsActive: true}
+	if !applyCouponInput(c, &coupon, input, userID, isAdmin) {
+		return
+	}
+
+	var existing int64
+	if err := config.DB.Unscoped().Model(&models.Coupon{}).Where("code = ?", coupon.Code).Count(&existing).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create coupon"})
+		return
+	}
+	if existing > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Coupon code already exists"})
+		return
+	}
+
+	if err := config.DB.Create(&coupon).Error; err != nil {
+		log.Printf("Failed to create coupon: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create coupon"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"coupon": coupon})
+}
+
+// GetCoupons retrieves a page of coupons, newest first: all coupons for admins
+// and the seller's own coupons for sellers
+func GetCoupons(c *gin.Context) {
+	userID, isAdmin, ok := couponManager(c)
+	if !ok {
+		return
+	}
+
+	query := config.DB.Model(&models.Coupon{})
+	if !isAdmin {
+		query = query.Where("created_by_id = ?", userID)
+	}
+
+	respondPage(c, query, "coupons.id", true, func(coupon models.Coupon) uint { return coupon.ID })
+}
+
+// GetCoupon retrieves a single coupon
+func GetCoupon(c *gin.Context) {
+	coupon, _, _, ok := findManagedCoupon(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"coupon": coupon})
+}
+
+// UpdateCoupon updates the terms of a coupon. The code cannot be changed once created.
+func UpdateCoupon(c *gin.Context) {
+	coupon, userID, isAdmin, ok := findManagedCoupon(c)
+	if !ok {
+		return
+	}
+
+	var input couponInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+	if !applyCouponInput(c, &coupon, input, userID, isAdmin) {
+		return
+	}
+
+	if err := config.DB.Save(&coupon).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update coupon"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"coupon": coupon})
+}
+
+// DeleteCoupon deletes a coupon. Its redemptions are kept for reporting.
+func DeleteCoupon(c *gin.Context) {
+	coupon, _, _, ok := findManagedCoupon(c)
+	if !ok {
+		return
+	}
+
+	if err := config.DB.Delete(&coupon).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete coupon"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Coupon deleted successfully"})
+}
+
+// GetCouponReport retrieves redemption and order statistics of a coupon
+func GetCouponReport(c *gin.Context) {
+	coupon, _, _, ok := findManagedCoupon(c)
+	if !ok {
+		return
+	}
+
+	report, err := services.GetCouponReport(config.DB, coupon)
+	if err != nil {
+		log.Printf("Failed to build coupon report: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build coupon report"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"report": report})
+}
+
+// couponManager returns the authenticated user and whether they may manage
+// every coupon rather than only their own. The route checks the user holds the
+// coupons:manage permission.
+func couponManager(c *gin.Context) (uint, bool, bool) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authorized"})
+		return 0, false, false
+	}
+
+	return userID, middleware.Can(c, models.PermissionCouponsManage), true
+}
+
+// findManagedCoupon loads the coupon in the URL, writing an error response if
+// it does not exist. The route checks the user may manage the coupon.
+func findManagedCoupon(c *gin.Context) (models.Coupon, uint, bool, bool) {
+	var coupon models.Coupon
+	userID, isAdmin, ok := couponManager(c)
+	if !ok {
+		return coupon, 0, false, false
+	}
+
+	if err := config.DB.First(&coupon, c.Param("couponID")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Coupon not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve coupon"})
+		}
+		return coupon, 0, false, false
+	}
+
+	return coupon, userID, isAdmin, true
+}
+
+// applyCouponInput validates the input and copies it onto the coupon, writing
+// an error response if it is invalid
+func applyCouponInput(c *gin.Context, coupon *models.Coupon, input couponInput, userID uint, isAdmin bool) bool {
+	// Sellers can only discount their own products
+	if !isAdmin {
+		input.SellerID = &userID
+	}
+
+	switch {
+	case input.Type == models.CouponTypePercentage && (input.Percent <= 0 || input.Percent > 100):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Percent must be greater than 0 and at most 100"})
+		return false
+	case input.Type == models.CouponTypeFixed && !isValidAmount(input.Amount):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Amount must be a positive " + models.DefaultCurrency + " amount"})
+		return false
+	case !input.MinBasket.IsZero() && !isValidAmount(input.MinBasket):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Minimum basket must be a positive " + models.DefaultCurrency + " amount"})
+		return false
+	case input.UsageLimit < 0 || input.PerUserLimit < 0:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Usage limits cannot be negative"})
+		return false
+	case input.StartsAt != nil && input.EndsAt != nil && !input.EndsAt.After(*input.StartsAt):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Coupon must end after it starts"})
+		return false
+	}
+
+	if input.ProductID != nil {
+		var product models.Product
+		if err := config.DB.First(&product, *input.ProductID).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+			return false
+		}
+		if !isAdmin && product.SellerID != userID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You can only create coupons for your own products"})
+			return false
+		}
+	}
+
+	coupon.Description = input.Description
+	coupon.Type = input.Type
+	coupon.Percent = 0
+	coupon.Amount = models.NewMoney(0, models.DefaultCurrency)
+	if input.Type == models.CouponTypePercentage {
+		coupon.Percent = input.Percent
+	} else {
+		coupon.Amount = input.Amount
+	}
+	coupon.MinBasket = models.NewMoney(0, models.DefaultCurrency).Add(input.MinBasket)
+	coupon.UsageLimit = input.UsageLimit
+	coupon.PerUserLimit = input.PerUserLimit
+	coupon.StartsAt = input.StartsAt
+	coupon.EndsAt = input.EndsAt
+	coupon.FirstOrderOnly = input.FirstOrderOnly
+	coupon.CategoryID = input.CategoryID
+	coupon.SellerID = input.SellerID
+	coupon.ProductID = input.ProductID
+	if input.IsActive != nil {
+		coupon.IsActive = *input.IsActive
+	}
+	return true
+}
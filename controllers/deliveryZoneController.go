@@ -0,0 +1,220 @@
+// controllers/deliveryZoneController.go
+package controllers
+
+import (
+	"errors"
+	"farmers_market_backend/config"
+	"farmers_market_backend/middleware"
+	"farmers_market_backend/models"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// deliveryZoneInput is the body of a delivery zone create or update request
+type deliveryZoneInput struct {
+	Name             string       `json:"name"`
+	FeeType          string       `json:"fee_type"` // Defaults to flat
+	BaseFee          models.Money `json:"base_fee"`
+	Rate             models.Money `json:"rate"`
+	FreeDeliveryFrom models.Money `json:"free_delivery_from"`
+	MinimumBasket    models.Money `json:"minimum_basket"`
+	DistrictIDs      []uint       `json:"district_ids"`
+}
+
+// GetDeliveryZones lists the delivery zones of the authenticated seller
+func GetDeliveryZones(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authorized"})
+		return
+	}
+	listDeliveryZones(c, userID)
+}
+
+// GetSellerDeliveryZones lists the delivery zones of a seller, so buyers can
+// see where and on what terms the seller delivers
+func GetSellerDeliveryZones(c *gin.Context) {
+	var seller models.User
+	if err := config.DB.First(&seller, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Seller not found"})
+		return
+	}
+	listDeliveryZones(c, seller.ID)
+}
+
+func listDeliveryZones(c *gin.Context, sellerID uint) {
+	var zones []models.DeliveryZone
+	if err := config.DB.Preload("Districts").Where("seller_id = ?", sellerID).Order("name").Find(&zones).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve delivery zones"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"delivery_zones": zones})
+}
+
+// CreateDeliveryZone adds a delivery zone of the authenticated seller
+func CreateDeliveryZone(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authorized"})
+		return
+	}
+
+	zone := models.DeliveryZone{SellerID: userID}
+	saveDeliveryZone(c, &zone, http.StatusCreated)
+}
+
+// UpdateDeliveryZone replaces the terms and districts of a delivery zone of
+// the authenticated seller
+func UpdateDeliveryZone(c *gin.Context) {
+	zone, ok := findSellerDeliveryZone(c)
+	if !ok {
+		return
+	}
+	saveDeliveryZone(c, &zone, http.StatusOK)
+}
+
+// DeleteDeliveryZone removes a delivery zone of the authenticated seller. A
+// seller whose last zone is removed delivers everywhere for the default fee.
+func DeleteDeliveryZone(c *gin.Context) {
+	zone, ok := findSellerDeliveryZone(c)
+	if !ok {
+		return
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&zone).Association("Districts").Clear(); err != nil {
+			return err
+		}
+		return tx.Delete(&zone).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete delivery zone"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Delivery zone deleted successfully"})
+}
+
+// findSellerDeliveryZone loads the delivery zone in the URL, writing the error
+// response if it does not exist. The route checks the user may manage it.
+func findSellerDeliveryZone(c *gin.Context) (models.DeliveryZone, bool) {
+	var zone models.DeliveryZone
+	if err := config.DB.First(&zone, c.Param("zoneID")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Delivery zone not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve delivery zone"})
+		}
+		return zone, false
+	}
+	return zone, true
+}
+
+// saveDeliveryZone validates the request body, copies it onto the zone and
+// saves the zone with its districts
+func saveDeliveryZone(c *gin.Context, zone *models.DeliveryZone, status int) {
+	var input deliveryZoneInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	zone.Name = strings.TrimSpace(input.Name)
+	if zone.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Zone name is required"})
+		return
+	}
+	zone.FeeType = input.FeeType
+	if zone.FeeType == "" {
+		zone.FeeType = models.DeliveryFeeFlat
+	}
+	if !containsString(models.DeliveryFeeTypes, zone.FeeType) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Fee type must be one of " + strings.Join(models.DeliveryFeeTypes, ", ")})
+		return
+	}
+	for _, amount := range []*models.Money{&input.BaseFee, &input.Rate, &input.FreeDeliveryFrom, &input.MinimumBasket} {
+		if amount.Currency == "" {
+			amount.Currency = models.DefaultCurrency
+		}
+		if amount.Minor < 0 || amount.Currency != models.DefaultCurrency {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Fees and thresholds must be " + models.DefaultCurrency + " amounts that are not negative"})
+			return
+		}
+	}
+	if zone.FeeType != models.DeliveryFeeFlat && !input.Rate.IsPositive() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Rate is required for per-km and per-weight fees"})
+		return
+	}
+	zone.BaseFee, zone.Rate = input.BaseFee, input.Rate
+	zone.FreeDeliveryFrom, zone.MinimumBasket = input.FreeDeliveryFrom, input.MinimumBasket
+
+	if len(input.DistrictIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A zone needs at least one district"})
+		return
+	}
+	var districts []models.District
+	if err := config.DB.Where("id IN ?", input.DistrictIDs).Find(&districts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve districts"})
+		return
+	}
+	if len(districts) != len(uniqueIDs(input.DistrictIDs)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid district ID"})
+		return
+	}
+
+	// A district may only be in one zone of the seller, so the fee is unambiguous
+	var taken []uint
+	if err := config.DB.Table("delivery_zone_districts").
+		Joins("JOIN delivery_zones ON delivery_zones.id = delivery_zone_districts.delivery_zone_id").
+		Where("delivery_zones.seller_id = ? AND delivery_zones.id <> ? AND delivery_zones.deleted_at IS NULL", zone.SellerID, zone.ID).
+		Where("delivery_zone_districts.district_id IN ?", input.DistrictIDs).
+		Pluck("delivery_zone_districts.district_id", &taken).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check districts"})
+		return
+	}
+	if len(taken) > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Districts are already in another delivery zone", "district_ids": taken})
+		return
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Districts").Save(zone).Error; err != nil {
+			return err
+		}
+		return tx.Model(zone).Association("Districts").Replace(districts)
+	})
+	if err != nil {
+		log.Printf("Failed to save delivery zone: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save delivery zone"})
+		return
+	}
+
+	c.JSON(status, gin.H{"delivery_zone": zone})
+}
+
+// uniqueIDs returns ids without duplicates
+func uniqueIDs(ids []uint) []uint {
+	seen := map[uint]bool{}
+	var unique []uint
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
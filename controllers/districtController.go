@@ -3,20 +3,18 @@ package controllers
 import (
 	"farmers_market_backend/config"
 	"farmers_market_backend/models"
+	"farmers_market_backend/services"
 	"log"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
-// GetDistricts retrieves all districts
+// GetDistricts retrieves a page of districts
 func GetDistricts(c *gin.Context) {
-	var districts []models.District
-	if err := db.Find(&districts).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to retrieve districts"})
-		return
-	}
-	c.JSON(http.StatusOK, districts)
+	respondPage(c, config.DB.Model(&models.District{}), "districts.id", false,
+		func(district models.District) uint { return district.ID })
 }
 
 // GetDistrict retrieves a district by ID
@@ -48,6 +46,11 @@ func CreateDistrict(c *gin.Context) {
 		return
 	}
 
+	if !services.ValidCoordinates(district.Latitude, district.Longitude) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Latitude and longitude must be given together and in range"})
+		return
+	}
+
 	var country models.Country
 	if err := config.DB.First(&country, district.CountryID).Error; err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Country ID", "details": err.Error()})
@@ -76,6 +79,10 @@ func UpdateDistrict(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
 		return
 	}
+	if !services.ValidCoordinates(district.Latitude, district.Longitude) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Latitude and longitude must be given together and in range"})
+		return
+	}
 	db.Save(&district)
 	c.JSON(http.StatusOK, district)
 }
@@ -89,3 +96,8 @@ func DeleteDistrict(c *gin.Context) {
 	}
 	c.JSON(http.StatusNoContent, nil)
 }
+
+// preloadDistrict loads the district of users
+func preloadDistrict(db *gorm.DB) *gorm.DB {
+	return db.Preload("District")
+}
@@ -0,0 +1,190 @@
+// controllers/inventoryLotController.go
+package controllers
+
+import (
+	"errors"
+	"farmers_market_backend/config"
+	"farmers_market_backend/middleware"
+	"farmers_market_backend/models"
+	"farmers_market_backend/services"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// CreateLot receives a harvest lot into the stock of a variant
+func CreateLot(c *gin.Context) {
+	variant, ok := findSellerVariant(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		LotCode         string     `json:"lot_code"`
+		HarvestDate     time.Time  `json:"harvest_date" binding:"required"`
+		BestBefore      *time.Time `json:"best_before"`
+		StorageLocation string     `json:"storage_location"`
+		Quantity        float64    `json:"quantity" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	input.LotCode = strings.TrimSpace(input.LotCode)
+
+	switch {
+	case input.Quantity <= 0:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity must be greater than zero"})
+		return
+	case input.HarvestDate.After(time.Now()):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Harvest date cannot be in the future"})
+		return
+	case input.BestBefore != nil && !input.BestBefore.After(input.HarvestDate):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Best-before date must be after the harvest date"})
+		return
+	}
+	if input.LotCode != "" {
+		var existing int64
+		if err := config.DB.Unscoped().Model(&models.InventoryLot{}).Where("lot_code = ?", input.LotCode).Count(&existing).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create lot"})
+			return
+		}
+		if existing > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Lot code is already in use"})
+			return
+		}
+	}
+
+	userID, _ := middleware.CurrentUserID(c)
+	lot := models.InventoryLot{
+		VariantID:       variant.ID,
+		LotCode:         input.LotCode,
+		HarvestDate:     input.HarvestDate,
+		BestBefore:      input.BestBefore,
+		StorageLocation: input.StorageLocation,
+		Quantity:        input.Quantity,
+	}
+	if err := config.DB.Transaction(func(tx *gorm.DB) error {
+		return services.ReceiveLot(tx, &lot, &userID)
+	}); err != nil {
+		log.Printf("Failed to create lot: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create lot"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"lot": lot})
+}
+
+// GetLots lists the lots of a variant, optionally filtered by status
+func GetLots(c *gin.Context) {
+	variant, ok := findSellerVariant(c)
+	if !ok {
+		return
+	}
+
+	query := config.DB.Where("variant_id = ?", variant.ID).Order("best_before IS NULL, best_before, harvest_date, id")
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var lots []models.InventoryLot
+	if err := query.Find(&lots).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve lots"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"lots": lots})
+}
+
+// UpdateLot updates the storage location and best-before date of a lot, and
+// corrects its remaining quantity after a stock count when one is given
+func UpdateLot(c *gin.Context) {
+	variant, ok := findSellerVariant(c)
+	if !ok {
+		return
+	}
+
+	var lot models.InventoryLot
+	if err := config.DB.Where("variant_id = ?", variant.ID).First(&lot, c.Param("lotID")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Lot not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve lot"})
+		}
+		return
+	}
+
+	var input struct {
+		BestBefore      *time.Time `json:"best_before"`
+		StorageLocation *string    `json:"storage_location"`
+		Remaining       *float64   `json:"remaining"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	if lot.Status == models.LotStatusExpired {
+		c.JSON(http.StatusConflict, gin.H{"error": "Lot has expired and was written off"})
+		return
+	}
+	if input.BestBefore != nil && !input.BestBefore.After(lot.HarvestDate) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Best-before date must be after the harvest date"})
+		return
+	}
+	if input.Remaining != nil && (*input.Remaining < 0 || *input.Remaining > lot.Quantity) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Remaining quantity must be between zero and the quantity received"})
+		return
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		return services.UpdateLot(tx, &lot, services.LotChanges{
+			BestBefore:      input.BestBefore,
+			StorageLocation: input.StorageLocation,
+			Remaining:       input.Remaining,
+		})
+	})
+	if err != nil {
+		if errors.Is(err, services.ErrStockReserved) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Not enough stock would be left for pending orders"})
+			return
+		}
+		log.Printf("Failed to update lot: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update lot"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"lot": lot})
+}
+
+// GetProductStock shows the sellable stock of each variant of a product,
+// counting only lots that have not expired
+func GetProductStock(c *gin.Context) {
+	productID, err := strconv.ParseUint(c.Param("productID"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	var product models.Product
+	if err := config.DB.First(&product, productID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve product"})
+		}
+		return
+	}
+
+	stock, err := services.GetProductStock(config.DB, product.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve stock"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"product_id": product.ID, "variants": stock})
+}
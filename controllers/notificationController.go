@@ -0,0 +1,111 @@
+// controllers/notificationController.go
+package controllers
+
+import (
+	"errors"
+	"farmers_market_backend/config"
+	"farmers_market_backend/middleware"
+	"farmers_market_backend/models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GetNotifications retrieves a page of the notifications of the authenticated
+// user, newest first. Only unread notifications are returned with ?unread=true.
+func GetNotifications(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authorized"})
+		return
+	}
+
+	query := config.DB.Model(&models.Notification{}).Where("user_id = ?", userID)
+	if c.Query("unread") == "true" {
+		query = query.Where("read_at IS NULL")
+	}
+
+	respondPage(c, query, "notifications.id", true, func(notification models.Notification) uint { return notification.ID })
+}
+
+// MarkNotificationRead marks a notification of the authenticated user as read
+func MarkNotificationRead(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authorized"})
+		return
+	}
+
+	var notification models.Notification
+	if err := config.DB.Where("user_id = ?", userID).First(&notification, c.Param("notificationID")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve notification"})
+		}
+		return
+	}
+
+	if notification.ReadAt == nil {
+		now := time.Now()
+		notification.ReadAt = &now
+		if err := config.DB.Model(&notification).Update("read_at", now).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notification"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"notification": notification})
+}
+
+// SubscribeBackInSeason asks for a notification when an out-of-season product
+// is listed again
+func SubscribeBackInSeason(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authorized"})
+		return
+	}
+
+	var product models.Product
+	if err := config.DB.First(&product, c.Param("productID")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve product"})
+		}
+		return
+	}
+	if product.DelistedAt == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Product is in season"})
+		return
+	}
+
+	subscription := models.SeasonSubscription{UserID: userID, ProductID: product.ID}
+	if err := config.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&subscription).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to subscribe"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "You will be notified when the product is back in season"})
+}
+
+// UnsubscribeBackInSeason cancels a back-in-season notification request
+func UnsubscribeBackInSeason(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authorized"})
+		return
+	}
+
+	if err := config.DB.Where("user_id = ? AND product_id = ?", userID, c.Param("productID")).
+		Delete(&models.SeasonSubscription{}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unsubscribe"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Unsubscribed successfully"})
+}
@@ -1,130 +1,283 @@
 package controllers
 
 import (
+	"errors"
 	"farmers_market_backend/config"
+	"farmers_market_backend/middleware"
 	"farmers_market_backend/models"
+	"farmers_market_backend/services"
 	"fmt"
+	"log"
 	"net/http"
-	"time"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
-	"github.com/jinzhu/gorm"
+	"gorm.io/gorm"
 )
 
-// PlaceOrder creates a new order for a product
+// PlaceOrder creates a new order for a single product variant on behalf of the
+// authenticated user. The seller and the price are taken from the product. The
+// variant may be left out for products that have a single variant.
 func PlaceOrder(c *gin.Context) {
-	// CreateOrder creates a new order
-	var order models.Order
-
-	// Bind JSON input to order struct
-	if err := c.ShouldBindJSON(&order); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+	buyerID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authorized"})
 		return
 	}
 
-	// Validate product and users (buyer and seller)
-	if err := validateOrderDependencies(&order); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
+	var input struct {
+		ProductID       uint    `json:"product_id"`
+		VariantID       uint    `json:"variant_id"`
+		Quantity        float64 `json:"quantity"`
+		UnitOfMeasureID uint    `json:"unit_of_measure_id"` // Unit of the quantity, defaults to the unit of the variant
+		PaymentMethod   string  `json:"payment_method"`
+		CouponCode      string  `json:"coupon_code"`
+		DeliveryAddress string  `json:"delivery_address"` // Defaults to the buyer's delivery address
+		DistrictID      uint    `json:"district_id"`      // District to deliver to, defaults to the buyer's district
 	}
 
-	// Fetch the product to calculate delivery date
-	var product models.Product
-	if err := db.First(&product, order.ProductID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+	// Bind JSON input to the input struct
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
 		return
 	}
 
-	// Set OrderDateTime to current time
-	order.OrderDateTime = time.Now()
+	variant, ok := resolveVariant(c, input.ProductID, input.VariantID)
+	if !ok {
+		return
+	}
 
-	// Calculate DeliveryDateTime based on product's delivery rules
-	order.DeliveryDateTime = order.OrderDateTime.Add(time.Duration(product.DeliveryTime) * time.Hour)
+	if input.PaymentMethod == "" {
+		input.PaymentMethod = models.PaymentMethodWallet
+	}
 
-	// Create the order
-	if err := db.Create(&order).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
+	// Create the order with a single order line
+	var orders []models.Order
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		orders, err = services.CreateOrders(tx, buyerID, []services.OrderLine{
+			{VariantID: variant.ID, Quantity: input.Quantity, UnitOfMeasureID: input.UnitOfMeasureID},
+		}, services.CheckoutOptions{
+			PaymentMethod:      input.PaymentMethod,
+			CouponCode:         input.CouponCode,
+			DeliveryAddress:    input.DeliveryAddress,
+			DeliveryDistrictID: input.DistrictID,
+		})
+		return err
+	})
+	if err != nil {
+		respondOrderError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"order": order})
+	c.JSON(http.StatusOK, gin.H{"order": orders[0]})
 }
 
-// validateOrderDependencies checks if the product and users are valid
-func validateOrderDependencies(order *models.Order) error {
-	var product models.Product
-	if err := db.First(&product, order.ProductID).Error; err != nil {
-		return fmt.Errorf("invalid product ID")
+// Checkout converts the cart of the authenticated user into orders, one per
+// seller. Orders are paid from the buyer's wallet unless another payment method
+// is requested, and an optional coupon code is applied to the whole cart.
+func Checkout(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authorized"})
+		return
 	}
 
-	var buyer models.User
-	if err := db.First(&buyer, order.BuyerID).Error; err != nil {
-		return fmt.Errorf("invalid buyer ID")
+	var input struct {
+		PaymentMethod   string `json:"payment_method"`
+		CouponCode      string `json:"coupon_code"`
+		DeliveryAddress string `json:"delivery_address"` // Defaults to the buyer's delivery address
+		DistrictID      uint   `json:"district_id"`      // District to deliver to, defaults to the buyer's district
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
+	}
+	if input.PaymentMethod == "" {
+		input.PaymentMethod = models.PaymentMethodWallet
 	}
 
-	var seller models.User
-	if err := db.First(&seller, order.SellerID).Error; err != nil {
-		return fmt.Errorf("invalid seller ID")
+	var orders []models.Order
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		orders, err = services.CheckoutCart(tx, userID, services.CheckoutOptions{
+			PaymentMethod:      input.PaymentMethod,
+			CouponCode:         input.CouponCode,
+			DeliveryAddress:    input.DeliveryAddress,
+			DeliveryDistrictID: input.DistrictID,
+		})
+		return err
+	})
+	if err != nil {
+		// Let the buyer review the new prices before checking out again
+		var validationErr *services.OrderValidationError
+		if errors.As(err, &validationErr) {
+			if err := services.RefreshCartPrices(config.DB, userID); err != nil {
+				log.Printf("Failed to refresh cart prices: %v", err)
+			}
+		}
+		respondOrderError(c, err)
+		return
 	}
 
-	return nil
+	c.JSON(http.StatusCreated, gin.H{"orders": orders})
+}
+
+// respondOrderError writes the HTTP response for an error returned while creating orders
+func respondOrderError(c *gin.Context, err error) {
+	var validationErr *services.OrderValidationError
+	var stockErr *services.StockConflictError
+	var couponErr *services.CouponError
+	var deliveryErr *services.UndeliverableError
+	switch {
+	case errors.As(err, &validationErr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order", "lines": validationErr.Lines})
+	case errors.As(err, &stockErr):
+		c.JSON(http.StatusConflict, gin.H{"error": "Insufficient stock", "lines": stockErr.Lines})
+	case errors.As(err, &deliveryErr):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Address cannot be delivered to", "sellers": deliveryErr.Sellers})
+	case errors.Is(err, services.ErrInvalidDeliveryDistrict):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid district ID"})
+	case errors.As(err, &couponErr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid coupon", "reason": couponErr.Reason})
+	case errors.Is(err, services.ErrEmptyOrder):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cart is empty"})
+	case errors.Is(err, services.ErrInvalidPaymentMethod):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment method"})
+	case errors.Is(err, services.ErrInsufficientFunds):
+		c.JSON(http.StatusPaymentRequired, gin.H{"error": "Insufficient wallet balance"})
+	default:
+		log.Printf("Failed to create order: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
+	}
 }
 
 // GetOrderDetails retrieves details of a specific order by ID
 func GetOrderDetails(c *gin.Context) {
 	var order models.Order
-	if err := config.DB.Preload("Product").First(&order, c.Param("id")).Error; err != nil {
+	if err := config.DB.Preload("Items.Product").Preload("Items.Variant").Preload("Items.Lots.Lot").Preload("Escrow").Preload("Events", func(db *gorm.DB) *gorm.DB {
+		return db.Order("created_at, id")
+	}).First(&order, c.Param("orderID")).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
 		return
 	}
 	c.JSON(http.StatusOK, order)
 }
 
-// UpdateOrder updates an existing order by ID
+// UpdateOrder changes the status of an existing order by ID. The status change
+// goes through the same rules as the dedicated transition endpoints; prices and
+// other fields of an order cannot be changed once it is placed.
 func UpdateOrder(c *gin.Context) {
-	orderID := c.Param("orderID")
-	var updatedOrder models.Order
+	var input struct {
+		Status string `json:"status" binding:"required"`
+		Note   string `json:"note"`
+	}
 
-	// Bind JSON data to the updatedOrder struct
-	if err := c.ShouldBindJSON(&updatedOrder); err != nil {
+	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
 		return
 	}
 
-	// Find the existing order
-	var order models.Order
-	if err := db.First(&order, orderID).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve order"})
+	changeOrderStatus(c, input.Status, input.Note)
+}
+
+// ConfirmOrder lets the seller accept a pending order
+func ConfirmOrder(c *gin.Context) {
+	transitionOrder(c, models.OrderStatusConfirmed)
+}
+
+// RejectOrder lets the seller decline a pending order
+func RejectOrder(c *gin.Context) {
+	transitionOrder(c, models.OrderStatusRejected)
+}
+
+// PackOrder lets the seller mark a confirmed order as packed
+func PackOrder(c *gin.Context) {
+	transitionOrder(c, models.OrderStatusPacked)
+}
+
+// DispatchOrder lets the seller hand a packed order over for delivery
+func DispatchOrder(c *gin.Context) {
+	transitionOrder(c, models.OrderStatusOutForDelivery)
+}
+
+// DeliverOrder lets the buyer confirm that an order was delivered
+func DeliverOrder(c *gin.Context) {
+	transitionOrder(c, models.OrderStatusDelivered)
+}
+
+// CancelOrder lets the buyer cancel an order before it is packed
+func CancelOrder(c *gin.Context) {
+	transitionOrder(c, models.OrderStatusCancelled)
+}
+
+// RefundOrder lets an admin refund a delivered order
+func RefundOrder(c *gin.Context) {
+	transitionOrder(c, models.OrderStatusRefunded)
+}
+
+// transitionOrder reads an optional note from the request body and moves the
+// order to the given status
+func transitionOrder(c *gin.Context, status string) {
+	var input struct {
+		Note string `json:"note"`
+	}
+
+	// The body is optional for transition endpoints
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
 		}
-		return
 	}
 
-	// Update the order fields
-	order.Status = updatedOrder.Status // Assuming there is a Status field
-	order.TotalPrice = updatedOrder.TotalPrice
-	// Add other fields to update as needed
+	changeOrderStatus(c, status, input.Note)
+}
 
-	// Save the updated order
-	if err := db.Save(&order).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order"})
+// changeOrderStatus applies a status transition on behalf of the authenticated user
+func changeOrderStatus(c *gin.Context, status string, note string) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authorized"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"order": order})
-}
+	orderID, err := strconv.ParseUint(c.Param("orderID"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
 
-// DeleteOrder deletes an order by ID
-func DeleteOrder(c *gin.Context) {
-	orderID := c.Param("orderID")
+	actor := services.OrderActor{
+		UserID:          userID,
+		IsAdmin:         middleware.Can(c, models.PermissionOrdersUpdate),
+		IsDeliveryAgent: middleware.Can(c, models.PermissionOrdersDeliver),
+	}
 
-	// Delete the order by ID
-	if err := db.Delete(&models.Order{}, orderID).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete order"})
+	var order models.Order
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		order, err = services.TransitionOrder(tx, uint(orderID), status, actor, note)
+		return err
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrOrderNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		case errors.Is(err, services.ErrTransitionForbidden):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrInvalidTransition):
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Order cannot move from %s to %s", order.Status, status)})
+		case errors.Is(err, services.ErrInsufficientStock):
+			c.JSON(http.StatusConflict, gin.H{"error": "Not enough sellable stock left in the lots to fulfil the order"})
+		default:
+			log.Printf("Failed to update order status: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order"})
+		}
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Order deleted successfully"})
+	c.JSON(http.StatusOK, gin.H{"order": order})
 }
@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"farmers_market_backend/models"
+	"farmers_market_backend/testutil"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestPlaceOrderConcurrently places more orders at once than there is stock
+// for and checks the orders that find no stock left are refused with 409
+func TestPlaceOrderConcurrently(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := testutil.OpenDB(t)
+	const stock, buyers = 3, 10
+	variant := testutil.CreateVariant(t, db, testutil.CreateUser(t, db, "seller").ID, stock)
+
+	buyerIDs := make([]uint, buyers)
+	for i := range buyerIDs {
+		buyerIDs[i] = testutil.CreateUser(t, db, fmt.Sprintf("buyer%d", i)).ID
+	}
+
+	// Every buyer orders one unit at the same time
+	body := fmt.Sprintf(`{"variant_id": %d, "quantity": 1, "payment_method": %q}`, variant.ID, models.PaymentMethodCashOnDelivery)
+	statuses := make([]int, buyers)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i, buyerID := range buyerIDs {
+		wg.Add(1)
+		go func(i int, buyerID uint) {
+			defer wg.Done()
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			c.Request = httptest.NewRequest(http.MethodPost, "/api/orders/", bytes.NewBufferString(body))
+			c.Request.Header.Set("Content-Type", "application/json")
+			c.Set("user_id", buyerID)
+			<-start
+			PlaceOrder(c)
+			statuses[i] = recorder.Code
+		}(i, buyerID)
+	}
+	close(start)
+	wg.Wait()
+
+	placed, conflicts := 0, 0
+	for _, status := range statuses {
+		switch status {
+		case http.StatusOK:
+			placed++
+		case http.StatusConflict:
+			conflicts++
+		default:
+			t.Errorf("unexpected status %d", status)
+		}
+	}
+	if placed != stock || conflicts != buyers-stock {
+		t.Errorf("placed %d orders with %d conflicts, want %d placed and %d conflicts", placed, conflicts, stock, buyers-stock)
+	}
+
+	if err := db.First(&variant, variant.ID).Error; err != nil {
+		t.Fatalf("load variant: %v", err)
+	}
+	if variant.ReservedStock != stock {
+		t.Errorf("reserved stock = %v, want %v", variant.ReservedStock, stock)
+	}
+	var orders int64
+	if err := db.Model(&models.Order{}).Count(&orders).Error; err != nil {
+		t.Fatalf("count orders: %v", err)
+	}
+	if orders != stock {
+		t.Errorf("%d orders saved, want %d", orders, stock)
+	}
+}
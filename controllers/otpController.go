@@ -0,0 +1,108 @@
+// controllers/otpController.go
+package controllers
+
+import (
+	"errors"
+	"farmers_market_backend/config"
+	"farmers_market_backend/models"
+	"farmers_market_backend/services"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// otpPhoneNumber reads the mobile number of a one-time code request in E.164
+// format. National numbers need the country they belong to. It writes an
+// error response and returns false if the number is invalid.
+func otpPhoneNumber(c *gin.Context, number string, countryID uint) (string, bool) {
+	var country *models.Country
+	if countryID != 0 {
+		country = &models.Country{}
+		if err := config.DB.First(country, countryID).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Country not found"})
+			return "", false
+		}
+	}
+
+	phoneNumber, err := services.NormalizePhoneNumber(number, country)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid mobile number, give it with the country code or a country_id"})
+		return "", false
+	}
+	return phoneNumber, true
+}
+
+// RequestOTP sends a one-time login code by SMS to a mobile number
+func RequestOTP(c *gin.Context) {
+	var input struct {
+		MobileNumber string `json:"mobile_number" binding:"required"`
+		CountryID    uint   `json:"country_id"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	phoneNumber, ok := otpPhoneNumber(c, input.MobileNumber, input.CountryID)
+	if !ok {
+		return
+	}
+
+	if err := services.RequestOTP(config.DB, phoneNumber); err != nil {
+		var rateLimit services.OTPRateLimitError
+		if errors.As(err, &rateLimit) {
+			retryAfter := int(rateLimit.RetryAfter.Seconds()) + 1
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many codes requested, try again later", "retry_after": retryAfter})
+			return
+		}
+		log.Printf("Failed to send one-time code to %s: %v", phoneNumber, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send code"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Code sent", "mobile_number": phoneNumber})
+}
+
+// VerifyOTP logs in with a one-time code sent to a mobile number, signing up
+// a new user if no one has the number yet
+func VerifyOTP(c *gin.Context) {
+	var input struct {
+		MobileNumber string `json:"mobile_number" binding:"required"`
+		CountryID    uint   `json:"country_id"`
+		Code         string `json:"code" binding:"required"`
+		Name         string `json:"name"` // Name of a new user
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	phoneNumber, ok := otpPhoneNumber(c, input.MobileNumber, input.CountryID)
+	if !ok {
+		return
+	}
+
+	user, created, err := services.VerifyOTP(config.DB, phoneNumber, input.Code, input.Name)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidOTP):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired code"})
+		case errors.Is(err, services.ErrOTPAttemptsExceeded):
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many wrong codes, request a new one"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+		}
+		return
+	}
+
+	// Each login starts a session on the client's device
+	pair, err := services.StartSession(config.DB, user, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		log.Printf("Failed to start session: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"token": pair.AccessToken, "refresh_token": pair.RefreshToken, "session_id": pair.SessionID, "created": created})
+}
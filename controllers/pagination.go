@@ -0,0 +1,132 @@
+// controllers/pagination.go
+package controllers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Page sizes of list endpoints
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// pageRequest is the page of a list a client asked for with the cursor,
+// limit and total query parameters
+type pageRequest struct {
+	Limit     int
+	After     uint // ID of the last item of the previous page, 0 for the first page
+	Offset    int  // Number of items on the previous pages, for lists not ordered by ID
+	WithTotal bool
+}
+
+// pageResponse is the envelope of every paginated list response
+type pageResponse struct {
+	Data       interface{} `json:"data"`
+	NextCursor *string     `json:"next_cursor"`     // Pass as ?cursor= to get the next page, nil on the last page
+	Total      *int64      `json:"total,omitempty"` // Number of items on all pages, only with ?total=true
+}
+
+// pageCursor is the position in a list a cursor points after. Cursors are
+// opaque to clients so the position can change without breaking them.
+type pageCursor struct {
+	ID     uint `json:"id,omitempty"`
+	Offset int  `json:"offset,omitempty"`
+}
+
+// parsePageRequest reads the page parameters from the query string
+func parsePageRequest(c *gin.Context) (pageRequest, error) {
+	page := pageRequest{Limit: defaultPageSize, WithTotal: c.Query("total") == "true"}
+
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return page, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		page.Limit = limit
+	}
+
+	if value := c.Query("cursor"); value != "" {
+		data, err := base64.RawURLEncoding.DecodeString(value)
+		var cursor pageCursor
+		if err != nil || json.Unmarshal(data, &cursor) != nil || (cursor.ID == 0 && cursor.Offset <= 0) {
+			return page, fmt.Errorf("invalid cursor")
+		}
+		page.After = cursor.ID
+		page.Offset = cursor.Offset
+	}
+	return page, nil
+}
+
+// encodeCursor returns the cursor of the page after the item with the given ID
+func encodeCursor(id uint) string {
+	data, _ := json.Marshal(pageCursor{ID: id})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// encodeOffsetCursor returns the cursor of the page starting at offset, for
+// lists ranked by something other than ID
+func encodeOffsetCursor(offset int) string {
+	data, _ := json.Marshal(pageCursor{Offset: offset})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// respondPage writes one page of the rows selected by query in the common
+// list envelope. Rows are ordered by idColumn, which keeps pages stable while
+// rows are added; newestFirst pages from the highest ID down. Scopes such as
+// preloads are applied when loading the page but not when counting.
+func respondPage[T any](c *gin.Context, query *gorm.DB, idColumn string, newestFirst bool, id func(T) uint, scopes ...func(*gorm.DB) *gorm.DB) {
+	page, err := parsePageRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := pageResponse{}
+	if page.WithTotal {
+		var total int64
+		if err := query.Session(&gorm.Session{}).Model(new(T)).Count(&total).Error; err != nil {
+			log.Printf("Failed to count list items: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve items"})
+			return
+		}
+		response.Total = &total
+	}
+
+	pageQuery := query.Session(&gorm.Session{}).Scopes(scopes...)
+	if newestFirst {
+		if page.After != 0 {
+			pageQuery = pageQuery.Where(idColumn+" < ?", page.After)
+		}
+		pageQuery = pageQuery.Order(idColumn + " DESC")
+	} else {
+		if page.After != 0 {
+			pageQuery = pageQuery.Where(idColumn+" > ?", page.After)
+		}
+		pageQuery = pageQuery.Order(idColumn)
+	}
+
+	// Load one row more than requested to know whether there is a next page
+	items := []T{}
+	if err := pageQuery.Limit(page.Limit + 1).Find(&items).Error; err != nil {
+		log.Printf("Failed to list items: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve items"})
+		return
+	}
+	if len(items) > page.Limit {
+		items = items[:page.Limit]
+		cursor := encodeCursor(id(items[len(items)-1]))
+		response.NextCursor = &cursor
+	}
+	response.Data = items
+
+	c.JSON(http.StatusOK, response)
+}
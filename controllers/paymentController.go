@@ -0,0 +1,99 @@
+// controllers/paymentController.go
+package controllers
+
+import (
+	"errors"
+	"farmers_market_backend/config"
+	"farmers_market_backend/middleware"
+	"farmers_market_backend/models"
+	"farmers_market_backend/services"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PaymentWebhook receives signed payment callbacks from the payment gateway
+func PaymentWebhook(c *gin.Context) {
+	payload, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payload"})
+		return
+	}
+
+	topUp, err := services.HandleTopUpCallback(config.DB, payload, c.GetHeader("X-Signature"))
+	if err != nil {
+		respondPaymentError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": topUp.Status})
+}
+
+// CompleteMockPayment lets a developer complete one of their own payments at
+// the mock gateway, which is only routed when PAYMENT_GATEWAY is "mock". The
+// mock gateway signs a callback that is processed like a real webhook.
+func CompleteMockPayment(c *gin.Context) {
+	gateway, ok := services.CurrentPaymentGateway().(*services.MockGateway)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Mock gateway is not enabled"})
+		return
+	}
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authorized"})
+		return
+	}
+
+	var input struct {
+		Status string `json:"status" binding:"required,oneof=succeeded failed"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	// Only the user paying for a top-up can complete its payment
+	var count int64
+	if err := config.DB.Model(&models.TopUp{}).Where("gateway_reference = ? AND user_id = ?", c.Param("reference"), userID).
+		Count(&count).Error; err != nil {
+		respondPaymentError(c, err)
+		return
+	}
+	if count == 0 {
+		respondPaymentError(c, services.ErrTopUpNotFound)
+		return
+	}
+
+	payload, signature, err := gateway.SimulatePayment(c.Param("reference"), input.Status)
+	if err != nil {
+		respondPaymentError(c, err)
+		return
+	}
+
+	topUp, err := services.HandleTopUpCallback(config.DB, payload, signature)
+	if err != nil {
+		respondPaymentError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"top_up": topUp})
+}
+
+// respondPaymentError writes the HTTP response for an error returned while handling a payment callback
+func respondPaymentError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidSignature):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid signature"})
+	case errors.Is(err, services.ErrTopUpNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+	case errors.Is(err, services.ErrNoPaymentGateway):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Payments are not enabled"})
+	case errors.Is(err, services.ErrPaymentMismatch):
+		c.JSON(http.StatusConflict, gin.H{"error": "Payment does not match top-up"})
+	default:
+		log.Printf("Failed to handle payment callback: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to handle payment"})
+	}
+}
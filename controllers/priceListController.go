@@ -0,0 +1,135 @@
+// controllers/priceListController.go
+package controllers
+
+import (
+	"farmers_market_backend/config"
+	"farmers_market_backend/models"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// SetPriceTiers replaces the quantity price tiers of a product variant
+func SetPriceTiers(c *gin.Context) {
+	variant, ok := findSellerVariant(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Tiers []struct {
+			MinQuantity float64      `json:"min_quantity"`
+			Price       models.Money `json:"price"`
+		} `json:"tiers"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	tiers := make([]models.ProductPriceTier, 0, len(input.Tiers))
+	seen := map[float64]bool{}
+	for _, tier := range input.Tiers {
+		if tier.MinQuantity <= 0 || seen[tier.MinQuantity] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Each tier needs a distinct positive minimum quantity"})
+			return
+		}
+		if !isValidAmount(tier.Price) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Price must be a positive " + models.DefaultCurrency + " amount"})
+			return
+		}
+		seen[tier.MinQuantity] = true
+		tiers = append(tiers, models.ProductPriceTier{VariantID: variant.ID, MinQuantity: tier.MinQuantity, Price: tier.Price})
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("variant_id = ?", variant.ID).Delete(&models.ProductPriceTier{}).Error; err != nil {
+			return err
+		}
+		if len(tiers) == 0 {
+			return nil
+		}
+		return tx.Create(&tiers).Error
+	})
+	if err != nil {
+		log.Printf("Failed to save price tiers: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save price tiers"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"price_tiers": tiers})
+}
+
+// SetGroupPrices replaces the buyer group price list entries of a product variant
+func SetGroupPrices(c *gin.Context) {
+	variant, ok := findSellerVariant(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Prices []struct {
+			BuyerGroupID uint         `json:"buyer_group_id"`
+			MinQuantity  float64      `json:"min_quantity"`
+			Price        models.Money `json:"price"`
+		} `json:"prices"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	prices := make([]models.BuyerGroupPrice, 0, len(input.Prices))
+	type groupQuantity struct {
+		groupID     uint
+		minQuantity float64
+	}
+	seen := map[groupQuantity]bool{}
+	for _, entry := range input.Prices {
+		key := groupQuantity{entry.BuyerGroupID, entry.MinQuantity}
+		if entry.MinQuantity < 0 || seen[key] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Each group price needs a distinct minimum quantity that is not negative"})
+			return
+		}
+		if !isValidAmount(entry.Price) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Price must be a positive " + models.DefaultCurrency + " amount"})
+			return
+		}
+		var group models.BuyerGroup
+		if err := config.DB.First(&group, entry.BuyerGroupID).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid buyer group ID"})
+			return
+		}
+		seen[key] = true
+		prices = append(prices, models.BuyerGroupPrice{
+			BuyerGroupID: entry.BuyerGroupID,
+			VariantID:    variant.ID,
+			MinQuantity:  entry.MinQuantity,
+			Price:        entry.Price,
+		})
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("variant_id = ?", variant.ID).Delete(&models.BuyerGroupPrice{}).Error; err != nil {
+			return err
+		}
+		if len(prices) == 0 {
+			return nil
+		}
+		return tx.Create(&prices).Error
+	})
+	if err != nil {
+		log.Printf("Failed to save group prices: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save group prices"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"group_prices": prices})
+}
+
+// orderByMinQuantity sorts preloaded price tiers and group prices by quantity
+func orderByMinQuantity(db *gorm.DB) *gorm.DB {
+	return db.Order("min_quantity")
+}
@@ -2,16 +2,28 @@
 package controllers
 
 import (
+	"errors"
+	"farmers_market_backend/config"
+	"farmers_market_backend/middleware"
 	"farmers_market_backend/models"
-	"fmt"
+	"farmers_market_backend/services"
+	"log"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
-// CreateProduct creates a new product
+// CreateProduct creates a new product with at least one variant. The product
+// is sold by the authenticated user unless an admin names another seller.
 func CreateProduct(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authorized"})
+		return
+	}
+
 	var product models.Product
 
 	// Bind JSON input to product struct
@@ -19,9 +31,41 @@ func CreateProduct(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
 		return
 	}
+	if product.SellerID == 0 || !middleware.Can(c, models.PermissionProductsUpdate) {
+		product.SellerID = userID
+	}
+
+	// Out-of-season products are hidden by the season scheduler
+	product.DelistedAt = nil
+	product.SeasonNoticeAt = nil
 
-	// Create the product
-	if err := db.Create(&product).Error; err != nil {
+	if len(product.Variants) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A product needs at least one variant"})
+		return
+	}
+	for i := range product.Variants {
+		if err := validateVariant(&product.Variants[i]); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	// Create the product and make it searchable
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := validateProductDependencies(tx, &product); err != nil {
+			return err
+		}
+		if err := tx.Create(&product).Error; err != nil {
+			return err
+		}
+		return services.RefreshSearchText(tx, "id = ?", product.ID)
+	})
+	var dependencyErr *productDependencyError
+	if errors.As(err, &dependencyErr) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create product"})
 		return
 	}
@@ -31,10 +75,10 @@ func CreateProduct(c *gin.Context) {
 
 // GetProduct retrieves a product by ID
 func GetProduct(c *gin.Context) {
-	id := c.Param("id")
+	id := c.Param("productID")
 	var product models.Product
 
-	if err := db.Preload("Category").Preload("UnitOfMeasure").Preload("Seller").First(&product, id).Error; err != nil {
+	if err := config.DB.Preload("Category").Preload("Seller").Scopes(preloadVariants).First(&product, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
 		return
 	}
@@ -42,16 +86,11 @@ func GetProduct(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"product": product})
 }
 
-// GetAllProducts retrieves all products
+// GetAllProducts retrieves a page of the products that are in season, newest first
 func GetAllProducts(c *gin.Context) {
-	var products []models.Product
-
-	if err := db.Preload("Category").Preload("UnitOfMeasure").Preload("Seller").Find(&products).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve products"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{"products": products})
+	query := config.DB.Model(&models.Product{}).Scopes(services.ListedProducts)
+	respondPage(c, query, "products.id", true, func(product models.Product) uint { return product.ID },
+		func(db *gorm.DB) *gorm.DB { return db.Preload("Category").Preload("Seller").Scopes(preloadVariants) })
 }
 
 // UpdateProduct updates an existing product
@@ -66,7 +105,7 @@ func UpdateProduct(c *gin.Context) {
 
 	// Check if the product exists
 	var product models.Product
-	if err := db.First(&product, productID).Error; err != nil {
+	if err := config.DB.First(&product, productID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
 		} else {
@@ -75,30 +114,52 @@ func UpdateProduct(c *gin.Context) {
 		return
 	}
 
-	// Validate seller, category, and unit of measure
-	if err := validateProductDependencies(&updatedProduct); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	// Update product fields
+	// Update product fields, variants are updated through their own endpoints
 	product.Name = updatedProduct.Name
 	product.Description = updatedProduct.Description
-	product.Price = updatedProduct.Price
 	product.Discount = updatedProduct.Discount
 	product.IsPromoSale = updatedProduct.IsPromoSale
+	if !sameTime(product.SeasonExpiryDate, updatedProduct.SeasonExpiryDate) {
+		product.SeasonNoticeAt = nil // Warn the seller again before the new end of season
+	}
 	product.SeasonExpiryDate = updatedProduct.SeasonExpiryDate
-	product.Stock = updatedProduct.Stock
+	product.SeasonStartDate = updatedProduct.SeasonStartDate
 	product.CategoryID = updatedProduct.CategoryID
-	product.UnitOfMeasureID = updatedProduct.UnitOfMeasureID
 	product.ImageURL = updatedProduct.ImageURL
 	product.VideoURL = updatedProduct.VideoURL
-	product.Min_order_qty = updatedProduct.Min_order_qty
-	product.SellerID = updatedProduct.SellerID // Update seller ID if necessary
+	if updatedProduct.SellerID != 0 && middleware.Can(c, models.PermissionProductsUpdate) {
+		product.SellerID = updatedProduct.SellerID // Only admins can hand a product to another seller
+	}
 	product.DeliveryTime = updatedProduct.DeliveryTime
 	product.DeliveryTimeRules = updatedProduct.DeliveryTimeRules
 
-	if err := db.Save(&product).Error; err != nil {
+	// List or delist the product right away if the new season dates call for it
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := validateProductDependencies(tx, &product); err != nil {
+			return err
+		}
+		if err := tx.Save(&product).Error; err != nil {
+			return err
+		}
+		if err := services.RefreshSearchText(tx, "id = ?", product.ID); err != nil {
+			return err
+		}
+		now := time.Now()
+		switch inSeason := services.InSeason(product, now); {
+		case product.DelistedAt != nil && inSeason:
+			return services.RelistProduct(tx, &product, now)
+		case product.DelistedAt == nil && !inSeason:
+			return services.DelistProduct(tx, &product, now)
+		}
+		return nil
+	})
+	var dependencyErr *productDependencyError
+	if errors.As(err, &dependencyErr) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to update product: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
 		return
 	}
@@ -110,7 +171,7 @@ func UpdateProduct(c *gin.Context) {
 func DeleteProduct(c *gin.Context) {
 	productID := c.Param("productID")
 
-	if err := db.Delete(&models.Product{}, productID).Error; err != nil {
+	if err := config.DB.Delete(&models.Product{}, productID).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete product"})
 		return
 	}
@@ -118,31 +179,41 @@ func DeleteProduct(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Product deleted successfully"})
 }
 
+// productDependencyError is returned when the seller or category of a
+// product does not exist
+type productDependencyError struct {
+	dependency string
+}
+
+func (e *productDependencyError) Error() string {
+	return e.dependency + " not found"
+}
+
 // validateProductDependencies checks if the related entities exist
-func validateProductDependencies(product *models.Product) error {
+func validateProductDependencies(tx *gorm.DB, product *models.Product) error {
 	var seller models.User
-	if err := db.First(&seller, product.SellerID).Error; err != nil {
+	if err := tx.First(&seller, product.SellerID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return fmt.Errorf("seller not found")
+			return &productDependencyError{dependency: "seller"}
 		}
 		return err
 	}
 
 	var category models.Category
-	if err := db.First(&category, product.CategoryID).Error; err != nil {
+	if err := tx.First(&category, product.CategoryID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return fmt.Errorf("category not found")
-		}
-		return err
-	}
-
-	var unit models.UnitOfMeasure
-	if err := db.First(&unit, product.UnitOfMeasureID).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return fmt.Errorf("unit of measure not found")
+			return &productDependencyError{dependency: "category"}
 		}
 		return err
 	}
 
 	return nil
 }
+
+// sameTime reports whether two optional dates are equal
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"farmers_market_backend/models"
+	"farmers_market_backend/testutil"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGetProduct(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := testutil.OpenDB(t)
+	variant := testutil.CreateVariant(t, db, testutil.CreateUser(t, db, "seller").ID, 5)
+	tier := models.ProductPriceTier{VariantID: variant.ID, MinQuantity: 10, Price: models.NewMoney(4500, models.DefaultCurrency)}
+	if err := db.Create(&tier).Error; err != nil {
+		t.Fatalf("create price tier: %v", err)
+	}
+
+	router := gin.New()
+	router.GET("/api/products/:productID", GetProduct)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/products/%d", variant.ProductID), nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", recorder.Code, recorder.Body)
+	}
+	var response struct {
+		Product models.Product `json:"product"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if response.Product.ID != variant.ProductID || len(response.Product.Variants) != 1 {
+		t.Fatalf("product = %+v, want product %d with one variant", response.Product, variant.ProductID)
+	}
+	if tiers := response.Product.Variants[0].PriceTiers; len(tiers) != 1 || tiers[0].MinQuantity != 10 {
+		t.Errorf("price tiers = %+v, want the tier from 10", tiers)
+	}
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/products/999", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("status of a missing product = %d, want 404", recorder.Code)
+	}
+}
+
+func TestUpdateProductRelistsProductBackInSeason(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := testutil.OpenDB(t)
+	seller := testutil.CreateUser(t, db, "seller")
+	variant := testutil.CreateVariant(t, db, seller.ID, 5)
+	var product models.Product
+	if err := db.First(&product, variant.ProductID).Error; err != nil {
+		t.Fatalf("load product: %v", err)
+	}
+	ended := time.Now().AddDate(0, 0, -1)
+	if err := db.Model(&product).Updates(map[string]interface{}{"season_expiry_date": ended, "delisted_at": ended}).Error; err != nil {
+		t.Fatalf("delist product: %v", err)
+	}
+
+	router := gin.New()
+	router.PUT("/api/products/:productID", func(c *gin.Context) { c.Set("user_id", seller.ID) }, UpdateProduct)
+
+	body, _ := json.Marshal(gin.H{
+		"name":               product.Name,
+		"category_id":        product.CategoryID,
+		"seller_id":          seller.ID,
+		"season_expiry_date": time.Now().AddDate(0, 3, 0),
+	})
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPut, fmt.Sprintf("/api/products/%d", product.ID), bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", recorder.Code, recorder.Body)
+	}
+
+	var updated models.Product
+	if err := db.First(&updated, product.ID).Error; err != nil {
+		t.Fatalf("load product: %v", err)
+	}
+	if updated.DelistedAt != nil {
+		t.Error("product extended into a new season is still delisted")
+	}
+}
+
+func TestProductSellerComesFromTheAuthenticatedUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := testutil.OpenDB(t)
+	seller := testutil.CreateUser(t, db, "seller")
+	other := testutil.CreateUser(t, db, "other")
+	variant := testutil.CreateVariant(t, db, seller.ID, 5)
+	var product models.Product
+	if err := db.First(&product, variant.ProductID).Error; err != nil {
+		t.Fatalf("load product: %v", err)
+	}
+
+	asSeller := func(c *gin.Context) {
+		c.Set("user_id", seller.ID)
+		c.Set("roles", []string{models.RoleSeller, models.RoleBuyer})
+	}
+	router := gin.New()
+	router.POST("/api/products/", asSeller, CreateProduct)
+	router.PUT("/api/products/:productID", asSeller, UpdateProduct)
+	send := func(method, path string, body gin.H) {
+		t.Helper()
+		encoded, _ := json.Marshal(body)
+		request := httptest.NewRequest(method, path, bytes.NewReader(encoded))
+		request.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("%s %s: status = %d, want 200: %s", method, path, recorder.Code, recorder.Body)
+		}
+	}
+
+	send(http.MethodPut, fmt.Sprintf("/api/products/%d", product.ID), gin.H{
+		"name": product.Name, "category_id": product.CategoryID, "seller_id": other.ID,
+	})
+	send(http.MethodPost, "/api/products/", gin.H{
+		"name": "Onions", "category_id": product.CategoryID, "seller_id": other.ID,
+		"variants": []gin.H{{"sku": "ONION-1KG", "price": "40.00", "unit_of_measure_id": variant.UnitOfMeasureID, "min_order_qty": 1}},
+	})
+
+	var owned int64
+	if err := db.Model(&models.Product{}).Where("seller_id = ?", other.ID).Count(&owned).Error; err != nil {
+		t.Fatalf("count products: %v", err)
+	}
+	if owned != 0 {
+		t.Errorf("a seller listed or handed %d products to another seller", owned)
+	}
+}
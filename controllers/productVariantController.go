@@ -0,0 +1,200 @@
+// controllers/productVariantController.go
+package controllers
+
+import (
+	"errors"
+	"farmers_market_backend/config"
+	"farmers_market_backend/models"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// CreateVariant adds a variant to a product
+func CreateVariant(c *gin.Context) {
+	product, ok := findSellerProduct(c)
+	if !ok {
+		return
+	}
+
+	var variant models.ProductVariant
+	if err := c.ShouldBindJSON(&variant); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	variant.ProductID = product.ID
+	if err := validateVariant(&variant); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := config.DB.Create(&variant).Error; err != nil {
+		log.Printf("Failed to create variant: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create variant"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"variant": variant})
+}
+
+// UpdateVariant updates a variant of a product. Stock is kept, as it is only
+// changed by inventory lots and orders.
+func UpdateVariant(c *gin.Context) {
+	variant, ok := findSellerVariant(c)
+	if !ok {
+		return
+	}
+
+	var input models.ProductVariant
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	input.ID = variant.ID
+	if err := validateVariant(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	variant.SKU = input.SKU
+	variant.Name = input.Name
+	variant.Grade = input.Grade
+	variant.Size = input.Size
+	variant.Pack = input.Pack
+	variant.Price = input.Price
+	variant.UnitOfMeasureID = input.UnitOfMeasureID
+	variant.MinOrderQty = input.MinOrderQty
+	variant.UnitWeightKg = input.UnitWeightKg
+
+	if err := config.DB.Save(&variant).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update variant"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"variant": variant})
+}
+
+// DeleteVariant deletes a variant of a product. The last variant of a product
+// and variants with reserved stock cannot be deleted.
+func DeleteVariant(c *gin.Context) {
+	variant, ok := findSellerVariant(c)
+	if !ok {
+		return
+	}
+
+	var count int64
+	if err := config.DB.Model(&models.ProductVariant{}).Where("product_id = ?", variant.ProductID).Count(&count).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete variant"})
+		return
+	}
+	if count <= 1 {
+		c.JSON(http.StatusConflict, gin.H{"error": "A product needs at least one variant"})
+		return
+	}
+	if variant.ReservedStock > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Variant has stock reserved by pending orders"})
+		return
+	}
+
+	if err := config.DB.Delete(&variant).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete variant"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Variant deleted successfully"})
+}
+
+// validateVariant checks a variant sent by a seller and resets the fields
+// sellers cannot set directly
+func validateVariant(variant *models.ProductVariant) error {
+	// Stock is received through inventory lots and reserved by orders, prices
+	// are managed through their own endpoints
+	variant.Stock = 0
+	variant.ReservedStock = 0
+	variant.PriceTiers = nil
+	variant.GroupPrices = nil
+	variant.Product = nil
+
+	variant.SKU = strings.TrimSpace(variant.SKU)
+	if variant.SKU == "" {
+		return fmt.Errorf("sku is required")
+	}
+	if !isValidAmount(variant.Price) {
+		return fmt.Errorf("price must be a positive %s amount", models.DefaultCurrency)
+	}
+	if variant.MinOrderQty < 0 {
+		return fmt.Errorf("minimum order quantity cannot be negative")
+	}
+	if variant.UnitWeightKg < 0 {
+		return fmt.Errorf("unit weight cannot be negative")
+	}
+
+	var existing int64
+	if err := config.DB.Unscoped().Model(&models.ProductVariant{}).
+		Where("sku = ? AND id <> ?", variant.SKU, variant.ID).Count(&existing).Error; err != nil {
+		return err
+	}
+	if existing > 0 {
+		return fmt.Errorf("sku %s is already in use", variant.SKU)
+	}
+
+	var unit models.UnitOfMeasure
+	if err := config.DB.First(&unit, variant.UnitOfMeasureID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("unit of measure not found")
+		}
+		return err
+	}
+
+	return nil
+}
+
+// findSellerProduct loads the product in the URL, writing an error response
+// if it does not exist. The route checks the user may manage the product.
+func findSellerProduct(c *gin.Context) (models.Product, bool) {
+	var product models.Product
+	if err := config.DB.First(&product, c.Param("productID")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve product"})
+		}
+		return product, false
+	}
+
+	return product, true
+}
+
+// findSellerVariant loads the variant in the URL if it belongs to the product
+// in the URL, writing an error response otherwise
+func findSellerVariant(c *gin.Context) (models.ProductVariant, bool) {
+	var variant models.ProductVariant
+	product, ok := findSellerProduct(c)
+	if !ok {
+		return variant, false
+	}
+
+	if err := config.DB.Where("product_id = ?", product.ID).First(&variant, c.Param("variantID")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product variant not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve product variant"})
+		}
+		return variant, false
+	}
+
+	return variant, true
+}
+
+// preloadVariants loads the variants of products with their unit of measure and prices
+func preloadVariants(db *gorm.DB) *gorm.DB {
+	return db.Preload("Variants", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).Preload("Variants.UnitOfMeasure").
+		Preload("Variants.PriceTiers", orderByMinQuantity).
+		Preload("Variants.GroupPrices", orderByMinQuantity)
+}
@@ -2,14 +2,22 @@
 package controllers
 
 import (
+	"farmers_market_backend/config"
+	"farmers_market_backend/middleware"
 	"farmers_market_backend/models"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 )
 
-// CreateReview handles creating a new review
+// CreateReview handles creating a new review by the authenticated user
 func CreateReview(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authorized"})
+		return
+	}
+
 	var review models.Review
 
 	// Bind JSON to the review struct
@@ -17,9 +25,11 @@ func CreateReview(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
 		return
 	}
+	review.ID = 0
+	review.UserID = userID
 
 	// Save the review in the database
-	if err := db.Create(&review).Error; err != nil {
+	if err := config.DB.Create(&review).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create review"})
 		return
 	}
@@ -27,39 +37,39 @@ func CreateReview(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Review created successfully", "review": review})
 }
 
-// GetReviews retrieves all reviews for a specific product
+// GetReviews retrieves a page of reviews for a specific product, newest first
 func GetReviews(c *gin.Context) {
-	var reviews []models.Review
 	productID := c.Param("productID")
 
-	// Find all reviews for a specific product
-	if err := db.Where("product_id = ?", productID).Find(&reviews).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve reviews"})
-		return
-	}
-
-	c.JSON(http.StatusOK, reviews)
+	respondPage(c, config.DB.Model(&models.Review{}).Where("product_id = ?", productID), "reviews.id", true,
+		func(review models.Review) uint { return review.ID })
 }
 
-// UpdateReview handles updating an existing review
+// UpdateReview handles updating the rating and comment of an existing review
 func UpdateReview(c *gin.Context) {
 	var review models.Review
 	reviewID := c.Param("reviewID")
 
 	// Find the review by ID
-	if err := db.First(&review, reviewID).Error; err != nil {
+	if err := config.DB.First(&review, reviewID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Review not found"})
 		return
 	}
 
-	// Bind JSON to the review struct
-	if err := c.ShouldBindJSON(&review); err != nil {
+	// The product and author of a review stay the same
+	var input struct {
+		Rating  int    `json:"rating"`
+		Comment string `json:"comment"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
 		return
 	}
+	review.Rating = input.Rating
+	review.Comment = input.Comment
 
 	// Update the review in the database
-	if err := db.Save(&review).Error; err != nil {
+	if err := config.DB.Save(&review).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update review"})
 		return
 	}
@@ -72,7 +82,7 @@ func DeleteReview(c *gin.Context) {
 	reviewID := c.Param("reviewID")
 
 	// Delete the review by ID
-	if err := db.Delete(&models.Review{}, reviewID).Error; err != nil {
+	if err := config.DB.Delete(&models.Review{}, reviewID).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete review"})
 		return
 	}
@@ -0,0 +1,82 @@
+// controllers/roleController.go
+package controllers
+
+import (
+	"errors"
+	"farmers_market_backend/config"
+	"farmers_market_backend/middleware"
+	"farmers_market_backend/models"
+	"farmers_market_backend/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// GetRoles lists the roles, most privileged first, with the permissions each grants
+func GetRoles(c *gin.Context) {
+	type roleView struct {
+		Role        string   `json:"role"`
+		Permissions []string `json:"permissions"`
+	}
+	roles := make([]roleView, 0, len(models.Roles))
+	for _, role := range models.Roles {
+		roles = append(roles, roleView{Role: role, Permissions: models.RolePermissions[role]})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"roles": roles})
+}
+
+// GetUserRoles gets the roles held by a user
+func GetUserRoles(c *gin.Context) {
+	var user models.User
+	if err := config.DB.Preload("Roles").First(&user, c.Param("userID")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"user_id": user.ID, "roles": user.RoleNames()})
+}
+
+// SetUserRoles replaces the roles of a user. Admins cannot take the admin
+// role away from themselves, so the marketplace is never left without one.
+func SetUserRoles(c *gin.Context) {
+	var input struct {
+		Roles []string `json:"roles"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	userID, err := strconv.ParseUint(c.Param("userID"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	currentID, _ := middleware.CurrentUserID(c)
+	if uint(userID) == currentID && containsString(middleware.CurrentRoles(c), models.RoleAdmin) && !containsString(input.Roles, models.RoleAdmin) {
+		c.JSON(http.StatusConflict, gin.H{"error": "You cannot remove your own admin role"})
+		return
+	}
+
+	user, err := services.SetUserRoles(config.DB, uint(userID), input.Roles)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrUnknownRole):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role, must be one of the roles listed at /api/admin/roles"})
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign roles"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"user_id": user.ID, "roles": user.RoleNames()})
+}
@@ -0,0 +1,209 @@
+// controllers/searchController.go
+package controllers
+
+import (
+	"errors"
+	"farmers_market_backend/config"
+	"farmers_market_backend/models"
+	"farmers_market_backend/services"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// SearchProducts searches in-season products by keyword with filters, facet
+// counts and sorting. Query parameters:
+//
+//	q              keywords matched against name, description, category and seller
+//	category_id    comma-separated category IDs
+//	district_id    comma-separated district IDs of the seller
+//	min_price      lowest variant price, e.g. 20.50
+//	max_price      highest variant price
+//	promo          true for products on promotional sale only
+//	in_stock       true for products that can be ordered only
+//	min_rating     minimum rating
+//	sort           relevance, price_asc, price_desc, rating, newest or delivery_time
+//	page, limit    page number from 1 and page size up to 100
+func SearchProducts(c *gin.Context) {
+	search, err := parseProductSearch(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := services.SearchProducts(config.DB, search)
+	if err != nil {
+		log.Printf("Failed to search products: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search products"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// parseProductSearch reads the search parameters from the query string
+func parseProductSearch(c *gin.Context) (services.ProductSearch, error) {
+	search := services.ProductSearch{
+		Query:     strings.TrimSpace(c.Query("q")),
+		PromoOnly: c.Query("promo") == "true",
+		InStock:   c.Query("in_stock") == "true",
+		Sort:      c.DefaultQuery("sort", services.SortRelevance),
+	}
+
+	var err error
+	if search.CategoryIDs, err = parseIDList(c.Query("category_id")); err != nil {
+		return search, fmt.Errorf("category_id must be a comma-separated list of IDs")
+	}
+	if search.DistrictIDs, err = parseIDList(c.Query("district_id")); err != nil {
+		return search, fmt.Errorf("district_id must be a comma-separated list of IDs")
+	}
+	for _, bound := range []struct {
+		param string
+		price **models.Money
+	}{{"min_price", &search.MinPrice}, {"max_price", &search.MaxPrice}} {
+		if value := c.Query(bound.param); value != "" {
+			price, err := models.ParseMoney(value, models.DefaultCurrency)
+			if err != nil || price.Minor < 0 {
+				return search, fmt.Errorf("%s must be a %s amount", bound.param, models.DefaultCurrency)
+			}
+			*bound.price = &price
+		}
+	}
+	if value := c.Query("min_rating"); value != "" {
+		if search.MinRating, err = strconv.ParseFloat(value, 64); err != nil || search.MinRating < 0 || search.MinRating > 5 {
+			return search, fmt.Errorf("min_rating must be between 0 and 5")
+		}
+	}
+	if !containsSort(search.Sort) {
+		return search, fmt.Errorf("sort must be one of %s", strings.Join(services.SearchSorts, ", "))
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		return search, fmt.Errorf("page must be a positive number")
+	}
+	search.Limit, err = strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || search.Limit < 1 || search.Limit > 100 {
+		return search, fmt.Errorf("limit must be between 1 and 100")
+	}
+	search.Offset = (page - 1) * search.Limit
+	return search, nil
+}
+
+// GetNearbyProducts finds in-season products near the buyer, nearest first,
+// in the common list envelope. Query parameters:
+//
+//	lat, lng       the buyer's location
+//	district_id    the buyer's district, used when lat and lng are not given
+//	               and to rank sellers without coordinates
+//	radius_km      how far to look, 25 by default and up to 500
+//	category_id    comma-separated category IDs
+//
+// Sellers without coordinates on their farm or district follow the ones with
+// a distance: first those in the buyer's district, then those in its country.
+func GetNearbyProducts(c *gin.Context) {
+	search, err := parseNearbySearch(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	page, err := parsePageRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	search.Limit, search.Offset = page.Limit, page.Offset
+
+	result, err := services.FindNearbyProducts(config.DB, search)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrNoLocation):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "lat and lng or district_id are required"})
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "District not found"})
+		default:
+			log.Printf("Failed to find nearby products: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find nearby products"})
+		}
+		return
+	}
+
+	response := pageResponse{Data: result.Products}
+	if next := search.Offset + len(result.Products); int64(next) < result.Total {
+		cursor := encodeOffsetCursor(next)
+		response.NextCursor = &cursor
+	}
+	if page.WithTotal {
+		response.Total = &result.Total
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// parseNearbySearch reads the location parameters from the query string
+func parseNearbySearch(c *gin.Context) (services.NearbySearch, error) {
+	search := services.NearbySearch{RadiusKm: 25}
+
+	for _, coordinate := range []struct {
+		param string
+		value **float64
+	}{{"lat", &search.Latitude}, {"lng", &search.Longitude}} {
+		if value := c.Query(coordinate.param); value != "" {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return search, fmt.Errorf("%s must be a number", coordinate.param)
+			}
+			*coordinate.value = &parsed
+		}
+	}
+	if !services.ValidCoordinates(search.Latitude, search.Longitude) {
+		return search, fmt.Errorf("lat and lng must be given together, lat between -90 and 90 and lng between -180 and 180")
+	}
+
+	var err error
+	if value := c.Query("district_id"); value != "" {
+		districtID, err := strconv.ParseUint(value, 10, 64)
+		if err != nil || districtID == 0 {
+			return search, fmt.Errorf("district_id must be an ID")
+		}
+		search.DistrictID = uint(districtID)
+	}
+	if value := c.Query("radius_km"); value != "" {
+		if search.RadiusKm, err = strconv.ParseFloat(value, 64); err != nil || search.RadiusKm <= 0 || search.RadiusKm > 500 {
+			return search, fmt.Errorf("radius_km must be greater than 0 and at most 500")
+		}
+	}
+	if search.CategoryIDs, err = parseIDList(c.Query("category_id")); err != nil {
+		return search, fmt.Errorf("category_id must be a comma-separated list of IDs")
+	}
+	return search, nil
+}
+
+// parseIDList parses a comma-separated list of IDs such as "3,7"
+func parseIDList(value string) ([]uint, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var ids []uint
+	for _, part := range strings.Split(value, ",") {
+		id, err := strconv.ParseUint(strings.TrimSpace(part), 10, 64)
+		if err != nil || id == 0 {
+			return nil, fmt.Errorf("invalid ID %q", part)
+		}
+		ids = append(ids, uint(id))
+	}
+	return ids, nil
+}
+
+func containsSort(sort string) bool {
+	for _, s := range services.SearchSorts {
+		if s == sort {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+// controllers/sessionController.go
+package controllers
+
+import (
+	"errors"
+	"farmers_market_backend/config"
+	"farmers_market_backend/middleware"
+	"farmers_market_backend/models"
+	"farmers_market_backend/services"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Logout ends the session of the access token, so neither it nor the
+// session's refresh token can be used again
+func Logout(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	sessionID, hasSession := middleware.CurrentSessionID(c)
+	if !ok || !hasSession {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authorized"})
+		return
+	}
+
+	if err := services.RevokeSession(config.DB, userID, sessionID, models.SessionRevokedLogout); err != nil && !errors.Is(err, services.ErrSessionNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
+
+// LogoutAll ends every session of the authenticated user, on all devices
+func LogoutAll(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authorized"})
+		return
+	}
+
+	if err := services.RevokeAllSessions(config.DB, userID, models.SessionRevokedLogoutAll); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out on all devices"})
+}
+
+// GetSessions lists the active sessions of the authenticated user, most
+// recently used first. The session making the request has current set.
+func GetSessions(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authorized"})
+		return
+	}
+	currentID, _ := middleware.CurrentSessionID(c)
+
+	var sessions []models.Session
+	if err := config.DB.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_used_at DESC").Find(&sessions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve sessions"})
+		return
+	}
+
+	type sessionView struct {
+		models.Session
+		Current bool `json:"current"`
+	}
+	views := make([]sessionView, 0, len(sessions))
+	for _, session := range sessions {
+		views = append(views, sessionView{Session: session, Current: session.ID == currentID})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": views})
+}
+
+// RevokeSession ends one session of the authenticated user, such as a lost device
+func RevokeSession(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authorized"})
+		return
+	}
+	sessionID, err := strconv.ParseUint(c.Param("sessionID"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	if err := services.RevokeSession(config.DB, userID, uint(sessionID), models.SessionRevokedByUser); err != nil {
+		if errors.Is(err, services.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked successfully"})
+}
@@ -0,0 +1,107 @@
+// controllers/traceController.go
+package controllers
+
+import (
+	"errors"
+	"farmers_market_backend/config"
+	"farmers_market_backend/middleware"
+	"farmers_market_backend/models"
+	"farmers_market_backend/services"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+	"gorm.io/gorm"
+)
+
+// GetTrace shows the public traceability page of a lot: the farm, harvest,
+// certifications and handling history. No login is required.
+func GetTrace(c *gin.Context) {
+	report, err := services.GetTrace(config.DB, c.Param("lotCode"))
+	if err != nil {
+		if errors.Is(err, services.ErrLotNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Lot not found"})
+			return
+		}
+		log.Printf("Failed to trace lot: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve traceability information"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"trace": report})
+}
+
+// GetTraceQRCode returns a PNG QR code linking to the traceability page of a
+// lot, to be printed on its packaging. The size in pixels can be set with ?size=.
+func GetTraceQRCode(c *gin.Context) {
+	lotCode := c.Param("lotCode")
+	var lot models.InventoryLot
+	if err := config.DB.Where("lot_code = ?", lotCode).First(&lot).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Lot not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve lot"})
+		}
+		return
+	}
+
+	size, err := strconv.Atoi(c.DefaultQuery("size", "256"))
+	if err != nil || size < 64 || size > 1024 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Size must be between 64 and 1024 pixels"})
+		return
+	}
+
+	baseURL := strings.TrimSuffix(config.GetEnv("TRACE_BASE_URL", "http://localhost:8080"), "/")
+	png, err := qrcode.Encode(baseURL+"/api/trace/"+url.PathEscape(lot.LotCode), qrcode.Medium, size)
+	if err != nil {
+		log.Printf("Failed to generate QR code: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate QR code"})
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=86400")
+	c.Data(http.StatusOK, "image/png", png)
+}
+
+// CreateLotEvent records a handling step of a lot, such as packing or transport
+func CreateLotEvent(c *gin.Context) {
+	variant, ok := findSellerVariant(c)
+	if !ok {
+		return
+	}
+
+	var lot models.InventoryLot
+	if err := config.DB.Where("variant_id = ?", variant.ID).First(&lot, c.Param("lotID")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Lot not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve lot"})
+		}
+		return
+	}
+
+	var event models.LotEvent
+	if err := c.ShouldBindJSON(&event); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	userID, _ := middleware.CurrentUserID(c)
+	event.ID = 0
+	event.LotID = lot.ID
+	event.RecordedByID = &userID
+
+	if err := services.RecordLotEvent(config.DB, &event); err != nil {
+		if errors.Is(err, services.ErrInvalidLotEvent) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Event type must be one of " + strings.Join(models.LotEventTypes, ", ")})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record lot event"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"event": event})
+}
@@ -2,7 +2,9 @@
 package controllers
 
 import (
+	"farmers_market_backend/config"
 	"farmers_market_backend/models"
+	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -18,7 +20,12 @@ func CreateUnitOfMeasure(c *gin.Context) {
 		return
 	}
 
-	if err := db.Create(&unit).Error; err != nil {
+	if err := validateUnitOfMeasure(&unit); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := config.DB.Create(&unit).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create unit of measure"})
 		return
 	}
@@ -26,10 +33,15 @@ func CreateUnitOfMeasure(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"unit_of_measure": unit})
 }
 
-// GetUnitsOfMeasure retrieves all units of measure
+// GetUnitsOfMeasure retrieves all units of measure, optionally filtered by dimension
 func GetUnitsOfMeasure(c *gin.Context) {
+	query := config.DB.Order("dimension, to_base")
+	if dimension := c.Query("dimension"); dimension != "" {
+		query = query.Where("dimension = ?", dimension)
+	}
+
 	var units []models.UnitOfMeasure
-	if err := db.Find(&units).Error; err != nil {
+	if err := query.Find(&units).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve units of measure"})
 		return
 	}
@@ -37,7 +49,8 @@ func GetUnitsOfMeasure(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"units_of_measure": units})
 }
 
-// UpdateUnitOfMeasure updates an existing unit of measure
+// UpdateUnitOfMeasure updates an existing unit of measure. The dimension of a
+// unit that variants are sold in cannot be changed.
 func UpdateUnitOfMeasure(c *gin.Context) {
 	unitID := c.Param("unitID")
 	var updatedUnit models.UnitOfMeasure
@@ -47,8 +60,13 @@ func UpdateUnitOfMeasure(c *gin.Context) {
 		return
 	}
 
+	if err := validateUnitOfMeasure(&updatedUnit); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	var unit models.UnitOfMeasure
-	if err := db.First(&unit, unitID).Error; err != nil {
+	if err := config.DB.First(&unit, unitID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Unit of measure not found"})
 		} else {
@@ -57,10 +75,25 @@ func UpdateUnitOfMeasure(c *gin.Context) {
 		return
 	}
 
+	if updatedUnit.Dimension != unit.Dimension {
+		inUse, err := unitInUse(unit.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update unit of measure"})
+			return
+		}
+		if inUse {
+			c.JSON(http.StatusConflict, gin.H{"error": "Unit of measure is in use, its dimension cannot be changed"})
+			return
+		}
+	}
+
 	unit.Name = updatedUnit.Name
 	unit.Abbreviation = updatedUnit.Abbreviation
+	unit.Dimension = updatedUnit.Dimension
+	unit.ToBase = updatedUnit.ToBase
+	unit.StepSize = updatedUnit.StepSize
 
-	if err := db.Save(&unit).Error; err != nil {
+	if err := config.DB.Save(&unit).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update unit of measure"})
 		return
 	}
@@ -68,14 +101,62 @@ func UpdateUnitOfMeasure(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"unit_of_measure": unit})
 }
 
-// DeleteUnitOfMeasure deletes a unit of measure by ID
+// DeleteUnitOfMeasure deletes a unit of measure by ID unless variants are sold in it
 func DeleteUnitOfMeasure(c *gin.Context) {
 	unitID := c.Param("unitID")
 
-	if err := db.Delete(&models.UnitOfMeasure{}, unitID).Error; err != nil {
+	var unit models.UnitOfMeasure
+	if err := config.DB.First(&unit, unitID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unit of measure not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve unit of measure"})
+		}
+		return
+	}
+
+	inUse, err := unitInUse(unit.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete unit of measure"})
+		return
+	}
+	if inUse {
+		c.JSON(http.StatusConflict, gin.H{"error": "Unit of measure is in use by product variants"})
+		return
+	}
+
+	if err := config.DB.Delete(&unit).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete unit of measure"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Unit of measure deleted successfully"})
 }
+
+// validateUnitOfMeasure checks the dimension, conversion factor and step size of a unit
+func validateUnitOfMeasure(unit *models.UnitOfMeasure) error {
+	if unit.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	switch unit.Dimension {
+	case models.DimensionMass, models.DimensionVolume, models.DimensionCount:
+	case "":
+		unit.Dimension = models.DimensionCount
+	default:
+		return fmt.Errorf("dimension must be one of %s, %s or %s", models.DimensionMass, models.DimensionVolume, models.DimensionCount)
+	}
+	if unit.ToBase <= 0 {
+		return fmt.Errorf("to_base must be greater than zero")
+	}
+	if unit.StepSize <= 0 {
+		return fmt.Errorf("step_size must be greater than zero")
+	}
+	return nil
+}
+
+// unitInUse reports whether any product variant is sold in the unit
+func unitInUse(unitID uint) (bool, error) {
+	var count int64
+	err := config.DB.Model(&models.ProductVariant{}).Where("unit_of_measure_id = ?", unitID).Count(&count).Error
+	return count > 0, err
+}
@@ -1,51 +1,112 @@
 package controllers
 
 import (
+	"errors"
 	"farmers_market_backend/config"
+	"farmers_market_backend/middleware"
 	"farmers_market_backend/models"
+	"farmers_market_backend/services"
+	"log"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 )
 
-// TopUpWallet adds funds to a user's wallet
+// TopUpWallet starts a top-up of the user's wallet through the payment gateway.
+// The wallet is credited once the gateway confirms the payment.
 func TopUpWallet(c *gin.Context) {
 	var request struct {
-		Amount float64 `json:"amount"`
+		Amount models.Money `json:"amount"`
 	}
 
 	if err := c.ShouldBindJSON(&request); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !isValidAmount(request.Amount) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Amount must be a positive " + models.DefaultCurrency + " amount"})
+		return
+	}
+
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authorized"})
+		return
+	}
+
+	topUp, err := services.StartTopUp(config.DB, userID, request.Amount)
+	if errors.Is(err, services.ErrNoPaymentGateway) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Top-ups are not enabled"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to start top-up: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to start top-up"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Complete the payment to top up your wallet", "top_up": topUp})
+}
+
+// GetTopUps retrieves a page of the top-ups of the authenticated user, newest
+// first, including failed and abandoned ones
+func GetTopUps(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authorized"})
+		return
+	}
+
+	query := config.DB.Model(&models.TopUp{}).Where("user_id = ?", userID)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	respondPage(c, query, "top_ups.id", true, func(topUp models.TopUp) uint { return topUp.ID })
+}
+
+// GetWalletBalance retrieves the current balance of a user's wallet, derived from the ledger
+func GetWalletBalance(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authorized"})
+		return
+	}
 
-	userID := c.MustGet("userID").(uint)
 	var wallet models.Wallet
 	if err := config.DB.Where("user_id = ?", userID).First(&wallet).Error; err != nil {
-		wallet = models.Wallet{UserID: userID, Balance: request.Amount}
-		if err := config.DB.Create(&wallet).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create wallet"})
-			return
-		}
-	} else {
-		wallet.Balance += request.Amount
-		if err := config.DB.Save(&wallet).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to top up wallet"})
-			return
-		}
-	}
-
-	c.JSON(http.StatusOK, gin.H{"message": "Wallet topped up successfully", "balance": wallet.Balance})
+		c.JSON(http.StatusNotFound, gin.H{"error": "Wallet not found"})
+		return
+	}
+
+	balance, err := services.LedgerBalance(config.DB, wallet)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate balance"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"balance": balance})
 }
 
-// GetWalletBalance retrieves the current balance of a user's wallet
-func GetWalletBalance(c *gin.Context) {
-	userID := c.MustGet("userID").(uint)
+// GetWalletStatement retrieves a page of the ledger entries of a user's wallet, newest first
+func GetWalletStatement(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authorized"})
+		return
+	}
+
 	var wallet models.Wallet
 	if err := config.DB.Where("user_id = ?", userID).First(&wallet).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Wallet not found"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"balance": wallet.Balance})
+	respondPage(c, config.DB.Model(&models.LedgerEntry{}).Where("wallet_id = ?", wallet.ID), "ledger_entries.id", true,
+		func(entry models.LedgerEntry) uint { return entry.ID })
+}
+
+// isValidAmount reports whether an amount sent by a client is positive and in the marketplace currency
+func isValidAmount(amount models.Money) bool {
+	return amount.IsPositive() && amount.Currency == models.DefaultCurrency
 }
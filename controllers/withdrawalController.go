@@ -0,0 +1,159 @@
+// controllers/withdrawalController.go
+package controllers
+
+import (
+	"errors"
+	"farmers_market_backend/config"
+	"farmers_market_backend/middleware"
+	"farmers_market_backend/models"
+	"farmers_market_backend/services"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestWithdrawal creates a withdrawal request from the wallet of the authenticated user
+func RequestWithdrawal(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authorized"})
+		return
+	}
+
+	var input struct {
+		Amount          models.Money `json:"amount"`
+		DestinationType string       `json:"destination_type" binding:"required,oneof=bank mobile_money"`
+		AccountName     string       `json:"account_name" binding:"required"`
+		AccountNumber   string       `json:"account_number" binding:"required"`
+		BankName        string       `json:"bank_name"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+	if !isValidAmount(input.Amount) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Amount must be a positive " + models.DefaultCurrency + " amount"})
+		return
+	}
+
+	request, err := services.RequestWithdrawal(config.DB, models.WithdrawalRequest{
+		UserID:          userID,
+		Amount:          input.Amount,
+		DestinationType: input.DestinationType,
+		AccountName:     input.AccountName,
+		AccountNumber:   input.AccountNumber,
+		BankName:        input.BankName,
+	})
+	if err != nil {
+		if errors.Is(err, services.ErrInsufficientFunds) {
+			c.JSON(http.StatusPaymentRequired, gin.H{"error": "Insufficient wallet balance"})
+			return
+		}
+		log.Printf("Failed to request withdrawal: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to request withdrawal"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"withdrawal": request})
+}
+
+// GetMyWithdrawals retrieves a page of the withdrawal requests of the authenticated user, newest first
+func GetMyWithdrawals(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authorized"})
+		return
+	}
+
+	respondPage(c, config.DB.Model(&models.WithdrawalRequest{}).Where("user_id = ?", userID), "withdrawal_requests.id", true,
+		func(request models.WithdrawalRequest) uint { return request.ID })
+}
+
+// GetWithdrawals retrieves a page of withdrawal requests for admins, oldest
+// first, optionally filtered by status
+func GetWithdrawals(c *gin.Context) {
+	query := config.DB.Model(&models.WithdrawalRequest{})
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	respondPage(c, query, "withdrawal_requests.id", false, func(request models.WithdrawalRequest) uint { return request.ID })
+}
+
+// ApproveWithdrawal approves a pending withdrawal request and pays it out
+func ApproveWithdrawal(c *gin.Context) {
+	adminID, withdrawalID, ok := withdrawalReviewParams(c)
+	if !ok {
+		return
+	}
+
+	request, err := services.ApproveWithdrawal(config.DB, withdrawalID, adminID)
+	if err != nil {
+		respondWithdrawalError(c, err)
+		return
+	}
+	if request.Status == models.WithdrawalStatusProcessing {
+		c.JSON(http.StatusAccepted, gin.H{"message": "Payout outcome unknown, it will be reconciled with the provider", "withdrawal": request})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"withdrawal": request})
+}
+
+// RejectWithdrawal rejects a pending withdrawal request and returns the funds to the wallet
+func RejectWithdrawal(c *gin.Context) {
+	adminID, withdrawalID, ok := withdrawalReviewParams(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Reason string `json:"reason"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
+	}
+
+	request, err := services.RejectWithdrawal(config.DB, withdrawalID, adminID, input.Reason)
+	if err != nil {
+		respondWithdrawalError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"withdrawal": request})
+}
+
+// withdrawalReviewParams reads the reviewing admin and the withdrawal ID, writing an error response if invalid
+func withdrawalReviewParams(c *gin.Context) (uint, uint, bool) {
+	adminID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authorized"})
+		return 0, 0, false
+	}
+
+	withdrawalID, err := strconv.ParseUint(c.Param("withdrawalID"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid withdrawal ID"})
+		return 0, 0, false
+	}
+
+	return adminID, uint(withdrawalID), true
+}
+
+// respondWithdrawalError writes the HTTP response for an error returned while reviewing a withdrawal
+func respondWithdrawalError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrWithdrawalNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Withdrawal request not found"})
+	case errors.Is(err, services.ErrWithdrawalNotPending):
+		c.JSON(http.StatusConflict, gin.H{"error": "Withdrawal request was already reviewed"})
+	default:
+		log.Printf("Failed to review withdrawal: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to review withdrawal"})
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"farmers_market_backend/config"
 	"farmers_market_backend/models"
 	"farmers_market_backend/routes"
+	"farmers_market_backend/services"
 	"log"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -19,11 +21,44 @@ func main() {
 
 	// Load environment variables and database configuration
 	config.LoadEnv()
+	if err := services.LoadTokenKeys(); err != nil {
+		log.Fatalf("Error loading token keys: %v", err)
+	}
 	database := config.ConnectDatabase() // Store the returned database instance
 
+	// The currency is set first, as migrations stamp it on existing amounts
+	models.DefaultCurrency = config.GetEnv("CURRENCY", "BDT")
+
 	// Initialize the database with migrations
 	initDatabase(database)
 
+	// Configure the external providers
+	configurePaymentGateway()
+	services.SetMailer(newMailer())
+	services.SetSMSProvider(services.NewFakeSMSProvider(config.GetEnv("SMS_LOG_FILE", "tmp/sms.log")))
+
+	// Start background jobs
+	services.StartReservationSweeper(database,
+		config.GetEnvDuration("ORDER_RESERVATION_TTL", 30*time.Minute),
+		config.GetEnvDuration("ORDER_RESERVATION_SWEEP_INTERVAL", time.Minute))
+	services.StartEscrowSweeper(database,
+		config.GetEnvDuration("ESCROW_AUTO_RELEASE_AFTER", 72*time.Hour),
+		config.GetEnvDuration("ESCROW_SWEEP_INTERVAL", time.Hour))
+	services.StartTopUpSweeper(database,
+		config.GetEnvDuration("TOPUP_ABANDON_AFTER", time.Hour),
+		config.GetEnvDuration("TOPUP_SWEEP_INTERVAL", 10*time.Minute))
+	services.StartLotExpirySweeper(database,
+		config.GetEnvDuration("LOT_EXPIRY_SWEEP_INTERVAL", time.Hour))
+	services.StartSeasonScheduler(database,
+		config.GetEnvDuration("SEASON_EXPIRY_NOTICE", 7*24*time.Hour),
+		config.GetEnvDuration("SEASON_SCHEDULE_INTERVAL", time.Hour))
+	services.StartPayoutReconciler(database,
+		config.GetEnvDuration("PAYOUT_RECONCILE_AFTER", 5*time.Minute),
+		config.GetEnvDuration("PAYOUT_RECONCILE_INTERVAL", 5*time.Minute))
+	services.StartSessionSweeper(database,
+		config.GetEnvDuration("SESSION_RETENTION", 30*24*time.Hour),
+		config.GetEnvDuration("SESSION_SWEEP_INTERVAL", 24*time.Hour))
+
 	// Set up routes
 	routes.InitializeRoutes(router)
 
@@ -36,18 +71,73 @@ func main() {
 // initDatabase performs database migrations for all models
 func initDatabase(db *gorm.DB) {
 	// Automatically migrate the schema
-	if err := db.AutoMigrate(
-		&models.Message{},
-		&models.Review{},
-		&models.Product{},
-		&models.User{},
-		&models.Country{},
-		&models.Category{},
-		&models.District{},
-		&models.Order{},
-		&models.Wallet{},
-		&models.UnitOfMeasure{},
-	); err != nil {
+	if err := db.AutoMigrate(models.All()...); err != nil {
 		log.Fatalf("Error during database migration: %v", err)
 	}
+
+	// Migrate existing data to the current schema
+	if err := migrateLegacyOrders(db); err != nil {
+		log.Fatalf("Error migrating legacy orders: %v", err)
+	}
+	if err := migrateOrderStatuses(db); err != nil {
+		log.Fatalf("Error migrating order statuses: %v", err)
+	}
+	if err := migrateMoneyColumns(db); err != nil {
+		log.Fatalf("Error migrating money columns: %v", err)
+	}
+	if err := migrateOrderPriceBreakdowns(db); err != nil {
+		log.Fatalf("Error migrating order price breakdowns: %v", err)
+	}
+	if err := migrateProductVariants(db); err != nil {
+		log.Fatalf("Error migrating product variants: %v", err)
+	}
+	if err := migrateInventoryLots(db); err != nil {
+		log.Fatalf("Error migrating inventory lots: %v", err)
+	}
+	if err := migrateProductSearch(db); err != nil {
+		log.Fatalf("Error migrating product search: %v", err)
+	}
+	if err := migrateWalletOpeningBalances(db); err != nil {
+		log.Fatalf("Error migrating wallet balances: %v", err)
+	}
+	if err := migrateDuplicateWallets(db); err != nil {
+		log.Fatalf("Error merging duplicate wallets: %v", err)
+	}
+	if err := migrateEmptyEmails(db); err != nil {
+		log.Fatalf("Error migrating empty emails: %v", err)
+	}
+	if err := migrateMobileNumbers(db); err != nil {
+		log.Fatalf("Error migrating mobile numbers: %v", err)
+	}
+}
+
+// newMailer returns the mailer selected by MAILER: "smtp" sends through
+// SMTP_HOST, anything else writes emails to files in MAIL_DIR for local use
+func newMailer() services.Mailer {
+	if config.GetEnv("MAILER", "fake") == "smtp" {
+		return &services.SMTPMailer{
+			Host:     config.GetEnv("SMTP_HOST", "localhost"),
+			Port:     config.GetEnv("SMTP_PORT", "587"),
+			Username: config.GetEnv("SMTP_USERNAME", ""),
+			Password: config.GetEnv("SMTP_PASSWORD", ""),
+			From:     config.GetEnv("MAIL_FROM", "no-reply@farmersmarket.local"),
+		}
+	}
+	return services.NewFakeMailer(config.GetEnv("MAIL_DIR", "tmp/mail"))
+}
+
+// configurePaymentGateway installs the gateway selected by PAYMENT_GATEWAY.
+// Only the mock gateway exists so far; it completes payments on request
+// without taking money and must only be enabled for development. Without a
+// gateway, wallet top-ups are disabled.
+func configurePaymentGateway() {
+	switch gateway := config.GetEnv("PAYMENT_GATEWAY", ""); gateway {
+	case "":
+		log.Println("Warning: PAYMENT_GATEWAY not set, wallet top-ups are disabled")
+	case "mock":
+		log.Println("Warning: using the mock payment gateway, payments are not real")
+		services.SetPaymentGateway(services.NewMockGateway(config.GetEnv("MOCK_GATEWAY_SECRET", "mock-secret")))
+	default:
+		log.Fatalf("Unsupported PAYMENT_GATEWAY %q", gateway)
+	}
 }
@@ -0,0 +1,135 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"farmers_market_backend/config"
+	"farmers_market_backend/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// OwnershipRule reports whether the resource a request is about belongs to
+// the user
+type OwnershipRule func(c *gin.Context, userID uint) (bool, error)
+
+// RequirePermission lets a request through if the authenticated user holds
+// the permission. A user whose roles grant the permission only for their own
+// resources is let through if any of the ownership rules passes. It must be
+// used after AuthMiddleware.
+func RequirePermission(permission string, rules ...OwnershipRule) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := CurrentUserID(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+
+		granted, ownOnly := models.HasPermission(CurrentRoles(c), permission)
+		if granted && ownOnly {
+			granted = false
+			for _, rule := range rules {
+				owns, err := rule(c, userID)
+				if err != nil {
+					log.Printf("Failed to check ownership for %s: %v", permission, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permissions"})
+					c.Abort()
+					return
+				}
+				if owns {
+					granted = true
+					break
+				}
+			}
+		}
+		if !granted {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You do not have the " + permission + " permission"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// OwnsProfile passes when the user ID in the URL parameter is the user's own
+func OwnsProfile(param string) OwnershipRule {
+	return func(c *gin.Context, userID uint) (bool, error) {
+		profileID, err := strconv.ParseUint(c.Param(param), 10, 32)
+		return err == nil && uint(profileID) == userID, nil
+	}
+}
+
+// OwnsProduct passes when the user sells the product in the URL parameter
+func OwnsProduct(param string) OwnershipRule {
+	return func(c *gin.Context, userID uint) (bool, error) {
+		return exists(config.DB.Model(&models.Product{}).Where("id = ? AND seller_id = ?", c.Param(param), userID))
+	}
+}
+
+// OwnsOrder passes when the user is the buyer or the seller of the order in
+// the URL parameter
+func OwnsOrder(param string) OwnershipRule {
+	return func(c *gin.Context, userID uint) (bool, error) {
+		return exists(config.DB.Model(&models.Order{}).Where("id = ? AND (buyer_id = ? OR seller_id = ?)", c.Param(param), userID, userID))
+	}
+}
+
+// OwnsCoupon passes when the user created the coupon in the URL parameter
+func OwnsCoupon(param string) OwnershipRule {
+	return func(c *gin.Context, userID uint) (bool, error) {
+		return exists(config.DB.Model(&models.Coupon{}).Where("id = ? AND created_by_id = ?", c.Param(param), userID))
+	}
+}
+
+// OwnsDeliveryZone passes when the delivery zone in the URL parameter is the user's
+func OwnsDeliveryZone(param string) OwnershipRule {
+	return func(c *gin.Context, userID uint) (bool, error) {
+		return exists(config.DB.Model(&models.DeliveryZone{}).Where("id = ? AND seller_id = ?", c.Param(param), userID))
+	}
+}
+
+// OwnsCertification passes when the certification in the URL parameter is the user's
+func OwnsCertification(param string) OwnershipRule {
+	return func(c *gin.Context, userID uint) (bool, error) {
+		return exists(config.DB.Model(&models.Certification{}).Where("id = ? AND seller_id = ?", c.Param(param), userID))
+	}
+}
+
+// OwnsReview passes when the user wrote the review in the URL parameter
+func OwnsReview(param string) OwnershipRule {
+	return func(c *gin.Context, userID uint) (bool, error) {
+		return exists(config.DB.Model(&models.Review{}).Where("id = ? AND user_id = ?", c.Param(param), userID))
+	}
+}
+
+// InConversation passes when the user is one of the two users named by the
+// query parameters
+func InConversation(param, otherParam string) OwnershipRule {
+	return func(c *gin.Context, userID uint) (bool, error) {
+		for _, value := range []string{c.Query(param), c.Query(otherParam)} {
+			if id, err := strconv.ParseUint(value, 10, 32); err == nil && uint(id) == userID {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+}
+
+// OwnResourcesOnly passes for requests that name no existing resource, such
+// as creating one or listing the user's own. The handler must keep them to
+// the user's own resources.
+func OwnResourcesOnly(c *gin.Context, userID uint) (bool, error) {
+	return true, nil
+}
+
+// exists reports whether the query matches a row
+func exists(query *gorm.DB) (bool, error) {
+	var count int64
+	err := query.Count(&count).Error
+	return count > 0, err
+}
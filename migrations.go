@@ -0,0 +1,555 @@
+package main
+
+import (
+	"database/sql"
+	"farmers_market_backend/models"
+	"farmers_market_backend/services"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// migrateLegacyOrders moves orders created before multi-item orders existed,
+// which stored a single product_id and quantity on the order itself, into
+// order lines and then drops the old columns.
+func migrateLegacyOrders(db *gorm.DB) error {
+	migrator := db.Migrator()
+	if !migrator.HasColumn(&models.Order{}, "product_id") {
+		return nil
+	}
+
+	var legacyOrders []struct {
+		ID         uint
+		ProductID  uint
+		Quantity   int
+		TotalPrice float64
+	}
+	if err := db.Table("orders").Select("id, product_id, quantity, total_price").
+		Where("product_id IS NOT NULL AND product_id <> 0").Scan(&legacyOrders).Error; err != nil {
+		return err
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, legacy := range legacyOrders {
+			var count int64
+			if err := tx.Model(&models.OrderItem{}).Where("order_id = ?", legacy.ID).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				continue
+			}
+
+			unitPrice := legacy.TotalPrice
+			if legacy.Quantity > 0 {
+				unitPrice = legacy.TotalPrice / float64(legacy.Quantity)
+			}
+			item := models.OrderItem{
+				OrderID:   legacy.ID,
+				ProductID: legacy.ProductID,
+				Quantity:  float64(legacy.Quantity),
+				UnitPrice: models.MoneyFromFloat(unitPrice, models.DefaultCurrency),
+				LineTotal: models.MoneyFromFloat(legacy.TotalPrice, models.DefaultCurrency),
+			}
+			if err := tx.Create(&item).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Schema changes are done outside the transaction as MySQL commits DDL implicitly
+	if migrator.HasConstraint(&models.Order{}, "fk_orders_product") {
+		if err := migrator.DropConstraint(&models.Order{}, "fk_orders_product"); err != nil {
+			return err
+		}
+	}
+	for _, column := range []string{"product_id", "quantity"} {
+		if err := migrator.DropColumn(&models.Order{}, column); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Migrated %d legacy orders to order lines", len(legacyOrders))
+	return nil
+}
+
+// legacyOrderStatuses maps the free-text order statuses written before the
+// order lifecycle existed, lower-cased with single spaces between words, to
+// the status constants in models
+var legacyOrderStatuses = map[string]string{
+	"pending":               models.OrderStatusPending,
+	"new":                   models.OrderStatusPending,
+	"placed":                models.OrderStatusPending,
+	"awaiting confirmation": models.OrderStatusPending,
+	"confirmed":             models.OrderStatusConfirmed,
+	"accepted":              models.OrderStatusConfirmed,
+	"approved":              models.OrderStatusConfirmed,
+	"processing":            models.OrderStatusConfirmed,
+	"in progress":           models.OrderStatusConfirmed,
+	"packed":                models.OrderStatusPacked,
+	"ready":                 models.OrderStatusPacked,
+	"out for delivery":      models.OrderStatusOutForDelivery,
+	"shipped":               models.OrderStatusOutForDelivery,
+	"dispatched":            models.OrderStatusOutForDelivery,
+	"in transit":            models.OrderStatusOutForDelivery,
+	"delivered":             models.OrderStatusDelivered,
+	"completed":             models.OrderStatusDelivered,
+	"complete":              models.OrderStatusDelivered,
+	"fulfilled":             models.OrderStatusDelivered,
+	"received":              models.OrderStatusDelivered,
+	"done":                  models.OrderStatusDelivered,
+	"cancelled":             models.OrderStatusCancelled,
+	"canceled":              models.OrderStatusCancelled,
+	"rejected":              models.OrderStatusRejected,
+	"declined":              models.OrderStatusRejected,
+	"refunded":              models.OrderStatusRefunded,
+}
+
+// migrateOrderStatuses converts free-text order statuses written before the
+// order lifecycle existed to the status constants in models. Orders whose
+// status cannot be mapped may already have been fulfilled, so rather than
+// reopening them they are cancelled, with an event recording the old status.
+// Orders made pending here reserved no stock (stock_reserved stays false), so
+// the reservation sweeper leaves them alone and cancelling them frees nothing.
+func migrateOrderStatuses(db *gorm.DB) error {
+	var statuses []sql.NullString
+	if err := db.Model(&models.Order{}).Distinct("status").Pluck("status", &statuses).Error; err != nil {
+		return err
+	}
+
+	for _, legacy := range statuses {
+		words := strings.Fields(strings.NewReplacer("_", " ", "-", " ").Replace(strings.ToLower(legacy.String)))
+		status, known := legacyOrderStatuses[strings.Join(words, " ")]
+		if known && legacy.Valid && legacy.String == status {
+			continue
+		}
+
+		orders := db.Model(&models.Order{}).Where("status = ?", legacy.String)
+		if !legacy.Valid {
+			orders = db.Model(&models.Order{}).Where("status IS NULL")
+		}
+		if known {
+			if err := orders.Update("status", status).Error; err != nil {
+				return err
+			}
+			continue
+		}
+
+		var orderIDs []uint
+		if err := orders.Pluck("id", &orderIDs).Error; err != nil {
+			return err
+		}
+		log.Printf("Cancelling %d orders with the unknown legacy status %q", len(orderIDs), legacy.String)
+		err := db.Transaction(func(tx *gorm.DB) error {
+			for _, orderID := range orderIDs {
+				if err := tx.Model(&models.Order{}).Where("id = ?", orderID).Update("status", models.OrderStatusCancelled).Error; err != nil {
+					return err
+				}
+				if err := tx.Create(&models.OrderEvent{
+					OrderID:    orderID,
+					FromStatus: legacy.String,
+					ToStatus:   models.OrderStatusCancelled,
+					ActorRole:  services.OrderRoleSystem,
+					Note:       fmt.Sprintf("Closed when order statuses were migrated, the legacy status %q is unknown", legacy.String),
+				}).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateDuplicateWallets merges the wallets users got twice when two
+// payments created their first wallet at the same time, then makes the user
+// index of wallets unique so it cannot happen again. It runs after
+// migrateWalletOpeningBalances, so every balance is backed by the ledger. The
+// oldest wallet of a user is kept; the balance of each other wallet is moved
+// to it with a journal entry and its withdrawals are pointed at it. Ledger
+// entries are append-only, so the history of a merged wallet stays under its
+// own account, which ends at zero.
+func migrateDuplicateWallets(db *gorm.DB) error {
+	var userIDs []uint
+	if err := db.Unscoped().Model(&models.Wallet{}).Group("user_id").Having("COUNT(*) > 1").
+		Pluck("user_id", &userIDs).Error; err != nil {
+		return err
+	}
+
+	for _, userID := range userIDs {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var wallets []models.Wallet
+			if err := tx.Unscoped().Where("user_id = ?", userID).Order("id").Find(&wallets).Error; err != nil {
+				return err
+			}
+			kept := wallets[0]
+			for _, duplicate := range wallets[1:] {
+				if !duplicate.Balance.IsZero() {
+					if duplicate.Balance.Currency != kept.Balance.Currency {
+						return fmt.Errorf("wallets %d and %d of user %d hold different currencies", kept.ID, duplicate.ID, userID)
+					}
+					from, to := services.DebitWallet(duplicate, duplicate.Balance), services.CreditWallet(kept, duplicate.Balance)
+					if !duplicate.Balance.IsPositive() {
+						overdrawn := models.NewMoney(-duplicate.Balance.Minor, duplicate.Balance.Currency)
+						from, to = services.CreditWallet(duplicate, overdrawn), services.DebitWallet(kept, overdrawn)
+					}
+					if _, err := services.PostJournal(tx, fmt.Sprintf("wallet:%d:merge", duplicate.ID),
+						fmt.Sprintf("Merged into wallet %d", kept.ID), nil, from, to); err != nil {
+						return err
+					}
+				}
+				if err := tx.Model(&models.WithdrawalRequest{}).Unscoped().Where("wallet_id = ?", duplicate.ID).
+					Update("wallet_id", kept.ID).Error; err != nil {
+					return err
+				}
+				if err := tx.Unscoped().Delete(&duplicate).Error; err != nil {
+					return err
+				}
+			}
+			log.Printf("Merged %d duplicate wallets of user %d into wallet %d", len(wallets)-1, userID, kept.ID)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Databases created before the index was unique keep the old index of the same name
+	indexes, err := db.Migrator().GetIndexes(&models.Wallet{})
+	if err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		if index.Name() != "idx_wallets_user_id" {
+			continue
+		}
+		if unique, ok := index.Unique(); ok && !unique {
+			if err := db.Migrator().DropIndex(&models.Wallet{}, index.Name()); err != nil {
+				return err
+			}
+			return db.Migrator().CreateIndex(&models.Wallet{}, "UserID")
+		}
+	}
+	return nil
+}
+
+// migrateWalletOpeningBalances posts an opening balance journal entry for
+// wallets whose balance was set before the ledger existed, so every wallet
+// balance can be derived from its ledger entries
+func migrateWalletOpeningBalances(db *gorm.DB) error {
+	var wallets []models.Wallet
+	if err := db.Where("balance_minor <> 0 AND NOT EXISTS (?)",
+		db.Model(&models.LedgerEntry{}).Select("1").Where("ledger_entries.wallet_id = wallets.id"),
+	).Find(&wallets).Error; err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, wallet := range wallets {
+			walletID := wallet.ID
+			balance := wallet.Balance
+			walletLine := models.LedgerEntry{Account: models.WalletAccount(wallet.ID), WalletID: &walletID, Currency: balance.Currency}
+			equityLine := models.LedgerEntry{Account: models.LedgerAccountOpeningBalances, Currency: balance.Currency}
+			if balance.IsPositive() {
+				walletLine.CreditMinor, equityLine.DebitMinor = balance.Minor, balance.Minor
+			} else {
+				walletLine.DebitMinor, equityLine.CreditMinor = -balance.Minor, -balance.Minor
+			}
+
+			// The wallet balance already includes this amount, so the entry is
+			// created directly instead of through services.PostJournal
+			entry := models.JournalEntry{
+				Reference:   fmt.Sprintf("wallet:%d:opening", wallet.ID),
+				Description: "Opening balance",
+				Lines:       []models.LedgerEntry{walletLine, equityLine},
+			}
+			if err := tx.Create(&entry).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// moneyColumn describes a floating point money column written before amounts
+// were stored as models.Money, and the columns that replace it
+type moneyColumn struct {
+	model          interface{}
+	table          string
+	floatColumn    string
+	minorColumn    string
+	currencyColumn string
+}
+
+// migrateMoneyColumns converts floating point money columns to minor units in
+// models.DefaultCurrency, rounding half away from zero, and drops the old columns
+func migrateMoneyColumns(db *gorm.DB) error {
+	columns := []moneyColumn{
+		{&models.CartItem{}, "cart_items", "unit_price", "unit_price_minor", "unit_price_currency"},
+		{&models.Order{}, "orders", "total_price", "total_price_minor", "total_price_currency"},
+		{&models.OrderItem{}, "order_items", "unit_price", "unit_price_minor", "unit_price_currency"},
+		{&models.OrderItem{}, "order_items", "line_total", "line_total_minor", "line_total_currency"},
+		{&models.Wallet{}, "wallets", "balance", "balance_minor", "balance_currency"},
+		{&models.LedgerEntry{}, "ledger_entries", "debit", "debit_minor", "currency"},
+		{&models.LedgerEntry{}, "ledger_entries", "credit", "credit_minor", "currency"},
+		{&models.EscrowHold{}, "escrow_holds", "amount", "amount_minor", "amount_currency"},
+		{&models.WithdrawalRequest{}, "withdrawal_requests", "amount", "amount_minor", "amount_currency"},
+		{&models.TopUp{}, "top_ups", "amount", "amount_minor", "amount_currency"},
+	}
+
+	scale := math.Pow10(models.CurrencyExponent(models.DefaultCurrency))
+	migrator := db.Migrator()
+	for _, column := range columns {
+		if !migrator.HasColumn(column.model, column.floatColumn) {
+			continue
+		}
+
+		if err := db.Table(column.table).Where("1 = 1").Updates(map[string]interface{}{
+			column.minorColumn:    gorm.Expr("ROUND(COALESCE("+column.floatColumn+", 0) * ?)", scale),
+			column.currencyColumn: models.DefaultCurrency,
+		}).Error; err != nil {
+			return err
+		}
+		if err := migrator.DropColumn(column.model, column.floatColumn); err != nil {
+			return err
+		}
+		log.Printf("Converted %s.%s to minor units", column.table, column.floatColumn)
+	}
+
+	// Rows created since the money columns were added may lack a currency
+	for _, column := range columns {
+		if err := db.Table(column.table).
+			Where(column.currencyColumn+" IS NULL OR "+column.currencyColumn+" = ''").
+			Update(column.currencyColumn, models.DefaultCurrency).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateOrderPriceBreakdowns fills in the price breakdown of orders placed
+// before the pricing engine or coupons existed. Their total becomes the
+// subtotal, and the unit price of their lines becomes the list price.
+// Their lines were priced by the list price or the promotional discount.
+func migrateOrderPriceBreakdowns(db *gorm.DB) error {
+	if err := db.Model(&models.Order{}).
+		Where("subtotal_currency IS NULL OR subtotal_currency = ''").
+		Updates(map[string]interface{}{
+			"subtotal_minor":          gorm.Expr("total_price_minor"),
+			"subtotal_currency":       gorm.Expr("total_price_currency"),
+			"discount_total_currency": gorm.Expr("total_price_currency"),
+			"delivery_fee_currency":   gorm.Expr("total_price_currency"),
+			"tax_total_currency":      gorm.Expr("total_price_currency"),
+		}).Error; err != nil {
+		return err
+	}
+
+	if err := db.Model(&models.Order{}).
+		Where("coupon_discount_currency IS NULL OR coupon_discount_currency = ''").
+		Update("coupon_discount_currency", gorm.Expr("total_price_currency")).Error; err != nil {
+		return err
+	}
+
+	if err := db.Model(&models.OrderItem{}).
+		Where("list_price_currency IS NULL OR list_price_currency = ''").
+		Updates(map[string]interface{}{
+			"list_price_minor":    gorm.Expr("unit_price_minor"),
+			"list_price_currency": gorm.Expr("unit_price_currency"),
+		}).Error; err != nil {
+		return err
+	}
+
+	return db.Model(&models.OrderItem{}).
+		Where("price_rule IS NULL OR price_rule = ''").
+		Update("price_rule", gorm.Expr("CASE WHEN discount > 0 THEN ? ELSE ? END", models.PriceRulePromo, models.PriceRuleList)).Error
+}
+
+// migrateProductVariants turns products created before variants existed into
+// single-variant products. Price, stock, unit of measure and minimum order
+// quantity move from the product to its variant, and cart items, order lines
+// and prices that referred to the product are pointed at that variant.
+func migrateProductVariants(db *gorm.DB) error {
+	migrator := db.Migrator()
+	if migrator.HasColumn(&models.Product{}, "unit_of_measure_id") {
+		// Prices may still be floating point if the money migration has not
+		// converted them yet
+		priceColumn := "price_minor"
+		if !migrator.HasColumn(&models.Product{}, "price_minor") {
+			scale := math.Pow10(models.CurrencyExponent(models.DefaultCurrency))
+			priceColumn = fmt.Sprintf("ROUND(COALESCE(price, 0) * %v)", scale)
+		}
+		reservedColumn := "reserved_stock"
+		if !migrator.HasColumn(&models.Product{}, "reserved_stock") {
+			reservedColumn = "0"
+		}
+
+		var legacyProducts []struct {
+			ID              uint
+			PriceMinor      int64
+			Stock           float64
+			ReservedStock   float64
+			UnitOfMeasureID uint
+			MinOrderQty     float64
+		}
+		if err := db.Table("products").
+			Select(priceColumn + " AS price_minor, " + reservedColumn + " AS reserved_stock, id, stock, unit_of_measure_id, min_order_qty").
+			Where("NOT EXISTS (SELECT 1 FROM product_variants WHERE product_variants.product_id = products.id)").
+			Scan(&legacyProducts).Error; err != nil {
+			return err
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			for _, legacy := range legacyProducts {
+				variant := models.ProductVariant{
+					ProductID:       legacy.ID,
+					SKU:             fmt.Sprintf("SKU-%06d", legacy.ID),
+					Price:           models.NewMoney(legacy.PriceMinor, models.DefaultCurrency),
+					Stock:           legacy.Stock,
+					ReservedStock:   legacy.ReservedStock,
+					UnitOfMeasureID: legacy.UnitOfMeasureID,
+					MinOrderQty:     legacy.MinOrderQty,
+				}
+				if err := tx.Create(&variant).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// Schema changes are done outside the transaction as MySQL commits DDL implicitly
+		if migrator.HasConstraint(&models.Product{}, "fk_products_unit_of_measure") {
+			if err := migrator.DropConstraint(&models.Product{}, "fk_products_unit_of_measure"); err != nil {
+				return err
+			}
+		}
+		for _, column := range []string{"price", "price_minor", "price_currency", "stock", "reserved_stock", "unit_of_measure_id", "min_order_qty"} {
+			if migrator.HasColumn(&models.Product{}, column) {
+				if err := migrator.DropColumn(&models.Product{}, column); err != nil {
+					return err
+				}
+			}
+		}
+		log.Printf("Migrated %d products to single-variant products", len(legacyProducts))
+	}
+
+	// Point rows that still refer to a product at its first variant
+	firstVariant := "(SELECT MIN(product_variants.id) FROM product_variants WHERE product_variants.product_id = %s.product_id)"
+	for _, table := range []string{"cart_items", "order_items", "product_price_tiers", "buyer_group_prices"} {
+		if !migrator.HasColumn(table, "product_id") {
+			continue
+		}
+		if err := db.Table(table).Where("variant_id IS NULL OR variant_id = 0").
+			Update("variant_id", gorm.Expr(fmt.Sprintf(firstVariant, table))).Error; err != nil {
+			return err
+		}
+	}
+
+	// Prices are kept per variant now
+	for _, table := range []string{"product_price_tiers", "buyer_group_prices"} {
+		if migrator.HasColumn(table, "product_id") {
+			if err := migrator.DropColumn(table, "product_id"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// migrateInventoryLots moves the stock of variants created before lots were
+// tracked into an opening lot, so the stock stays sellable. Opening lots have
+// no best-before date as the harvest they came from is unknown.
+func migrateInventoryLots(db *gorm.DB) error {
+	var variants []models.ProductVariant
+	if err := db.Where("stock > 0").
+		Where("NOT EXISTS (SELECT 1 FROM inventory_lots WHERE inventory_lots.variant_id = product_variants.id)").
+		Find(&variants).Error; err != nil {
+		return err
+	}
+	if len(variants) == 0 {
+		return nil
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, variant := range variants {
+			lot := models.InventoryLot{
+				VariantID:   variant.ID,
+				LotCode:     fmt.Sprintf("OPENING-%s", variant.SKU),
+				HarvestDate: variant.CreatedAt,
+				Quantity:    variant.Stock,
+				Remaining:   variant.Stock,
+				Status:      models.LotStatusAvailable,
+			}
+			if err := tx.Create(&lot).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Moved the stock of %d variants into opening lots", len(variants))
+	return nil
+}
+
+// migrateProductSearch creates the full-text index products are searched by
+// and fills in the search text of products created before search existed
+func migrateProductSearch(db *gorm.DB) error {
+	if !db.Migrator().HasIndex(&models.Product{}, "idx_products_search") {
+		statement := "CREATE FULLTEXT INDEX idx_products_search ON products (search_text)"
+		if db.Dialector.Name() == "postgres" {
+			statement = "CREATE INDEX idx_products_search ON products USING GIN (to_tsvector('simple', search_text))"
+		}
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return services.RefreshSearchText(db, "search_text IS NULL OR search_text = ''")
+}
+
+// migrateEmptyEmails clears the empty email addresses of users saved before
+// email became optional, as users without one are told apart by NULL
+func migrateEmptyEmails(db *gorm.DB) error {
+	return db.Model(&models.User{}).Where("email = ?", "").Update("email", nil).Error
+}
+
+// migrateMobileNumbers writes the mobile numbers of users saved before
+// one-time login codes existed in E.164 format, so the codes can find them.
+// National numbers are read as numbers of the country of the user's district.
+// Numbers that cannot be normalised are left as they are and logged.
+func migrateMobileNumbers(db *gorm.DB) error {
+	var users []models.User
+	return db.Preload("District.Country").Where("mobile_number <> ''").
+		FindInBatches(&users, 500, func(tx *gorm.DB, batch int) error {
+			for _, user := range users {
+				var country *models.Country
+				if user.DistrictID != nil {
+					country = &user.District.Country
+				}
+				number, err := services.NormalizePhoneNumber(user.MobileNumber, country)
+				if err != nil {
+					log.Printf("Leaving mobile number of user %d as it is: %v", user.ID, err)
+					continue
+				}
+				if number == user.MobileNumber {
+					continue
+				}
+				if err := db.Model(&models.User{}).Where("id = ?", user.ID).Update("mobile_number", number).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		}).Error
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"farmers_market_backend/models"
+	"farmers_market_backend/services"
+	"farmers_market_backend/testutil"
+)
+
+func TestMigrateOrderStatuses(t *testing.T) {
+	db := testutil.OpenDB(t)
+	buyer := testutil.CreateUser(t, db, "buyer")
+	seller := testutil.CreateUser(t, db, "seller")
+
+	want := map[string]string{
+		"Pending":          models.OrderStatusPending,
+		"Out for Delivery": models.OrderStatusOutForDelivery,
+		"Shipped":          models.OrderStatusOutForDelivery,
+		"COMPLETED":        models.OrderStatusDelivered,
+		"Delivered":        models.OrderStatusDelivered,
+		"Canceled":         models.OrderStatusCancelled,
+		"on-hold":          models.OrderStatusCancelled,
+		"packed":           models.OrderStatusPacked,
+	}
+	orderIDs := map[string]uint{}
+	for legacy := range want {
+		order := models.Order{BuyerID: buyer.ID, SellerID: seller.ID, Status: legacy}
+		if err := db.Create(&order).Error; err != nil {
+			t.Fatalf("create order: %v", err)
+		}
+		orderIDs[legacy] = order.ID
+	}
+
+	if err := migrateOrderStatuses(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	for legacy, status := range want {
+		var order models.Order
+		if err := db.First(&order, orderIDs[legacy]).Error; err != nil {
+			t.Fatalf("load order: %v", err)
+		}
+		if order.Status != status {
+			t.Errorf("legacy status %q became %q, want %q", legacy, order.Status, status)
+		}
+	}
+
+	var events []models.OrderEvent
+	if err := db.Find(&events).Error; err != nil {
+		t.Fatalf("load events: %v", err)
+	}
+	if len(events) != 1 || events[0].OrderID != orderIDs["on-hold"] || events[0].FromStatus != "on-hold" {
+		t.Errorf("events = %+v, want one recording the unknown status of the on-hold order", events)
+	}
+}
+
+func TestMigrateDuplicateWallets(t *testing.T) {
+	db := testutil.OpenDB(t)
+	user := testutil.CreateUser(t, db, "buyer")
+
+	// Databases from before the unique index let a user get two wallets
+	if err := db.Migrator().DropIndex(&models.Wallet{}, "idx_wallets_user_id"); err != nil {
+		t.Fatalf("drop index: %v", err)
+	}
+	if err := db.Exec("CREATE INDEX idx_wallets_user_id ON wallets (user_id)").Error; err != nil {
+		t.Fatalf("create old index: %v", err)
+	}
+	wallets := make([]models.Wallet, 2)
+	for i := range wallets {
+		wallets[i] = models.Wallet{UserID: user.ID, Balance: models.NewMoney(0, models.DefaultCurrency)}
+		if err := db.Create(&wallets[i]).Error; err != nil {
+			t.Fatalf("create wallet: %v", err)
+		}
+		if _, err := services.PostJournal(db, fmt.Sprintf("topup:%d", i), "Top up", nil,
+			services.CreditWallet(wallets[i], models.NewMoney(1000, models.DefaultCurrency)),
+			services.Posting{Account: models.LedgerAccountTopUps, Debit: models.NewMoney(1000, models.DefaultCurrency)}); err != nil {
+			t.Fatalf("top up: %v", err)
+		}
+	}
+	withdrawal := models.WithdrawalRequest{UserID: user.ID, WalletID: wallets[1].ID, AccountNumber: "01700000000",
+		DestinationType: models.WithdrawalDestinationBank, Amount: models.NewMoney(0, models.DefaultCurrency)}
+	if err := db.Create(&withdrawal).Error; err != nil {
+		t.Fatalf("create withdrawal: %v", err)
+	}
+
+	if err := migrateDuplicateWallets(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	var remaining []models.Wallet
+	if err := db.Unscoped().Where("user_id = ?", user.ID).Find(&remaining).Error; err != nil {
+		t.Fatalf("load wallets: %v", err)
+	}
+	if len(remaining) != 1 || remaining[0].ID != wallets[0].ID {
+		t.Fatalf("wallets = %+v, want only wallet %d", remaining, wallets[0].ID)
+	}
+	if remaining[0].Balance.Minor != 2000 {
+		t.Errorf("balance = %v, want 2000", remaining[0].Balance.Minor)
+	}
+	if balance, err := services.LedgerBalance(db, remaining[0]); err != nil || balance.Minor != 2000 {
+		t.Errorf("ledger balance = %v (%v), want 2000", balance.Minor, err)
+	}
+	if balance, err := services.LedgerBalance(db, wallets[1]); err != nil || !balance.IsZero() {
+		t.Errorf("merged wallet ledger balance = %v (%v), want 0", balance.Minor, err)
+	}
+	if err := db.First(&withdrawal, withdrawal.ID).Error; err != nil || withdrawal.WalletID != wallets[0].ID {
+		t.Errorf("withdrawal wallet = %d (%v), want %d", withdrawal.WalletID, err, wallets[0].ID)
+	}
+
+	duplicate := models.Wallet{UserID: user.ID, Balance: models.NewMoney(0, models.DefaultCurrency)}
+	if err := db.Create(&duplicate).Error; err == nil {
+		t.Error("created a second wallet after the migration, want the unique index to refuse it")
+	}
+}
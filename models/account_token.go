@@ -0,0 +1,20 @@
+// models/account_token.go
+package models
+
+import (
+	"time"
+)
+
+// AccountToken records a token emailed to a user to verify their address or
+// reset their password, so each can be used only once. The token itself is a
+// signed JWT; only its ID is stored.
+type AccountToken struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    uint       `json:"user_id" gorm:"not null;index"`         // Foreign Key from User
+	Type      string     `json:"type" gorm:"size:32;not null"`          // services.TokenTypeEmailVerification or TokenTypePasswordReset
+	TokenID   string     `json:"-" gorm:"size:64;uniqueIndex;not null"` // jti claim of the token
+	Email     string     `json:"email"`                                 // Address the token was sent to
+	ExpiresAt time.Time  `json:"expires_at"`                            // Expiry of the token
+	UsedAt    *time.Time `json:"used_at"`                               // When the token was used, or superseded by a newer one
+	CreatedAt time.Time  `json:"created_at"`
+}
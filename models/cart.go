@@ -0,0 +1,27 @@
+// models/cart.go
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// Cart is the persistent shopping cart of a user. Each user has at most one cart.
+type Cart struct {
+	gorm.Model
+	UserID uint       `json:"user_id" gorm:"uniqueIndex;not null"` // Foreign Key from User (owner of the cart)
+	Items  []CartItem `json:"items" gorm:"foreignKey:CartID"`      // Line items in the cart
+}
+
+// CartItem is a single product variant line in a cart
+type CartItem struct {
+	gorm.Model
+	CartID    uint    `json:"cart_id" gorm:"not null;index"`                         // Foreign Key from Cart
+	ProductID uint    `json:"product_id" gorm:"not null;index"`                      // Foreign Key from Product
+	VariantID uint    `json:"variant_id" gorm:"not null;index"`                      // Foreign Key from ProductVariant
+	Quantity  float64 `json:"quantity" gorm:"not null"`                              // Quantity to buy in the unit of the variant
+	UnitPrice Money   `json:"unit_price" gorm:"embedded;embeddedPrefix:unit_price_"` // Unit price seen by the buyer when the item was added
+
+	// Relationships
+	Product Product        `json:"product" gorm:"foreignKey:ProductID"`
+	Variant ProductVariant `json:"variant" gorm:"foreignKey:VariantID"`
+}
@@ -6,6 +6,8 @@ import (
 
 type Country struct {
 	gorm.Model
-	Name      string     `json:"name" gorm:"unique;not null"`
-	Districts []District `json:"districts" gorm:"foreignKey:CountryID"` // Relationship with District
+	Name        string     `json:"name" gorm:"unique;not null"`
+	CallingCode string     `json:"calling_code" gorm:"size:3"`            // International calling code without the +, e.g. 880
+	TrunkPrefix string     `json:"trunk_prefix" gorm:"size:2"`            // Prefix dropped from national numbers, e.g. 0
+	Districts   []District `json:"districts" gorm:"foreignKey:CountryID"` // Relationship with District
 }
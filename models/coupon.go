@@ -0,0 +1,48 @@
+// models/coupon.go
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Coupon discount types
+const (
+	CouponTypePercentage = "percentage" // Percent off the eligible goods
+	CouponTypeFixed      = "fixed"      // Fixed amount off the eligible goods
+)
+
+// Coupon is a promotion code that buyers can apply at checkout. The scope
+// fields restrict the coupon to products of a category, seller or single
+// product; when several are set a product must match all of them.
+type Coupon struct {
+	gorm.Model
+	Code           string     `json:"code" gorm:"size:64;uniqueIndex;not null"`              // Code entered by the buyer, stored in upper case
+	Description    string     `json:"description"`                                           // Campaign description shown to buyers
+	Type           string     `json:"type" gorm:"not null"`                                  // One of the CouponType constants
+	Percent        float64    `json:"percent"`                                               // Discount percentage for percentage coupons
+	Amount         Money      `json:"amount" gorm:"embedded;embeddedPrefix:amount_"`         // Discount amount for fixed coupons
+	MinBasket      Money      `json:"min_basket" gorm:"embedded;embeddedPrefix:min_basket_"` // Minimum value of the eligible goods, zero for none
+	UsageLimit     int        `json:"usage_limit" gorm:"default:0"`                          // Total number of redemptions allowed, 0 for unlimited
+	PerUserLimit   int        `json:"per_user_limit" gorm:"default:0"`                       // Redemptions allowed per buyer, 0 for unlimited
+	StartsAt       *time.Time `json:"starts_at"`                                             // Coupon is valid from this time, nil for immediately
+	EndsAt         *time.Time `json:"ends_at"`                                               // Coupon is valid until this time, nil for no end
+	FirstOrderOnly bool       `json:"first_order_only" gorm:"default:false"`                 // Only buyers without earlier orders may use it
+	CategoryID     *uint      `json:"category_id" gorm:"index"`                              // Restrict to products of this category
+	SellerID       *uint      `json:"seller_id" gorm:"index"`                                // Restrict to products of this seller
+	ProductID      *uint      `json:"product_id" gorm:"index"`                               // Restrict to this product
+	IsActive       bool       `json:"is_active" gorm:"default:true"`                         // Inactive coupons cannot be redeemed
+	CreatedByID    uint       `json:"created_by_id" gorm:"not null;index"`                   // Admin or seller who created the coupon
+}
+
+// CouponRedemption records a coupon applied at checkout. A checkout that
+// spans several sellers creates one redemption shared by all of its orders.
+type CouponRedemption struct {
+	gorm.Model
+	CouponID uint    `json:"coupon_id" gorm:"not null;index"`                   // Foreign Key from Coupon
+	UserID   uint    `json:"user_id" gorm:"not null;index"`                     // Buyer who redeemed the coupon
+	Discount Money   `json:"discount" gorm:"embedded;embeddedPrefix:discount_"` // Total discount over all orders
+	Orders   []Order `json:"orders,omitempty" gorm:"foreignKey:CouponRedemptionID"`
+	Coupon   Coupon  `json:"-" gorm:"foreignKey:CouponID"`
+}
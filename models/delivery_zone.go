@@ -0,0 +1,33 @@
+// models/delivery_zone.go
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// Delivery fee types of a delivery zone. Every type charges the zone's base
+// fee; the per-km and per-weight types add the zone's rate per unit.
+const (
+	DeliveryFeeFlat      = "flat"       // Base fee per order
+	DeliveryFeePerKm     = "per_km"     // Base fee plus rate per kilometre from the farm to the delivery district
+	DeliveryFeePerWeight = "per_weight" // Base fee plus rate per kilogram ordered
+)
+
+// DeliveryFeeTypes lists the valid delivery fee types
+var DeliveryFeeTypes = []string{DeliveryFeeFlat, DeliveryFeePerKm, DeliveryFeePerWeight}
+
+// DeliveryZone is a group of districts a seller delivers to on the same terms.
+// A district belongs to at most one zone of a seller.
+type DeliveryZone struct {
+	gorm.Model
+	SellerID         uint   `json:"seller_id" gorm:"not null;index"`                                       // Foreign Key from User (Seller)
+	Name             string `json:"name" gorm:"not null"`                                                  // e.g. "City centre"
+	FeeType          string `json:"fee_type" gorm:"not null;default:'flat'"`                               // One of the DeliveryFee constants
+	BaseFee          Money  `json:"base_fee" gorm:"embedded;embeddedPrefix:base_fee_"`                     // Charged on every order
+	Rate             Money  `json:"rate" gorm:"embedded;embeddedPrefix:rate_"`                             // Per kilometre or kilogram, unused for flat fees
+	FreeDeliveryFrom Money  `json:"free_delivery_from" gorm:"embedded;embeddedPrefix:free_delivery_from_"` // Basket value from which delivery is free, zero for never
+	MinimumBasket    Money  `json:"minimum_basket" gorm:"embedded;embeddedPrefix:minimum_basket_"`         // Smallest basket value delivered, zero for any
+
+	// Relationships
+	Districts []District `json:"districts" gorm:"many2many:delivery_zone_districts"` // Districts in the zone
+}
@@ -10,6 +10,8 @@ type District struct {
 	gorm.Model
 	Name      string    `json:"name" gorm:"unique;not null"`
 	CountryID uint      `json:"country_id"`
+	Latitude  *float64  `json:"latitude"`  // Centre of the district, used when a seller's farm has no coordinates
+	Longitude *float64  `json:"longitude"` // Centre of the district
 	Country   Country   `json:"country"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
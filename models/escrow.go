@@ -0,0 +1,27 @@
+// models/escrow.go
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Escrow hold statuses
+const (
+	EscrowStatusHeld     = "held"     // Buyer funds are held by the platform
+	EscrowStatusReleased = "released" // Funds were paid out to the seller
+	EscrowStatusRefunded = "refunded" // Funds were returned to the buyer
+)
+
+// EscrowHold tracks the buyer funds of a wallet-paid order that are held by
+// the platform until the buyer confirms delivery
+type EscrowHold struct {
+	gorm.Model
+	OrderID   uint       `json:"order_id" gorm:"uniqueIndex;not null"`          // Foreign Key from Order
+	BuyerID   uint       `json:"buyer_id" gorm:"not null"`                      // Foreign Key from User (Buyer)
+	SellerID  uint       `json:"seller_id" gorm:"not null"`                     // Foreign Key from User (Seller)
+	Amount    Money      `json:"amount" gorm:"embedded;embeddedPrefix:amount_"` // Amount held
+	Status    string     `json:"status" gorm:"default:'held';index"`            // One of the EscrowStatus constants
+	SettledAt *time.Time `json:"settled_at"`                                    // When the funds were released or refunded
+}
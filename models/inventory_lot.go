@@ -0,0 +1,54 @@
+// models/inventory_lot.go
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Inventory lot statuses
+const (
+	LotStatusAvailable = "available" // Lot has sellable stock left
+	LotStatusDepleted  = "depleted"  // All stock of the lot was sold
+	LotStatusExpired   = "expired"   // Lot passed its best-before date and its remaining stock was written off
+)
+
+// InventoryLot is one harvest of a product variant held in stock. The stock of
+// a variant is the sum of the remaining quantity of its sellable lots, and
+// orders are fulfilled from the lot that expires first.
+type InventoryLot struct {
+	gorm.Model
+	VariantID       uint       `json:"variant_id" gorm:"not null;index"`                 // Foreign Key from ProductVariant
+	LotCode         string     `json:"lot_code" gorm:"size:64;uniqueIndex;not null"`     // Code printed on the packaging, unique over all lots
+	HarvestDate     time.Time  `json:"harvest_date" gorm:"not null"`                     // When the produce was harvested
+	BestBefore      *time.Time `json:"best_before" gorm:"index"`                         // Lot is written off after this time; nil if it does not expire
+	StorageLocation string     `json:"storage_location"`                                 // Where the lot is kept, e.g. "Cold room 2"
+	Quantity        float64    `json:"quantity" gorm:"not null"`                         // Quantity received, in the variant's unit of measure
+	Remaining       float64    `json:"remaining" gorm:"not null"`                        // Quantity not yet sold or written off
+	WrittenOff      float64    `json:"written_off" gorm:"default:0"`                     // Quantity written off when the lot expired
+	Status          string     `json:"status" gorm:"default:'available';index;not null"` // One of the LotStatus constants
+	WrittenOffAt    *time.Time `json:"written_off_at"`                                   // When the lot was written off
+
+	// Relationships
+	Variant *ProductVariant `json:"variant,omitempty" gorm:"foreignKey:VariantID"`
+	Events  []LotEvent      `json:"events,omitempty" gorm:"foreignKey:LotID"` // Handling history of the lot
+}
+
+// Sellable reports whether stock can still be sold from the lot at time t
+func (lot InventoryLot) Sellable(t time.Time) bool {
+	return lot.Status == LotStatusAvailable && lot.Remaining > 0 &&
+		(lot.BestBefore == nil || lot.BestBefore.After(t))
+}
+
+// LotAllocation records the quantity of an order line that was fulfilled from a lot
+type LotAllocation struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	OrderItemID uint      `json:"order_item_id" gorm:"not null;index"` // Foreign Key from OrderItem
+	LotID       uint      `json:"lot_id" gorm:"not null;index"`        // Foreign Key from InventoryLot
+	Quantity    float64   `json:"quantity" gorm:"not null"`            // Quantity taken from the lot
+	CreatedAt   time.Time `json:"created_at"`
+
+	// Relationships
+	Lot InventoryLot `json:"lot" gorm:"foreignKey:LotID"`
+}
@@ -0,0 +1,75 @@
+// models/ledger.go
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Ledger accounts that do not belong to a wallet
+const (
+	LedgerAccountPlatformCommission = "platform:commission"          // Commission earned by the marketplace
+	LedgerAccountEscrow             = "platform:escrow"              // Buyer funds held until delivery is confirmed
+	LedgerAccountPendingWithdrawals = "platform:pending_withdrawals" // Wallet funds waiting to be paid out
+	LedgerAccountTopUps             = "external:topups"              // Money that entered the platform through top-ups
+	LedgerAccountPayouts            = "external:payouts"             // Money that left the platform through payouts
+	LedgerAccountOpeningBalances    = "equity:opening_balances"      // Wallet balances that existed before the ledger
+)
+
+// ErrLedgerImmutable is returned when code tries to change or remove posted ledger data
+var ErrLedgerImmutable = errors.New("ledger entries are append-only")
+
+// WalletAccount returns the ledger account name of a wallet
+func WalletAccount(walletID uint) string {
+	return fmt.Sprintf("wallet:%d", walletID)
+}
+
+// JournalEntry groups the ledger lines of one balanced money movement, e.g. the
+// payment of an order. Journal entries are never updated or deleted; mistakes
+// are corrected by posting a reversing entry.
+type JournalEntry struct {
+	ID          uint          `json:"id" gorm:"primaryKey"`
+	Reference   string        `json:"reference" gorm:"uniqueIndex;size:191;not null"` // Unique business reference, e.g. "order:12:payment"
+	Description string        `json:"description"`
+	OrderID     *uint         `json:"order_id" gorm:"index"` // Order the movement relates to, if any
+	Lines       []LedgerEntry `json:"lines" gorm:"foreignKey:JournalEntryID"`
+	CreatedAt   time.Time     `json:"created_at"`
+}
+
+// LedgerEntry is one debit or credit line of a journal entry. For wallet
+// accounts a credit increases and a debit decreases the wallet balance.
+type LedgerEntry struct {
+	ID             uint      `json:"id" gorm:"primaryKey"`
+	JournalEntryID uint      `json:"journal_entry_id" gorm:"not null;index"`
+	Account        string    `json:"account" gorm:"size:191;not null;index"`
+	WalletID       *uint     `json:"wallet_id" gorm:"index"` // Set when Account is a wallet account
+	Currency       string    `json:"currency" gorm:"size:3"`
+	DebitMinor     int64     `json:"debit_minor" gorm:"not null;default:0"`  // Debit in minor units of Currency
+	CreditMinor    int64     `json:"credit_minor" gorm:"not null;default:0"` // Credit in minor units of Currency
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// Debit returns the debit of the line as Money
+func (e LedgerEntry) Debit() Money {
+	return NewMoney(e.DebitMinor, e.Currency)
+}
+
+// Credit returns the credit of the line as Money
+func (e LedgerEntry) Credit() Money {
+	return NewMoney(e.CreditMinor, e.Currency)
+}
+
+// BeforeUpdate prevents changing posted journal entries
+func (JournalEntry) BeforeUpdate(*gorm.DB) error { return ErrLedgerImmutable }
+
+// BeforeDelete prevents removing posted journal entries
+func (JournalEntry) BeforeDelete(*gorm.DB) error { return ErrLedgerImmutable }
+
+// BeforeUpdate prevents changing posted ledger lines
+func (LedgerEntry) BeforeUpdate(*gorm.DB) error { return ErrLedgerImmutable }
+
+// BeforeDelete prevents removing posted ledger lines
+func (LedgerEntry) BeforeDelete(*gorm.DB) error { return ErrLedgerImmutable }
@@ -0,0 +1,230 @@
+// models/money.go
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// DefaultCurrency is the ISO 4217 code of the currency the marketplace trades in
+var DefaultCurrency = "BDT"
+
+var (
+	// ErrCurrencyMismatch is returned when amounts in different currencies are combined
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+	// ErrInvalidMoney is returned when an amount cannot be parsed exactly
+	ErrInvalidMoney = errors.New("invalid money amount")
+)
+
+// currencyExponents lists currencies that do not have two minor unit digits
+var currencyExponents = map[string]int{
+	"JPY": 0,
+	"KRW": 0,
+	"KWD": 3,
+	"OMR": 3,
+}
+
+// Money is a fixed-point amount stored in the minor units of its currency, so
+// 1250 in BDT is 12.50 taka. Embedded in a model with
+// gorm:"embedded;embeddedPrefix:price_" it is stored in the price_minor and
+// price_currency columns.
+//
+// Rounding rules: percentages (discounts, commission) and prices of
+// fractional quantities are rounded half away from zero to the nearest minor
+// unit, and when an amount is split the rounded share is taken first and the
+// rest is the exact remainder, so the parts always add up to the whole.
+type Money struct {
+	Minor    int64  `gorm:"not null;default:0"` // Amount in minor units
+	Currency string `gorm:"size:3"`             // ISO 4217 currency code
+}
+
+// NewMoney returns an amount of minor units in currency
+func NewMoney(minor int64, currency string) Money {
+	return Money{Minor: minor, Currency: currency}
+}
+
+// MoneyFromFloat converts a floating point amount, rounding half away from
+// zero to the nearest minor unit. It is only meant for migrating legacy data.
+func MoneyFromFloat(amount float64, currency string) Money {
+	return Money{Minor: int64(math.Round(amount * math.Pow10(CurrencyExponent(currency)))), Currency: currency}
+}
+
+// ParseMoney parses a decimal amount such as "12.50" exactly. Amounts with
+// more decimal places than the currency has minor units are rejected.
+func ParseMoney(amount, currency string) (Money, error) {
+	amount = strings.TrimSpace(amount)
+	negative := strings.HasPrefix(amount, "-")
+	amount = strings.TrimPrefix(strings.TrimPrefix(amount, "-"), "+")
+
+	whole, fraction, _ := strings.Cut(amount, ".")
+	digits := CurrencyExponent(currency)
+	if whole == "" && fraction == "" || len(fraction) > digits {
+		return Money{}, ErrInvalidMoney
+	}
+	fraction += strings.Repeat("0", digits-len(fraction))
+
+	var minor int64
+	for _, r := range whole + fraction {
+		if r < '0' || r > '9' {
+			return Money{}, ErrInvalidMoney
+		}
+		if minor > (math.MaxInt64-9)/10 {
+			return Money{}, ErrInvalidMoney
+		}
+		minor = minor*10 + int64(r-'0')
+	}
+	if negative {
+		minor = -minor
+	}
+	return Money{Minor: minor, Currency: currency}, nil
+}
+
+// String formats the amount as a decimal, e.g. "12.50"
+func (m Money) String() string {
+	digits := CurrencyExponent(m.Currency)
+	if digits == 0 {
+		return fmt.Sprintf("%d", m.Minor)
+	}
+
+	sign, minor := "", m.Minor
+	if minor < 0 {
+		sign, minor = "-", -minor
+	}
+	scale := int64(math.Pow10(digits))
+	return fmt.Sprintf("%s%d.%0*d", sign, minor/scale, digits, minor%scale)
+}
+
+// Add returns m + other. Both amounts must be in the same currency; a zero
+// value Money without a currency takes the currency of the other amount.
+func (m Money) Add(other Money) Money {
+	return Money{Minor: m.Minor + other.Minor, Currency: m.commonCurrency(other)}
+}
+
+// Sub returns m - other under the same currency rules as Add
+func (m Money) Sub(other Money) Money {
+	return Money{Minor: m.Minor - other.Minor, Currency: m.commonCurrency(other)}
+}
+
+// Mul returns m multiplied by a whole quantity
+func (m Money) Mul(quantity int64) Money {
+	return Money{Minor: m.Minor * quantity, Currency: m.Currency}
+}
+
+// MulQuantity returns m multiplied by a fractional quantity such as 2.5 kg,
+// rounded half away from zero to the nearest minor unit
+func (m Money) MulQuantity(quantity float64) Money {
+	return Money{Minor: int64(math.Round(float64(m.Minor) * quantity)), Currency: m.Currency}
+}
+
+// Percent returns percent % of m, rounded half away from zero
+func (m Money) Percent(percent float64) Money {
+	basisPoints := int64(math.Round(percent * 100))
+	return Money{Minor: roundDiv(m.Minor*basisPoints, 10000), Currency: m.Currency}
+}
+
+// Split divides m into a share of rate (0.05 for 5%) and the remainder. The
+// share is rounded half away from zero and the two parts always add up to m.
+func (m Money) Split(rate float64) (share, remainder Money) {
+	share = m.Percent(rate * 100)
+	return share, m.Sub(share)
+}
+
+// Cmp compares m with other and returns -1, 0 or +1
+func (m Money) Cmp(other Money) int {
+	m.commonCurrency(other)
+	switch {
+	case m.Minor < other.Minor:
+		return -1
+	case m.Minor > other.Minor:
+		return 1
+	}
+	return 0
+}
+
+// IsZero reports whether the amount is zero
+func (m Money) IsZero() bool {
+	return m.Minor == 0
+}
+
+// IsPositive reports whether the amount is greater than zero
+func (m Money) IsPositive() bool {
+	return m.Minor > 0
+}
+
+// MarshalJSON encodes the amount as {"amount": "12.50", "currency": "BDT"}
+func (m Money) MarshalJSON() ([]byte, error) {
+	currency := m.Currency
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+	return json.Marshal(struct {
+		Amount   string `json:"amount"`
+		Currency string `json:"currency"`
+	}{Money{Minor: m.Minor, Currency: currency}.String(), currency})
+}
+
+// UnmarshalJSON accepts {"amount": "12.50", "currency": "BDT"} as well as a
+// bare decimal string or number in DefaultCurrency. Numbers are parsed from
+// their decimal text, never through a float.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	var object struct {
+		Amount   json.Number `json:"amount"`
+		Currency string      `json:"currency"`
+	}
+	if len(data) > 0 && data[0] == '{' {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&object); err != nil {
+			return err
+		}
+	} else {
+		object.Amount = json.Number(strings.Trim(string(data), `"`))
+	}
+
+	if object.Currency == "" {
+		object.Currency = DefaultCurrency
+	}
+	parsed, err := ParseMoney(object.Amount.String(), strings.ToUpper(object.Currency))
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+func (m Money) commonCurrency(other Money) string {
+	switch {
+	case m.Currency == "":
+		return other.Currency
+	case other.Currency == "" || other.Currency == m.Currency:
+		return m.Currency
+	}
+	// Amounts are validated against DefaultCurrency where they enter the
+	// system, so mixing currencies here is a programming error
+	panic(fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, other.Currency))
+}
+
+// CurrencyExponent returns the number of minor unit digits of a currency
+func CurrencyExponent(currency string) int {
+	if digits, ok := currencyExponents[currency]; ok {
+		return digits
+	}
+	return 2
+}
+
+// roundDiv divides a by b rounding half away from zero
+func roundDiv(a, b int64) int64 {
+	if a < 0 {
+		return -((-a + b/2) / b)
+	}
+	return (a + b/2) / b
+}
@@ -0,0 +1,35 @@
+// models/notification.go
+package models
+
+import (
+	"time"
+)
+
+// Notification types
+const (
+	NotificationSeasonEnding = "season_ending"    // Seller: a product's season ends soon
+	NotificationDelisted     = "product_delisted" // Seller: a product was hidden after its season ended
+	NotificationBackInSeason = "back_in_season"   // Buyer: a product they subscribed to is listed again
+)
+
+// Notification is an in-app message to a user about something that happened
+// to them or to something they follow
+type Notification struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    uint       `json:"user_id" gorm:"not null;index"` // Foreign Key from User (Recipient)
+	Type      string     `json:"type" gorm:"not null"`          // One of the Notification constants
+	Title     string     `json:"title" gorm:"not null"`
+	Body      string     `json:"body"`
+	ProductID *uint      `json:"product_id"` // Product the notification is about, if any
+	ReadAt    *time.Time `json:"read_at"`    // When the user read it, nil while unread
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// SeasonSubscription asks for a notification when an out-of-season product
+// is listed again. It is removed once the notification is sent.
+type SeasonSubscription struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	UserID    uint      `json:"user_id" gorm:"not null;uniqueIndex:idx_season_subscription"`    // Foreign Key from User
+	ProductID uint      `json:"product_id" gorm:"not null;uniqueIndex:idx_season_subscription"` // Foreign Key from Product
+	CreatedAt time.Time `json:"created_at"`
+}
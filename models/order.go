@@ -7,19 +7,83 @@ import (
 	"gorm.io/gorm"
 )
 
+// Order statuses. An order moves through these states as described by the
+// transitions in services.TransitionOrder.
+const (
+	OrderStatusPending        = "pending"
+	OrderStatusConfirmed      = "confirmed"
+	OrderStatusPacked         = "packed"
+	OrderStatusOutForDelivery = "out_for_delivery"
+	OrderStatusDelivered      = "delivered"
+	OrderStatusCancelled      = "cancelled"
+	OrderStatusRejected       = "rejected"
+	OrderStatusRefunded       = "refunded"
+)
+
+// Payment methods of an order
+const (
+	PaymentMethodWallet         = "wallet"
+	PaymentMethodCashOnDelivery = "cash_on_delivery"
+)
+
+// Order is the header of an order placed by a buyer with a single seller
 type Order struct {
 	gorm.Model
-	ProductID        uint      `json:"product_id" gorm:"not null"`         // Foreign Key from Product
-	BuyerID          uint      `json:"buyer_id" gorm:"not null"`           // Foreign Key from User (Buyer)
-	SellerID         uint      `json:"seller_id" gorm:"not null"`          // Foreign Key from User (Seller)
-	Quantity         int       `json:"quantity" gorm:"not null"`           // Quantity of product ordered
-	TotalPrice       float64   `json:"total_price" gorm:"not null"`        // Total price of the order
-	Status           string    `json:"status" gorm:"default:'Pending'"`    // Status of the order
-	OrderDateTime    time.Time `json:"order_date_time" gorm:"not null"`    // Date and time when the order was placed
-	DeliveryDateTime time.Time `json:"delivery_date_time" gorm:"not null"` // Calculated delivery date and time
+	BuyerID            uint      `json:"buyer_id" gorm:"not null"`                                        // Foreign Key from User (Buyer)
+	SellerID           uint      `json:"seller_id" gorm:"not null"`                                       // Foreign Key from User (Seller)
+	Subtotal           Money     `json:"subtotal" gorm:"embedded;embeddedPrefix:subtotal_"`               // Sum of list prices of the order lines
+	DiscountTotal      Money     `json:"discount_total" gorm:"embedded;embeddedPrefix:discount_total_"`   // Sum of product discounts
+	CouponDiscount     Money     `json:"coupon_discount" gorm:"embedded;embeddedPrefix:coupon_discount_"` // Share of the coupon discount applied to this order
+	DeliveryFee        Money     `json:"delivery_fee" gorm:"embedded;embeddedPrefix:delivery_fee_"`       // Fee for delivering the order
+	TaxTotal           Money     `json:"tax_total" gorm:"embedded;embeddedPrefix:tax_total_"`             // Tax on the goods after all discounts
+	TotalPrice         Money     `json:"total_price" gorm:"embedded;embeddedPrefix:total_price_"`         // Amount the buyer pays: Subtotal - DiscountTotal - CouponDiscount + DeliveryFee + TaxTotal
+	Status             string    `json:"status" gorm:"default:'pending'"`                                 // Status of the order, one of the OrderStatus constants
+	PaymentMethod      string    `json:"payment_method" gorm:"default:'cash_on_delivery'"`                // How the buyer pays, one of the PaymentMethod constants
+	StockReserved      bool      `json:"stock_reserved" gorm:"default:false"`                             // Whether the pending order holds a stock reservation; false for orders placed before reservations existed
+	OrderDateTime      time.Time `json:"order_date_time" gorm:"not null"`                                 // Date and time when the order was placed
+	DeliveryDateTime   time.Time `json:"delivery_date_time" gorm:"not null"`                              // Calculated delivery date and time
+	CouponRedemptionID *uint     `json:"coupon_redemption_id" gorm:"index"`                               // Coupon redemption of the checkout that created the order
+	DeliveryAddress    string    `json:"delivery_address"`                                                // Address the order is delivered to
+	DeliveryDistrictID *uint     `json:"delivery_district_id"`                                            // District the order is delivered to
+	DeliveryZoneID     *uint     `json:"delivery_zone_id"`                                                // Delivery zone that set the delivery fee, nil for the default fee
 
 	// Relationships
-	Product Product `json:"product" gorm:"foreignKey:ProductID"` // Relationship to Product
-	Buyer   User    `json:"buyer" gorm:"foreignKey:BuyerID"`     // Relationship to User (Buyer)
-	Seller  User    `json:"seller" gorm:"foreignKey:SellerID"`   // Relationship to User (Seller)
+	Items  []OrderItem  `json:"items" gorm:"foreignKey:OrderID"`            // Order lines
+	Events []OrderEvent `json:"events" gorm:"foreignKey:OrderID"`           // Status history
+	Escrow *EscrowHold  `json:"escrow,omitempty" gorm:"foreignKey:OrderID"` // Escrow hold of a wallet-paid order
+	Buyer  User         `json:"buyer" gorm:"foreignKey:BuyerID"`            // Relationship to User (Buyer)
+	Seller User         `json:"seller" gorm:"foreignKey:SellerID"`          // Relationship to User (Seller)
+}
+
+// OrderItem is a single product variant line of an order. Prices are copied
+// from the variant at checkout time so order history is not affected by later
+// price changes.
+type OrderItem struct {
+	gorm.Model
+	OrderID   uint    `json:"order_id" gorm:"not null;index"`                        // Foreign Key from Order
+	ProductID uint    `json:"product_id" gorm:"not null;index"`                      // Foreign Key from Product
+	VariantID uint    `json:"variant_id" gorm:"not null;index"`                      // Foreign Key from ProductVariant
+	Quantity  float64 `json:"quantity" gorm:"not null"`                              // Quantity ordered in the unit of the variant
+	ListPrice Money   `json:"list_price" gorm:"embedded;embeddedPrefix:list_price_"` // Variant price per unit before discounts
+	UnitPrice Money   `json:"unit_price" gorm:"embedded;embeddedPrefix:unit_price_"` // Unit price after discount
+	Discount  float64 `json:"discount"`                                              // Discount percentage applied to the unit price
+	PriceRule string  `json:"price_rule"`                                            // Price rule that set the unit price, one of the PriceRule constants
+	LineTotal Money   `json:"line_total" gorm:"embedded;embeddedPrefix:line_total_"` // UnitPrice * Quantity
+
+	// Relationships
+	Product Product         `json:"product" gorm:"foreignKey:ProductID"`          // Relationship to Product
+	Variant ProductVariant  `json:"variant" gorm:"foreignKey:VariantID"`          // Relationship to ProductVariant
+	Lots    []LotAllocation `json:"lots,omitempty" gorm:"foreignKey:OrderItemID"` // Lots the line was fulfilled from
+}
+
+// OrderEvent records a single status transition of an order
+type OrderEvent struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	OrderID    uint      `json:"order_id" gorm:"not null;index"` // Foreign Key from Order
+	FromStatus string    `json:"from_status"`                    // Status before the transition, empty when the order is created
+	ToStatus   string    `json:"to_status" gorm:"not null"`      // Status after the transition
+	ActorID    *uint     `json:"actor_id"`                       // User who made the transition, nil for the system
+	ActorRole  string    `json:"actor_role"`                     // Role in which the actor acted (buyer, seller, admin, system)
+	Note       string    `json:"note"`                           // Optional reason or comment
+	CreatedAt  time.Time `json:"created_at"`                     // Time of the transition
 }
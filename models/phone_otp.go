@@ -0,0 +1,25 @@
+// models/phone_otp.go
+package models
+
+import (
+	"time"
+)
+
+// PhoneOTP is a one-time code sent by SMS to log in or sign up with a mobile
+// number. Only a hash of the code is stored.
+type PhoneOTP struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	PhoneNumber string     `json:"phone_number" gorm:"size:16;not null;index"` // E.164 number the code was sent to
+	CodeHash    string     `json:"-" gorm:"size:64;not null"`                  // Hex SHA-256 of the number and code
+	ExpiresAt   time.Time  `json:"expires_at"`                                 // Expiry of the code
+	Attempts    int        `json:"attempts"`                                   // Wrong codes entered against it
+	ConsumedAt  *time.Time `json:"consumed_at"`                                // When the code was used, or superseded by a newer one
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// PhoneOTPLock is a row per mobile number that code requests lock before
+// counting the codes sent to it, so concurrent requests for the same number,
+// including its first, are rate limited one after another
+type PhoneOTPLock struct {
+	PhoneNumber string `gorm:"primaryKey;size:16"` // E.164 number
+}
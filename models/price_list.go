@@ -0,0 +1,47 @@
+// models/price_list.go
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// Price rules that can set the unit price of an order line. The lowest price
+// available to the buyer wins; discounts do not stack.
+const (
+	PriceRuleList       = "list"        // Variant price
+	PriceRulePromo      = "promo"       // Variant price less the promotional discount of the product
+	PriceRuleTier       = "tier"        // Quantity price tier of the variant
+	PriceRuleBuyerGroup = "buyer_group" // Price list of a buyer group the buyer belongs to
+)
+
+// ProductPriceTier is a quantity break price of a product variant, e.g. 10 kg and more
+// at a lower price. The tier with the highest MinQuantity that the ordered
+// quantity reaches applies.
+type ProductPriceTier struct {
+	gorm.Model
+	VariantID   uint    `json:"variant_id" gorm:"not null;index"`            // Foreign Key from ProductVariant
+	MinQuantity float64 `json:"min_quantity" gorm:"not null"`                // Smallest quantity the price applies to
+	Price       Money   `json:"price" gorm:"embedded;embeddedPrefix:price_"` // Unit price from MinQuantity upwards
+}
+
+// BuyerGroup is a group of buyers, such as registered restaurants, that buy
+// at the prices of its price list
+type BuyerGroup struct {
+	gorm.Model
+	Name        string `json:"name" gorm:"size:100;uniqueIndex;not null"`
+	Description string `json:"description"`
+
+	// Relationships
+	Members []User            `json:"members,omitempty" gorm:"many2many:buyer_group_members"` // Buyers in the group
+	Prices  []BuyerGroupPrice `json:"prices,omitempty" gorm:"foreignKey:BuyerGroupID"`        // Price list of the group
+}
+
+// BuyerGroupPrice is an entry on the price list of a buyer group. Entries for
+// the same variant with different MinQuantity values work like price tiers.
+type BuyerGroupPrice struct {
+	gorm.Model
+	BuyerGroupID uint    `json:"buyer_group_id" gorm:"not null;index"`        // Foreign Key from BuyerGroup
+	VariantID    uint    `json:"variant_id" gorm:"not null;index"`            // Foreign Key from ProductVariant
+	MinQuantity  float64 `json:"min_quantity" gorm:"not null;default:0"`      // Smallest quantity the price applies to, 0 for any
+	Price        Money   `json:"price" gorm:"embedded;embeddedPrefix:price_"` // Unit price for the group
+}
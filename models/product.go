@@ -9,25 +9,25 @@ type Product struct {
 	ID                uint       `json:"id" gorm:"primaryKey"`
 	Name              string     `json:"name"`
 	Description       string     `json:"description"`
-	Price             float64    `json:"price"`
-	Discount          float64    `json:"discount"`           // New field for discount percentage
-	IsPromoSale       bool       `json:"is_promo_sale"`      // New field for promotional sale
-	SeasonExpiryDate  *time.Time `json:"season_expiry_date"` // New field for seasonal expiry date
-	Stock             int        `json:"stock"`
-	CategoryID        uint       `json:"category_id" gorm:"not null"`        // Foreign Key
-	UnitOfMeasureID   uint       `json:"unit_of_measure_id" gorm:"not null"` // Foreign Key
+	Discount          float64    `json:"discount"`                          // Discount percentage, applied to the price of every variant with Money.Percent
+	IsPromoSale       bool       `json:"is_promo_sale"`                     // New field for promotional sale
+	SeasonExpiryDate  *time.Time `json:"season_expiry_date"`                // New field for seasonal expiry date
+	SeasonStartDate   *time.Time `json:"season_start_date"`                 // Product is listed again on this date after its season ended, then on the same day every year
+	DelistedAt        *time.Time `json:"delisted_at" gorm:"index"`          // When the product was hidden for being out of season, nil while listed
+	SeasonNoticeAt    *time.Time `json:"-"`                                 // When the seller was told the season ends soon
+	CategoryID        uint       `json:"category_id" gorm:"not null;index"` // Foreign Key
 	ImageURL          string     `json:"image_url"`
 	VideoURL          string     `json:"video_url"`
-	Min_order_qty     float64    `json:"min_order_qty"`
-	SellerID          uint       `json:"seller_id" gorm:"not null"` // Foreign Key from User
-	Rating            float64    `json:"rating" gorm:"default:0"`
+	SellerID          uint       `json:"seller_id" gorm:"not null;index"` // Foreign Key from User
+	Rating            float64    `json:"rating" gorm:"default:0;index"`
 	DeliveryTime      int        `json:"delivery_time"`       // Duration in minutes or seconds
 	DeliveryTimeRules string     `json:"delivery_time_rules"` // New field for rules regarding delivery time
+	SearchText        string     `json:"-" gorm:"type:text"`  // Name, description, category and farm, indexed for full-text search
 	CreatedAt         time.Time  `json:"created_at"`
 	UpdatedAt         time.Time  `json:"updated_at"`
 
 	// Relationships
-	Category      Category      `json:"category" gorm:"foreignKey:CategoryID"`
-	UnitOfMeasure UnitOfMeasure `json:"unit_of_measure" gorm:"foreignKey:UnitOfMeasureID"`
-	Seller        User          `json:"seller" gorm:"foreignKey:SellerID"` // Relationship to User
+	Category Category         `json:"category" gorm:"foreignKey:CategoryID"`
+	Seller   User             `json:"seller" gorm:"foreignKey:SellerID"`    // Relationship to User
+	Variants []ProductVariant `json:"variants" gorm:"foreignKey:ProductID"` // Sellable options of the product
 }
@@ -0,0 +1,31 @@
+// models/product_variant.go
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// ProductVariant is a sellable option of a product, such as a grade, size or
+// pack of the same potatoes. Price, stock and order quantities are kept per
+// variant; carts, orders and stock reservations refer to the variant.
+type ProductVariant struct {
+	gorm.Model
+	ProductID       uint    `json:"product_id" gorm:"not null;index"`            // Foreign Key from Product
+	SKU             string  `json:"sku" gorm:"size:64;uniqueIndex;not null"`     // Stock keeping unit, unique over all variants
+	Name            string  `json:"name"`                                        // Display name, e.g. "Grade A, 5 kg sack"
+	Grade           string  `json:"grade"`                                       // Quality grade, e.g. "A"
+	Size            string  `json:"size"`                                        // Size, e.g. "large"
+	Pack            string  `json:"pack"`                                        // Packaging, e.g. "5 kg sack"
+	Price           Money   `json:"price" gorm:"embedded;embeddedPrefix:price_"` // Unit price before discounts
+	Stock           float64 `json:"stock"`                                       // Remaining quantity of sellable lots in UnitOfMeasure, including reserved stock
+	ReservedStock   float64 `json:"reserved_stock" gorm:"default:0"`             // Stock held by pending orders, not yet sold
+	UnitOfMeasureID uint    `json:"unit_of_measure_id" gorm:"not null"`          // Foreign Key from UnitOfMeasure
+	MinOrderQty     float64 `json:"min_order_qty"`                               // Smallest quantity a buyer may order, in UnitOfMeasure
+	UnitWeightKg    float64 `json:"unit_weight_kg"`                              // Shipping weight of one unit for weight-based delivery fees, unused for units of mass
+
+	// Relationships
+	Product       *Product           `json:"product,omitempty" gorm:"foreignKey:ProductID"`
+	UnitOfMeasure UnitOfMeasure      `json:"unit_of_measure" gorm:"foreignKey:UnitOfMeasureID"`
+	PriceTiers    []ProductPriceTier `json:"price_tiers" gorm:"foreignKey:VariantID"`  // Quantity price tiers
+	GroupPrices   []BuyerGroupPrice  `json:"group_prices" gorm:"foreignKey:VariantID"` // Buyer group price list entries
+}
@@ -0,0 +1,130 @@
+// models/role.go
+package models
+
+import (
+	"time"
+)
+
+// Roles a user can be assigned besides the buyer, seller and admin roles
+// defined with User. Every user is a buyer.
+const (
+	RoleMarketManager = "market_manager" // Runs the marketplace catalogue and product listings
+	RoleDeliveryAgent = "delivery_agent" // Takes orders out for delivery and hands them over
+	RoleSupport       = "support"        // Looks up users and orders to help them
+)
+
+// Roles lists the valid roles, most privileged first
+var Roles = []string{RoleAdmin, RoleMarketManager, RoleSupport, RoleDeliveryAgent, RoleSeller, RoleBuyer}
+
+// Permissions checked by middleware.RequirePermission. A role is granted a
+// permission either outright or, with the OwnScope suffix, only for the
+// resources the user owns, such as their own profile, products or orders.
+const (
+	PermissionUsersRead         = "users:read"
+	PermissionUsersUpdate       = "users:update"
+	PermissionUsersDelete       = "users:delete"
+	PermissionRolesAssign       = "roles:assign"
+	PermissionProductsCreate    = "products:create"
+	PermissionProductsUpdate    = "products:update"
+	PermissionProductsDelete    = "products:delete"
+	PermissionOrdersRead        = "orders:read"
+	PermissionOrdersUpdate      = "orders:update"
+	PermissionOrdersDeliver     = "orders:deliver" // Take orders out for delivery and hand them over
+	PermissionOrdersRefund      = "orders:refund"
+	PermissionCatalogManage     = "catalog:manage" // Countries, districts, categories and units
+	PermissionBuyerGroupsManage = "buyer_groups:manage"
+	PermissionWithdrawalsManage = "withdrawals:manage"
+	PermissionCouponsManage     = "coupons:manage"
+	PermissionDeliveryZones     = "delivery_zones:manage"
+	PermissionCertifications    = "certifications:manage"
+	PermissionReviewsWrite      = "reviews:write"
+	PermissionMessagesSend      = "messages:send"
+	PermissionMessagesRead      = "messages:read"
+)
+
+// OwnScope limits a permission to resources the user owns, e.g. "orders:read:own"
+const OwnScope = ":own"
+
+// RolePermissions lists the permissions granted to each role
+var RolePermissions = map[string][]string{
+	RoleBuyer: {
+		PermissionUsersRead + OwnScope,
+		PermissionUsersUpdate + OwnScope,
+		PermissionOrdersRead + OwnScope,
+		PermissionOrdersUpdate + OwnScope,
+		PermissionOrdersDeliver + OwnScope,
+		PermissionReviewsWrite + OwnScope,
+		PermissionMessagesSend,
+		PermissionMessagesRead + OwnScope,
+	},
+	RoleSeller: {
+		PermissionProductsCreate,
+		PermissionProductsUpdate + OwnScope,
+		PermissionProductsDelete + OwnScope,
+		PermissionCouponsManage + OwnScope,
+		PermissionDeliveryZones + OwnScope,
+		PermissionCertifications + OwnScope,
+	},
+	RoleMarketManager: {
+		PermissionProductsUpdate,
+		PermissionProductsDelete,
+		PermissionCatalogManage,
+		PermissionBuyerGroupsManage,
+		PermissionOrdersRead,
+	},
+	RoleDeliveryAgent: {
+		PermissionOrdersRead,
+		PermissionOrdersDeliver,
+	},
+	RoleSupport: {
+		PermissionUsersRead,
+		PermissionOrdersRead,
+	},
+	RoleAdmin: {
+		PermissionUsersRead,
+		PermissionUsersUpdate,
+		PermissionUsersDelete,
+		PermissionRolesAssign,
+		PermissionProductsCreate,
+		PermissionProductsUpdate,
+		PermissionProductsDelete,
+		PermissionOrdersRead,
+		PermissionOrdersUpdate,
+		PermissionOrdersDeliver,
+		PermissionOrdersRefund,
+		PermissionCatalogManage,
+		PermissionBuyerGroupsManage,
+		PermissionWithdrawalsManage,
+		PermissionCouponsManage,
+		PermissionDeliveryZones,
+		PermissionCertifications,
+		PermissionReviewsWrite,
+		PermissionMessagesSend,
+		PermissionMessagesRead,
+	},
+}
+
+// UserRole assigns a role to a user. The admin and seller roles are held
+// through User.IsAdmin and User.IsSeller instead, and every user is a buyer.
+type UserRole struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	UserID    uint      `json:"user_id" gorm:"not null;uniqueIndex:idx_user_role"` // Foreign Key from User
+	Role      string    `json:"role" gorm:"size:32;not null;uniqueIndex:idx_user_role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// HasPermission reports whether any of the roles grants the permission, and
+// whether it is granted only for the user's own resources
+func HasPermission(roles []string, permission string) (granted, ownOnly bool) {
+	for _, role := range roles {
+		for _, p := range RolePermissions[role] {
+			switch p {
+			case permission:
+				return true, false
+			case permission + OwnScope:
+				granted, ownOnly = true, true
+			}
+		}
+	}
+	return granted, ownOnly
+}
@@ -0,0 +1,46 @@
+// models/schema.go
+package models
+
+// All lists every model with a table, in the order the tables are migrated
+func All() []interface{} {
+	return []interface{}{
+		&Message{},
+		&Review{},
+		&Product{},
+		&ProductVariant{},
+		&InventoryLot{},
+		&LotAllocation{},
+		&LotEvent{},
+		&Certification{},
+		&Notification{},
+		&SeasonSubscription{},
+		&User{},
+		&UserRole{},
+		&Country{},
+		&Category{},
+		&District{},
+		&Order{},
+		&OrderItem{},
+		&OrderEvent{},
+		&Cart{},
+		&CartItem{},
+		&Wallet{},
+		&JournalEntry{},
+		&LedgerEntry{},
+		&EscrowHold{},
+		&WithdrawalRequest{},
+		&TopUp{},
+		&Coupon{},
+		&CouponRedemption{},
+		&ProductPriceTier{},
+		&BuyerGroup{},
+		&BuyerGroupPrice{},
+		&UnitOfMeasure{},
+		&DeliveryZone{},
+		&Session{},
+		&RefreshToken{},
+		&AccountToken{},
+		&PhoneOTP{},
+		&PhoneOTPLock{},
+	}
+}
@@ -0,0 +1,42 @@
+// models/session.go
+package models
+
+import (
+	"time"
+)
+
+// Reasons a session was revoked
+const (
+	SessionRevokedLogout    = "logout"     // The user logged out on the device
+	SessionRevokedLogoutAll = "logout_all" // The user logged out on all devices
+	SessionRevokedByUser    = "revoked"    // The user revoked the session from another device
+	SessionRevokedReuse     = "reuse"      // A rotated refresh token was used again, so it may have been stolen
+	SessionRevokedPassword  = "password"   // The password was reset, ending every session
+)
+
+// Session is a login of a user on one device. The refresh tokens of a session
+// form a family: each is used once to get the next, and reusing one revokes
+// the whole session. Access tokens carry the session ID and stop working when
+// it is revoked.
+type Session struct {
+	ID            uint       `json:"id" gorm:"primaryKey"`
+	UserID        uint       `json:"user_id" gorm:"not null;index"` // Foreign Key from User
+	Device        string     `json:"device"`                        // User agent of the client that logged in
+	IPAddress     string     `json:"ip_address"`                    // Address the session was last used from
+	LastUsedAt    time.Time  `json:"last_used_at"`                  // When a refresh token of the session was last used
+	ExpiresAt     time.Time  `json:"expires_at"`                    // When the current refresh token expires
+	RevokedAt     *time.Time `json:"revoked_at,omitempty"`          // When the session was revoked, nil while active
+	RevokedReason string     `json:"revoked_reason,omitempty"`      // One of the SessionRevoked constants
+	CreatedAt     time.Time  `json:"created_at"`                    // When the user logged in
+}
+
+// RefreshToken is a refresh token issued for a session. Only a hash of the
+// token is stored.
+type RefreshToken struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	SessionID uint       `json:"session_id" gorm:"not null;index"`      // Foreign Key from Session
+	TokenHash string     `json:"-" gorm:"size:64;uniqueIndex;not null"` // Hex SHA-256 of the token
+	ExpiresAt time.Time  `json:"expires_at"`                            // Expiry of the token
+	UsedAt    *time.Time `json:"used_at"`                               // When the token was exchanged for the next one
+	CreatedAt time.Time  `json:"created_at"`
+}
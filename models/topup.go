@@ -0,0 +1,30 @@
+// models/topup.go
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Top-up statuses
+const (
+	TopUpStatusPending   = "pending"   // Waiting for the payment gateway
+	TopUpStatusSucceeded = "succeeded" // Paid and credited to the wallet
+	TopUpStatusFailed    = "failed"    // The gateway reported the payment as failed
+	TopUpStatusAbandoned = "abandoned" // The user never completed the payment
+)
+
+// TopUp is an attempt to add money to a wallet through a payment gateway. The
+// wallet is only credited once the gateway confirms the payment.
+type TopUp struct {
+	gorm.Model
+	UserID           uint       `json:"user_id" gorm:"not null;index"`                 // Foreign Key from User
+	Amount           Money      `json:"amount" gorm:"embedded;embeddedPrefix:amount_"` // Amount to add to the wallet
+	Status           string     `json:"status" gorm:"default:'pending';index"`         // One of the TopUpStatus constants
+	Gateway          string     `json:"gateway"`                                       // Name of the payment gateway
+	GatewayReference *string    `json:"gateway_reference" gorm:"uniqueIndex;size:191"` // Payment reference at the gateway
+	CheckoutURL      string     `json:"checkout_url"`                                  // Where the user completes the payment
+	FailureReason    string     `json:"failure_reason"`
+	CompletedAt      *time.Time `json:"completed_at"`
+}
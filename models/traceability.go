@@ -0,0 +1,47 @@
+// models/traceability.go
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Lot event types, the handling steps of a lot from the field to the buyer
+const (
+	LotEventHarvested   = "harvested"   // Produce was harvested
+	LotEventStored      = "stored"      // Lot was put into storage
+	LotEventProcessed   = "processed"   // Lot was washed, sorted or graded
+	LotEventPacked      = "packed"      // Lot was packed for sale
+	LotEventTransported = "transported" // Lot was moved between locations
+	LotEventDispatched  = "dispatched"  // Stock of the lot was sent to a buyer
+	LotEventWrittenOff  = "written_off" // Lot expired and its remaining stock was written off
+	LotEventReturned    = "returned"    // Stock sent to a buyer came back from a cancelled or refunded order
+)
+
+// LotEventTypes lists the lot event types sellers can record themselves
+var LotEventTypes = []string{LotEventStored, LotEventProcessed, LotEventPacked, LotEventTransported}
+
+// LotEvent is a handling step of a lot, shown on its public traceability page
+type LotEvent struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	LotID        uint      `json:"lot_id" gorm:"not null;index"` // Foreign Key from InventoryLot
+	Type         string    `json:"type" gorm:"not null"`         // One of the LotEvent constants
+	Location     string    `json:"location"`                     // Where it happened, e.g. "Packhouse, Bogura"
+	Note         string    `json:"note"`                         // Optional details, e.g. "Kept at 4°C"
+	OccurredAt   time.Time `json:"occurred_at" gorm:"not null"`  // When it happened
+	RecordedByID *uint     `json:"-"`                            // User who recorded the event, nil for the system
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Certification is a certificate held by a seller's farm, such as organic or
+// GAP. Certifications valid at the harvest date are shown for a lot.
+type Certification struct {
+	gorm.Model
+	SellerID          uint       `json:"seller_id" gorm:"not null;index"` // Foreign Key from User (Seller)
+	Name              string     `json:"name" gorm:"not null"`            // Name of the standard, e.g. "Organic"
+	Issuer            string     `json:"issuer"`                          // Certification body
+	CertificateNumber string     `json:"certificate_number"`              // Number printed on the certificate
+	ValidFrom         *time.Time `json:"valid_from"`                      // Start of validity, nil if unknown
+	ValidUntil        *time.Time `json:"valid_until"`                     // End of validity, nil if it does not expire
+}
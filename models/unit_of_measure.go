@@ -5,11 +5,21 @@ import (
 	"time"
 )
 
+// Unit dimensions. Quantities can only be converted between units of the same dimension.
+const (
+	DimensionMass   = "mass"   // Base unit kilogram
+	DimensionVolume = "volume" // Base unit litre
+	DimensionCount  = "count"  // Base unit piece
+)
+
 // UnitOfMeasure struct
 type UnitOfMeasure struct {
 	ID           uint      `json:"id" gorm:"primaryKey"`
 	Name         string    `json:"name" gorm:"unique;not null"`
 	Abbreviation string    `json:"abbreviation"`
+	Dimension    string    `json:"dimension" gorm:"not null;default:'count'"` // One of the Dimension constants
+	ToBase       float64   `json:"to_base" gorm:"not null;default:1"`         // Base units in one unit, e.g. 0.001 for gram
+	StepSize     float64   `json:"step_size" gorm:"not null;default:1"`       // Quantities in this unit must be multiples of it, e.g. 0.5
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 }
@@ -4,20 +4,55 @@ import (
 	"time"
 )
 
+// User roles, carried in access tokens
+const (
+	RoleAdmin  = "admin"
+	RoleSeller = "seller"
+	RoleBuyer  = "buyer"
+)
+
 // User struct
 type User struct {
-	ID              uint      `json:"id" gorm:"primaryKey"`
-	Name            string    `json:"name"`
-	Email           string    `json:"email" gorm:"unique"`
-	Username        string    `json:"username" gorm:"unique"`
-	Password        string    `json:"-"`
-	ImageURL        string    `json:"image_url"`                             // Field for user image URL
-	IsSeller        bool      `json:"is_seller"`                             // New field to indicate if the user is a seller
-	DistrictID      *uint     `json:"district_id" gorm:"index"`              // Foreign key to District (pointer type)
-	DeliveryAddress string    `json:"delivery_address"`                      // New field for delivery address
-	MobileNumber    string    `json:"mobile_number"`                         // New field for mobile number
-	District        District  `json:"district" gorm:"foreignKey:DistrictID"` // Relationship with District
-	IsAdmin         bool      `json:"is_admin"`
-	CreatedAt       time.Time `json:"created_at"`
-	UpdatedAt       time.Time `json:"updated_at"`
+	ID               uint       `json:"id" gorm:"primaryKey"`
+	Name             string     `json:"name"`
+	Email            *string    `json:"email" gorm:"unique"` // Nil for farmers who signed up with only a mobile number
+	Username         string     `json:"username" gorm:"unique"`
+	Password         string     `json:"-"`
+	ImageURL         string     `json:"image_url"`                             // Field for user image URL
+	IsSeller         bool       `json:"is_seller"`                             // New field to indicate if the user is a seller
+	FarmName         string     `json:"farm_name"`                             // Public name of the seller's farm, shown on traceability pages
+	FarmAddress      string     `json:"farm_address"`                          // Address of the seller's farm
+	FarmLatitude     *float64   `json:"farm_latitude"`                         // Location of the farm, used to find produce near buyers
+	FarmLongitude    *float64   `json:"farm_longitude"`                        // Location of the farm
+	DistrictID       *uint      `json:"district_id" gorm:"index"`              // Foreign key to District (pointer type)
+	DeliveryAddress  string     `json:"delivery_address"`                      // New field for delivery address
+	MobileNumber     string     `json:"mobile_number" gorm:"index"`            // Mobile number in E.164 format, e.g. +8801712345678
+	MobileVerifiedAt *time.Time `json:"mobile_verified_at"`                    // When the user proved the mobile number with a one-time code
+	District         District   `json:"district" gorm:"foreignKey:DistrictID"` // Relationship with District
+	IsAdmin          bool       `json:"is_admin"`
+	EmailVerifiedAt  *time.Time `json:"email_verified_at"`                        // When the user confirmed the email address, nil until then
+	Roles            []UserRole `json:"roles,omitempty" gorm:"foreignKey:UserID"` // Roles assigned to the user, see RoleNames
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+// RoleNames returns every role the user holds: buyer, the assigned roles and
+// admin or seller if IsAdmin or IsSeller is set. Roles must be preloaded.
+func (u User) RoleNames() []string {
+	held := map[string]bool{RoleBuyer: true, RoleAdmin: u.IsAdmin, RoleSeller: u.IsSeller}
+	for _, role := range u.Roles {
+		held[role.Role] = true
+	}
+	names := []string{}
+	for _, role := range Roles {
+		if held[role] {
+			names = append(names, role)
+		}
+	}
+	return names
+}
+
+// Role returns the most privileged role of the user, one of the Role constants
+func (u User) Role() string {
+	return u.RoleNames()[0]
 }
@@ -2,8 +2,11 @@ package models
 
 import "gorm.io/gorm"
 
+// Wallet holds the money of a user on the platform. Balance is a cached
+// projection of the wallet's ledger entries and is only changed together with
+// a journal entry posted by services.PostJournal.
 type Wallet struct {
 	gorm.Model
-	UserID  uint    `json:"user_id"`
-	Balance float64 `json:"balance" gorm:"default:0"`
+	UserID  uint  `json:"user_id" gorm:"uniqueIndex"`
+	Balance Money `json:"balance" gorm:"embedded;embeddedPrefix:balance_"`
 }
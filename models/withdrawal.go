@@ -0,0 +1,41 @@
+// models/withdrawal.go
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Withdrawal request statuses
+const (
+	WithdrawalStatusPending    = "pending"    // Waiting for admin approval
+	WithdrawalStatusProcessing = "processing" // Approved and sent to the payout provider, or waiting to learn its outcome
+	WithdrawalStatusCompleted  = "completed"  // Paid out by the provider
+	WithdrawalStatusFailed     = "failed"     // The provider could not pay out, funds returned to the wallet
+	WithdrawalStatusRejected   = "rejected"   // Rejected by an admin, funds returned to the wallet
+)
+
+// Withdrawal destination types
+const (
+	WithdrawalDestinationBank        = "bank"
+	WithdrawalDestinationMobileMoney = "mobile_money"
+)
+
+// WithdrawalRequest is a request by a user to move money from their wallet to
+// a bank or mobile money account
+type WithdrawalRequest struct {
+	gorm.Model
+	UserID            uint       `json:"user_id" gorm:"not null;index"`                 // Foreign Key from User
+	WalletID          uint       `json:"wallet_id" gorm:"not null"`                     // Foreign Key from Wallet
+	Amount            Money      `json:"amount" gorm:"embedded;embeddedPrefix:amount_"` // Amount to pay out
+	DestinationType   string     `json:"destination_type" gorm:"not null"`              // One of the WithdrawalDestination constants
+	AccountName       string     `json:"account_name"`                                  // Name of the account holder
+	AccountNumber     string     `json:"account_number" gorm:"not null"`                // Bank account or mobile money number
+	BankName          string     `json:"bank_name"`                                     // Bank or mobile money operator
+	Status            string     `json:"status" gorm:"default:'pending';index"`         // One of the WithdrawalStatus constants
+	ProviderReference string     `json:"provider_reference"`                            // Reference returned by the payout provider
+	FailureReason     string     `json:"failure_reason"`                                // Why the payout failed or was rejected
+	ReviewedByID      *uint      `json:"reviewed_by_id"`                                // Admin who approved or rejected the request
+	ReviewedAt        *time.Time `json:"reviewed_at"`
+}
@@ -3,6 +3,8 @@ package routes
 import (
 	"farmers_market_backend/controllers"
 	"farmers_market_backend/middleware"
+	"farmers_market_backend/models"
+	"farmers_market_backend/services"
 
 	"github.com/gin-gonic/gin"
 )
@@ -14,48 +16,88 @@ func InitializeRoutes(router *gin.Engine) {
 	api.POST("/register", controllers.Register)
 	api.POST("/login", controllers.Login)
 	api.POST("/refresh-token", controllers.RefreshToken)
+	api.POST("/logout", middleware.AuthMiddleware(), controllers.Logout)
+	api.POST("/logout-all", middleware.AuthMiddleware(), controllers.LogoutAll)
 
-	userGroup := router.Group("/api/users")
-	{
-		userGroup.GET("/", controllers.GetUsers)                                  // Route for getting all users
-		userGroup.GET("/:id", middleware.IsItMyProfile(), controllers.GetUser)    // Route for getting a specific user by ID
-		userGroup.PUT("/:id", middleware.IsItMyProfile(), controllers.UpdateUser) // Route for updating user profile
-		userGroup.DELETE("/:id", middleware.IsAdmin(), controllers.DeleteUser)    // Route for updating user profile
+	// Email verification and password recovery with emailed links
+	api.POST("/verify-email", controllers.VerifyEmail)                    // Confirm the email address
+	api.POST("/verify-email/resend", controllers.ResendVerificationEmail) // Send a new verification link
+	api.POST("/forgot-password", controllers.ForgotPassword)              // Email a password reset link
+	api.POST("/reset-password", controllers.ResetPassword)                // Set a new password
+
+	// Passwordless login and sign-up with a code sent by SMS
+	api.POST("/otp/request", controllers.RequestOTP) // Send a code to a mobile number
+	api.POST("/otp/verify", controllers.VerifyOTP)   // Log in or sign up with the code
 
+	// Login sessions of the logged in user
+	sessionRoutes := router.Group("/api/sessions", middleware.AuthMiddleware())
+	{
+		sessionRoutes.GET("/", controllers.GetSessions)                // Get the active sessions
+		sessionRoutes.DELETE("/:sessionID", controllers.RevokeSession) // Log out a session
 	}
 
-	userGroup.Use(middleware.AuthMiddleware())
+	// Permissions are checked after authentication, so AuthMiddleware must come first
+	auth := middleware.AuthMiddleware()
+	can := middleware.RequirePermission
+
+	userGroup := router.Group("/api/users")
+	{
+		userGroup.GET("/", auth, can(models.PermissionUsersRead), controllers.GetUsers)                                      // Route for getting all users
+		userGroup.GET("/:id", auth, can(models.PermissionUsersRead, middleware.OwnsProfile("id")), controllers.GetUser)      // Route for getting a specific user by ID
+		userGroup.PUT("/:id", auth, can(models.PermissionUsersUpdate, middleware.OwnsProfile("id")), controllers.UpdateUser) // Route for updating user profile
+		userGroup.DELETE("/:id", auth, can(models.PermissionUsersDelete), controllers.DeleteUser)                            // Route for deleting a user
+		userGroup.GET("/:id/delivery-zones", controllers.GetSellerDeliveryZones)                                             // Where and on what terms a seller delivers
+	}
 
 	// Country routes
 	countryRoutes := router.Group("/api/countries")
 	{
 		countryRoutes.GET("/countries", controllers.GetCountries)
 		countryRoutes.GET("/countries/:id", controllers.GetCountry)
-		countryRoutes.POST("/countries", controllers.CreateCountry)
-		countryRoutes.PUT("/countries/:id", controllers.UpdateCountry)
-		countryRoutes.DELETE("/countries/:id", controllers.DeleteCountry)
+		countryRoutes.POST("/countries", auth, can(models.PermissionCatalogManage), controllers.CreateCountry)
+		countryRoutes.PUT("/countries/:id", auth, can(models.PermissionCatalogManage), controllers.UpdateCountry)
+		countryRoutes.DELETE("/countries/:id", auth, can(models.PermissionCatalogManage), controllers.DeleteCountry)
 	}
-	countryRoutes.Use(middleware.AuthMiddleware())
 
 	// District routes
 	districtRoutes := router.Group("/api/districts")
 	{
 		districtRoutes.GET("/districts", controllers.GetDistricts)
 		districtRoutes.GET("/districts/:id", controllers.GetDistrict)
-		districtRoutes.POST("/districts", controllers.CreateDistrict)
-		districtRoutes.PUT("/districts/:id", middleware.IsAdmin(), controllers.UpdateDistrict)
-		districtRoutes.DELETE("/districts/:id", middleware.AuthMiddleware(), middleware.IsAdmin(), controllers.DeleteDistrict)
+		districtRoutes.POST("/districts", auth, can(models.PermissionCatalogManage), controllers.CreateDistrict)
+		districtRoutes.PUT("/districts/:id", auth, can(models.PermissionCatalogManage), controllers.UpdateDistrict)
+		districtRoutes.DELETE("/districts/:id", auth, can(models.PermissionCatalogManage), controllers.DeleteDistrict)
 	}
-	districtRoutes.Use(middleware.AuthMiddleware())
 
 	// Product routes
 	productRoutes := router.Group("/api/products")
 	{
-		productRoutes.GET("/:productID", controllers.GetProduct)                                            // Get a single product
-		productRoutes.GET("/", controllers.GetAllProducts)                                                  // Get all products
-		productRoutes.POST("/", controllers.CreateProduct)                                                  // Create a new product
-		productRoutes.PUT("/:productID", middleware.IsSellerOfTheItem(), controllers.UpdateProduct)         // Update an existing product
-		productRoutes.DELETE("/:productID", middleware.IsCustomerForThisOrder(), controllers.DeleteProduct) // Delete a product
+		productRoutes.GET("/search", controllers.SearchProducts)                                                                                        // Search products with filters and facets
+		productRoutes.GET("/nearby", controllers.GetNearbyProducts)                                                                                     // Find products near a location or district
+		productRoutes.GET("/:productID", controllers.GetProduct)                                                                                        // Get a single product
+		productRoutes.GET("/", controllers.GetAllProducts)                                                                                              // Get all products
+		productRoutes.POST("/", auth, can(models.PermissionProductsCreate), controllers.CreateProduct)                                                  // Create a new product
+		productRoutes.PUT("/:productID", auth, can(models.PermissionProductsUpdate, middleware.OwnsProduct("productID")), controllers.UpdateProduct)    // Update an existing product
+		productRoutes.DELETE("/:productID", auth, can(models.PermissionProductsDelete, middleware.OwnsProduct("productID")), controllers.DeleteProduct) // Delete a product
+
+		// Variants and their wholesale pricing, managed by the seller of the product
+		manageProduct := can(models.PermissionProductsUpdate, middleware.OwnsProduct("productID"))
+		productRoutes.POST("/:productID/variants", auth, manageProduct, controllers.CreateVariant)                         // Add a variant
+		productRoutes.PUT("/:productID/variants/:variantID", auth, manageProduct, controllers.UpdateVariant)               // Update a variant
+		productRoutes.DELETE("/:productID/variants/:variantID", auth, manageProduct, controllers.DeleteVariant)            // Delete a variant
+		productRoutes.PUT("/:productID/variants/:variantID/price-tiers", auth, manageProduct, controllers.SetPriceTiers)   // Replace the quantity price tiers
+		productRoutes.PUT("/:productID/variants/:variantID/group-prices", auth, manageProduct, controllers.SetGroupPrices) // Replace the buyer group prices
+
+		// Harvest lots and the sellable stock they add up to
+		productRoutes.GET("/:productID/stock", controllers.GetProductStock)                                                       // Sellable stock per variant
+		productRoutes.GET("/:productID/variants/:variantID/lots", auth, manageProduct, controllers.GetLots)                       // List the lots of a variant
+		productRoutes.POST("/:productID/variants/:variantID/lots", auth, manageProduct, controllers.CreateLot)                    // Receive a harvest lot
+		productRoutes.PUT("/:productID/variants/:variantID/lots/:lotID", auth, manageProduct, controllers.UpdateLot)              // Update or correct a lot
+		productRoutes.POST("/:productID/variants/:variantID/lots/:lotID/events", auth, manageProduct, controllers.CreateLotEvent) // Record a handling step of a lot
+
+		// Back-in-season notifications for out-of-season products
+		productRoutes.POST("/:productID/season-subscription", middleware.AuthMiddleware(), controllers.SubscribeBackInSeason)     // Get notified when the product is back
+		productRoutes.DELETE("/:productID/season-subscription", middleware.AuthMiddleware(), controllers.UnsubscribeBackInSeason) // Cancel the notification
 	}
 	productRoutes.Use(middleware.AuthMiddleware())
 
@@ -63,58 +105,162 @@ func InitializeRoutes(router *gin.Engine) {
 
 	orderRoutes := router.Group("/api/orders")
 	{
-		orderRoutes.POST("/", controllers.PlaceOrder)                                                  // Create a new order
-		orderRoutes.GET("/:orderID", middleware.IsCustomerForThisOrder(), controllers.GetOrderDetails) // Get order details
-		orderRoutes.PUT("/:orderID", middleware.IsCustomerForThisOrder(), controllers.UpdateOrder)     // Update an existing order
-		orderRoutes.DELETE("/:orderID", middleware.IsCustomerForThisOrder(), controllers.DeleteOrder)  // Delete an order
+		orderRoutes.POST("/", middleware.AuthMiddleware(), controllers.PlaceOrder)                                                         // Create a new order for the logged in user
+		orderRoutes.GET("/:orderID", auth, can(models.PermissionOrdersRead, middleware.OwnsOrder("orderID")), controllers.GetOrderDetails) // Get order details
+		orderRoutes.PUT("/:orderID", auth, can(models.PermissionOrdersUpdate, middleware.OwnsOrder("orderID")), controllers.UpdateOrder)   // Update an existing order
+
+		// Order status transitions; which party may make each move is checked by services.TransitionOrder
+		updateOrder := can(models.PermissionOrdersUpdate, middleware.OwnsOrder("orderID"))
+		deliverOrder := can(models.PermissionOrdersDeliver, middleware.OwnsOrder("orderID"))
+		orderRoutes.POST("/:orderID/confirm", auth, updateOrder, controllers.ConfirmOrder)                      // Seller confirms a pending order
+		orderRoutes.POST("/:orderID/reject", auth, updateOrder, controllers.RejectOrder)                        // Seller rejects a pending order
+		orderRoutes.POST("/:orderID/pack", auth, updateOrder, controllers.PackOrder)                            // Seller packs a confirmed order
+		orderRoutes.POST("/:orderID/dispatch", auth, deliverOrder, controllers.DispatchOrder)                   // Seller or delivery agent sends a packed order out for delivery
+		orderRoutes.POST("/:orderID/deliver", auth, deliverOrder, controllers.DeliverOrder)                     // Buyer or delivery agent confirms delivery
+		orderRoutes.POST("/:orderID/cancel", auth, updateOrder, controllers.CancelOrder)                        // Buyer cancels before packing, admins also packed orders
+		orderRoutes.POST("/:orderID/refund", auth, can(models.PermissionOrdersRefund), controllers.RefundOrder) // Admin refunds a delivered order
 	}
 	orderRoutes.Use(middleware.AuthMiddleware())
 
+	// Notifications of the logged in user
+	notificationRoutes := router.Group("/api/notifications", middleware.AuthMiddleware())
+	{
+		notificationRoutes.GET("/", controllers.GetNotifications)                         // Get notifications, newest first
+		notificationRoutes.PUT("/:notificationID/read", controllers.MarkNotificationRead) // Mark a notification as read
+	}
+
+	// Public traceability pages of lots, linked from the QR code on the packaging
+	traceRoutes := router.Group("/api/trace")
+	{
+		traceRoutes.GET("/:lotCode", controllers.GetTrace)          // Get the traceability page of a lot
+		traceRoutes.GET("/:lotCode/qr", controllers.GetTraceQRCode) // Get a PNG QR code linking to it
+	}
+
+	// Farm certifications of the logged in seller
+	certificationRoutes := router.Group("/api/certifications", auth)
+	{
+		ownCertifications := can(models.PermissionCertifications, middleware.OwnResourcesOnly)
+		certificationRoutes.GET("/", ownCertifications, controllers.GetCertifications)                                                                                          // Get the seller's certifications
+		certificationRoutes.POST("/", ownCertifications, controllers.CreateCertification)                                                                                       // Add a certification
+		certificationRoutes.DELETE("/:certificationID", can(models.PermissionCertifications, middleware.OwnsCertification("certificationID")), controllers.DeleteCertification) // Delete a certification
+	}
+
+	// Delivery zones of the logged in seller
+	deliveryZoneRoutes := router.Group("/api/delivery-zones", auth)
+	{
+		ownZones := can(models.PermissionDeliveryZones, middleware.OwnResourcesOnly)
+		manageZone := can(models.PermissionDeliveryZones, middleware.OwnsDeliveryZone("zoneID"))
+		deliveryZoneRoutes.GET("/", ownZones, controllers.GetDeliveryZones)               // Get the seller's delivery zones
+		deliveryZoneRoutes.POST("/", ownZones, controllers.CreateDeliveryZone)            // Add a delivery zone
+		deliveryZoneRoutes.PUT("/:zoneID", manageZone, controllers.UpdateDeliveryZone)    // Update a delivery zone
+		deliveryZoneRoutes.DELETE("/:zoneID", manageZone, controllers.DeleteDeliveryZone) // Delete a delivery zone
+	}
+
+	// Coupon routes for admins and sellers
+	couponRoutes := router.Group("/api/coupons", auth)
+	{
+		ownCoupons := can(models.PermissionCouponsManage, middleware.OwnResourcesOnly)
+		manageCoupon := can(models.PermissionCouponsManage, middleware.OwnsCoupon("couponID"))
+		couponRoutes.POST("/", ownCoupons, controllers.CreateCoupon)                     // Create a coupon
+		couponRoutes.GET("/", ownCoupons, controllers.GetCoupons)                        // Get the coupons the user manages
+		couponRoutes.GET("/:couponID", manageCoupon, controllers.GetCoupon)              // Get a coupon
+		couponRoutes.PUT("/:couponID", manageCoupon, controllers.UpdateCoupon)           // Update the terms of a coupon
+		couponRoutes.DELETE("/:couponID", manageCoupon, controllers.DeleteCoupon)        // Delete a coupon
+		couponRoutes.GET("/:couponID/report", manageCoupon, controllers.GetCouponReport) // Get redemption statistics of a coupon
+	}
+
+	// Cart routes
+	cartRoutes := router.Group("/api/cart", middleware.AuthMiddleware())
+	{
+		cartRoutes.GET("/", controllers.GetCart)                        // Get the cart of the logged in user
+		cartRoutes.DELETE("/", controllers.ClearCart)                   // Remove all items from the cart
+		cartRoutes.POST("/items", controllers.AddCartItem)              // Add a product to the cart
+		cartRoutes.PUT("/items/:itemID", controllers.UpdateCartItem)    // Change the quantity of a cart item
+		cartRoutes.DELETE("/items/:itemID", controllers.RemoveCartItem) // Remove an item from the cart
+	}
+
+	// Checkout converts the cart into orders
+	api.POST("/checkout", middleware.AuthMiddleware(), controllers.Checkout)
+
 	// Wallet routes
-	walletRoutes := router.Group("/api/wallet")
+	walletRoutes := router.Group("/api/wallet", middleware.AuthMiddleware())
 	{
 		walletRoutes.POST("/wallet/topup", controllers.TopUpWallet)
 		walletRoutes.GET("/wallet/balance", controllers.GetWalletBalance)
+		walletRoutes.GET("/wallet/topups", controllers.GetTopUps)             // Top-up history including failed and abandoned ones
+		walletRoutes.GET("/wallet/statement", controllers.GetWalletStatement) // Ledger entries of the wallet
+		walletRoutes.POST("/withdrawals", controllers.RequestWithdrawal)      // Request a payout from the wallet
+		walletRoutes.GET("/withdrawals", controllers.GetMyWithdrawals)        // List own withdrawal requests
+	}
+
+	// Payment gateway routes
+	paymentRoutes := router.Group("/api/payments")
+	{
+		paymentRoutes.POST("/webhook", controllers.PaymentWebhook) // Signed callbacks from the payment gateway
+
+		// Only for development with PAYMENT_GATEWAY=mock
+		if _, ok := services.CurrentPaymentGateway().(*services.MockGateway); ok {
+			paymentRoutes.POST("/mock/:reference/complete", auth, controllers.CompleteMockPayment) // Complete an own payment at the mock gateway
+		}
+	}
+
+	// Admin routes
+	adminRoutes := router.Group("/api/admin", auth)
+	{
+		withdrawals := can(models.PermissionWithdrawalsManage)
+		adminRoutes.GET("/withdrawals", withdrawals, controllers.GetWithdrawals)                           // List withdrawal requests
+		adminRoutes.POST("/withdrawals/:withdrawalID/approve", withdrawals, controllers.ApproveWithdrawal) // Approve and pay out a withdrawal
+		adminRoutes.POST("/withdrawals/:withdrawalID/reject", withdrawals, controllers.RejectWithdrawal)   // Reject a withdrawal
+
+		// Buyer groups with their own price lists
+		buyerGroups := can(models.PermissionBuyerGroupsManage)
+		adminRoutes.POST("/buyer-groups", buyerGroups, controllers.CreateBuyerGroup)                                  // Create a buyer group
+		adminRoutes.GET("/buyer-groups", buyerGroups, controllers.GetBuyerGroups)                                     // List buyer groups
+		adminRoutes.GET("/buyer-groups/:groupID", buyerGroups, controllers.GetBuyerGroup)                             // Get a buyer group with members and prices
+		adminRoutes.PUT("/buyer-groups/:groupID", buyerGroups, controllers.UpdateBuyerGroup)                          // Update a buyer group
+		adminRoutes.DELETE("/buyer-groups/:groupID", buyerGroups, controllers.DeleteBuyerGroup)                       // Delete a buyer group
+		adminRoutes.POST("/buyer-groups/:groupID/members", buyerGroups, controllers.AddBuyerGroupMember)              // Add a buyer to a group
+		adminRoutes.DELETE("/buyer-groups/:groupID/members/:userID", buyerGroups, controllers.RemoveBuyerGroupMember) // Remove a buyer from a group
 
+		// Roles and the permissions they grant
+		adminRoutes.GET("/roles", can(models.PermissionRolesAssign), controllers.GetRoles)                   // List the roles with their permissions
+		adminRoutes.GET("/users/:userID/roles", can(models.PermissionRolesAssign), controllers.GetUserRoles) // Get the roles of a user
+		adminRoutes.PUT("/users/:userID/roles", can(models.PermissionRolesAssign), controllers.SetUserRoles) // Replace the roles of a user
 	}
-	walletRoutes.Use(middleware.AuthMiddleware())
 
 	// Chat routes
 	chatRoutes := router.Group("/api/chat")
 	{
-		chatRoutes.POST("/send", controllers.SendMessage)    // Send a message
-		chatRoutes.GET("/messages", controllers.GetMessages) // Get messages between users
+		chatRoutes.POST("/send", auth, can(models.PermissionMessagesSend), controllers.SendMessage)                                                           // Send a message
+		chatRoutes.GET("/messages", auth, can(models.PermissionMessagesRead, middleware.InConversation("sender_id", "receiver_id")), controllers.GetMessages) // Get messages between users
 	}
-	chatRoutes.Use(middleware.AuthMiddleware())
 
 	// Reviews routes
 	reviewRoutes := router.Group("/api/review")
 	{
-		reviewRoutes.GET("/reviews/:productID", controllers.GetReviews)  // Get all reviews for a product
-		reviewRoutes.POST("/reviews", controllers.CreateReview)          // Create a review
-		reviewRoutes.PUT("/reviews/:reviewID", controllers.UpdateReview) // Update a review
-		reviewRoutes.DELETE("/reviews/:reviewID", controllers.DeleteReview)
+		manageReview := can(models.PermissionReviewsWrite, middleware.OwnsReview("reviewID"))
+		reviewRoutes.GET("/reviews/:productID", controllers.GetReviews)                                                                // Get all reviews for a product
+		reviewRoutes.POST("/reviews", auth, can(models.PermissionReviewsWrite, middleware.OwnResourcesOnly), controllers.CreateReview) // Create a review
+		reviewRoutes.PUT("/reviews/:reviewID", auth, manageReview, controllers.UpdateReview)                                           // Update a review
+		reviewRoutes.DELETE("/reviews/:reviewID", auth, manageReview, controllers.DeleteReview)                                        // Delete a review
 	}
-	reviewRoutes.Use(middleware.AuthMiddleware())
 
 	// Category Routes
 	categoryRoutes := router.Group("/categories")
 	{
-		categoryRoutes.POST("/", middleware.IsAdmin(), controllers.CreateCategory)              // Create a new category
-		categoryRoutes.GET("/", controllers.GetCategories)                                      // Get all categories
-		categoryRoutes.PUT("/:categoryID", middleware.IsAdmin(), controllers.UpdateCategory)    // Update a category
-		categoryRoutes.DELETE("/:categoryID", middleware.IsAdmin(), controllers.DeleteCategory) // Delete a category
+		categoryRoutes.POST("/", auth, can(models.PermissionCatalogManage), controllers.CreateCategory)              // Create a new category
+		categoryRoutes.GET("/", controllers.GetCategories)                                                           // Get all categories
+		categoryRoutes.PUT("/:categoryID", auth, can(models.PermissionCatalogManage), controllers.UpdateCategory)    // Update a category
+		categoryRoutes.DELETE("/:categoryID", auth, can(models.PermissionCatalogManage), controllers.DeleteCategory) // Delete a category
 	}
-	categoryRoutes.Use(middleware.AuthMiddleware())
 
 	// Unit of Measure Routes
 	unitRoutes := router.Group("/units")
 	{
-		unitRoutes.POST("/", middleware.IsAdmin(), controllers.CreateUnitOfMeasure)          // Create a new unit of measure
-		unitRoutes.GET("/", controllers.GetUnitsOfMeasure)                                   // Get all units of measure
-		unitRoutes.PUT("/:unitID", middleware.IsAdmin(), controllers.UpdateUnitOfMeasure)    // Update a unit of measure
-		unitRoutes.DELETE("/:unitID", middleware.IsAdmin(), controllers.DeleteUnitOfMeasure) // Delete a unit of measure
+		unitRoutes.POST("/", auth, can(models.PermissionCatalogManage), controllers.CreateUnitOfMeasure)          // Create a new unit of measure
+		unitRoutes.GET("/", controllers.GetUnitsOfMeasure)                                                        // Get all units of measure
+		unitRoutes.PUT("/:unitID", auth, can(models.PermissionCatalogManage), controllers.UpdateUnitOfMeasure)    // Update a unit of measure
+		unitRoutes.DELETE("/:unitID", auth, can(models.PermissionCatalogManage), controllers.DeleteUnitOfMeasure) // Delete a unit of measure
 	}
-	unitRoutes.Use(middleware.AuthMiddleware())
 
 }
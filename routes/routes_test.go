@@ -0,0 +1,30 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestChatAndReviewRoutesRequireAuthentication(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	InitializeRoutes(router)
+
+	for _, route := range []struct{ method, path string }{
+		{http.MethodPost, "/api/chat/send"},
+		{http.MethodGet, "/api/chat/messages?sender_id=1&receiver_id=2"},
+		{http.MethodPost, "/api/review/reviews"},
+		{http.MethodPut, "/api/review/reviews/1"},
+		{http.MethodDelete, "/api/review/reviews/1"},
+	} {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(route.method, route.path, strings.NewReader("{}")))
+		if recorder.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s without a token: status = %d, want 401", route.method, route.path, recorder.Code)
+		}
+	}
+}
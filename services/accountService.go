@@ -0,0 +1,208 @@
+package services
+
+import (
+	"errors"
+	"farmers_market_backend/config"
+	"farmers_market_backend/models"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrNoEmail is returned when emailing a user who has no email address
+var ErrNoEmail = errors.New("user has no email address")
+
+// EmailVerificationRequired reports whether users must verify their email
+// address before they can log in, set by REQUIRE_EMAIL_VERIFICATION
+func EmailVerificationRequired() bool {
+	required, err := strconv.ParseBool(config.GetEnv("REQUIRE_EMAIL_VERIFICATION", "false"))
+	return err == nil && required
+}
+
+// accountLink returns the link of a page of the web app carrying a token
+func accountLink(path, token string) string {
+	return config.GetEnv("APP_BASE_URL", "http://localhost:3000") + path + "?token=" + url.QueryEscape(token)
+}
+
+// displayName returns the name to greet the user with
+func displayName(user models.User) string {
+	if user.Name != "" {
+		return user.Name
+	}
+	return user.Username
+}
+
+// describeDuration writes a token lifetime as hours or minutes
+func describeDuration(d time.Duration) string {
+	count, unit := int(d.Minutes()), "minute"
+	if d >= time.Hour && d%time.Hour == 0 {
+		count, unit = int(d.Hours()), "hour"
+	}
+	if count != 1 {
+		unit += "s"
+	}
+	return fmt.Sprintf("%d %s", count, unit)
+}
+
+// issueAccountToken signs an email token of the given type for the user and
+// records it, superseding the unused tokens of that type sent before
+func issueAccountToken(db *gorm.DB, user models.User, tokenType string, ttl time.Duration) (string, error) {
+	if user.Email == nil {
+		return "", ErrNoEmail
+	}
+	token, claims, err := IssueToken(user, tokenType, 0, ttl)
+	if err != nil {
+		return "", err
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.AccountToken{}).Where("user_id = ? AND type = ? AND used_at IS NULL", user.ID, tokenType).
+			Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.AccountToken{
+			UserID:    user.ID,
+			Type:      tokenType,
+			TokenID:   claims.ID,
+			Email:     *user.Email,
+			ExpiresAt: claims.ExpiresAt.Time,
+		}).Error
+	})
+	return token, err
+}
+
+// consumeAccountToken verifies an email token, marks it used and returns its
+// user. A token is rejected with ErrInvalidToken if it was used or superseded,
+// or if the user's email address changed since it was sent.
+func consumeAccountToken(tx *gorm.DB, token, tokenType string) (models.User, error) {
+	var user models.User
+	claims, err := ParseToken(token, tokenType)
+	if err != nil {
+		return user, err
+	}
+	userID, _ := claims.UserID()
+
+	var stored models.AccountToken
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("token_id = ? AND type = ?", claims.ID, tokenType).
+		First(&stored).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return user, ErrInvalidToken
+		}
+		return user, err
+	}
+	if stored.UsedAt != nil || stored.UserID != userID {
+		return user, ErrInvalidToken
+	}
+
+	if err := tx.First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return user, ErrInvalidToken
+		}
+		return user, err
+	}
+	if user.Email == nil || *user.Email != stored.Email {
+		return user, ErrInvalidToken
+	}
+
+	return user, tx.Model(&stored).Update("used_at", time.Now()).Error
+}
+
+// SendEmailVerification emails the user a link to confirm their address,
+// valid for EMAIL_VERIFICATION_TTL
+func SendEmailVerification(db *gorm.DB, user models.User) error {
+	ttl := config.GetEnvDuration("EMAIL_VERIFICATION_TTL", 48*time.Hour)
+	token, err := issueAccountToken(db, user, TokenTypeEmailVerification, ttl)
+	if err != nil {
+		return err
+	}
+	return sendEmail(*user.Email, "Confirm your email address", "verify_email", map[string]string{
+		"Name":      displayName(user),
+		"Link":      accountLink("/verify-email", token),
+		"ExpiresIn": describeDuration(ttl),
+	})
+}
+
+// ResendEmailVerification sends a new verification link to the user with
+// the email address if they have not verified it yet. Unknown addresses are
+// ignored so the response does not reveal who has an account.
+func ResendEmailVerification(db *gorm.DB, email string) error {
+	var user models.User
+	if err := db.Where("email = ? AND email_verified_at IS NULL", email).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	return SendEmailVerification(db, user)
+}
+
+// VerifyEmail marks the email address of the token's user verified
+func VerifyEmail(db *gorm.DB, token string) (models.User, error) {
+	var user models.User
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if user, err = consumeAccountToken(tx, token, TokenTypeEmailVerification); err != nil {
+			return err
+		}
+		if user.EmailVerifiedAt != nil {
+			return nil
+		}
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+		return tx.Model(&user).Update("email_verified_at", now).Error
+	})
+	return user, err
+}
+
+// RequestPasswordReset emails the user with the email address a link to set
+// a new password, valid for PASSWORD_RESET_TTL. Unknown addresses are ignored
+// so the response does not reveal who has an account.
+func RequestPasswordReset(db *gorm.DB, email string) error {
+	var user models.User
+	if err := db.Where("email = ?", email).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	ttl := config.GetEnvDuration("PASSWORD_RESET_TTL", time.Hour)
+	token, err := issueAccountToken(db, user, TokenTypePasswordReset, ttl)
+	if err != nil {
+		return err
+	}
+	return sendEmail(*user.Email, "Reset your password", "reset_password", map[string]string{
+		"Name":      displayName(user),
+		"Link":      accountLink("/reset-password", token),
+		"ExpiresIn": describeDuration(ttl),
+	})
+}
+
+// ResetPassword sets a new password for the token's user and logs them out
+// on every device. Following the emailed link also proves the address, so
+// it is marked verified.
+func ResetPassword(db *gorm.DB, token, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		user, err := consumeAccountToken(tx, token, TokenTypePasswordReset)
+		if err != nil {
+			return err
+		}
+		updates := map[string]interface{}{"password": string(hashedPassword)}
+		if user.EmailVerifiedAt == nil {
+			updates["email_verified_at"] = time.Now()
+		}
+		if err := tx.Model(&user).Updates(updates).Error; err != nil {
+			return err
+		}
+		return RevokeAllSessions(tx, user.ID, models.SessionRevokedPassword)
+	})
+}
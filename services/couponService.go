@@ -0,0 +1,221 @@
+package services
+
+import (
+	"errors"
+	"farmers_market_backend/models"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CouponError is returned when a coupon cannot be applied to a checkout
+type CouponError struct {
+	Reason string
+}
+
+func (e *CouponError) Error() string {
+	return "coupon cannot be applied: " + e.Reason
+}
+
+// Reasons a coupon is refused at checkout
+var (
+	ErrCouponNotFound       = &CouponError{Reason: "coupon does not exist"}
+	ErrCouponInactive       = &CouponError{Reason: "coupon is no longer active"}
+	ErrCouponNotStarted     = &CouponError{Reason: "coupon is not valid yet"}
+	ErrCouponExpired        = &CouponError{Reason: "coupon has expired"}
+	ErrCouponUsageLimit     = &CouponError{Reason: "coupon has been fully redeemed"}
+	ErrCouponUserLimit      = &CouponError{Reason: "you have already used this coupon"}
+	ErrCouponFirstOrderOnly = &CouponError{Reason: "coupon is only valid on your first order"}
+	ErrCouponNotApplicable  = &CouponError{Reason: "coupon does not apply to any item in your order"}
+	ErrCouponMinBasket      = &CouponError{Reason: "order does not reach the minimum basket value of the coupon"}
+)
+
+// CouponReport summarises the redemptions of a coupon
+type CouponReport struct {
+	CouponID          uint         `json:"coupon_id"`
+	Code              string       `json:"code"`
+	Redemptions       int64        `json:"redemptions"`         // Checkouts that used the coupon
+	UniqueBuyers      int64        `json:"unique_buyers"`       // Distinct buyers who used the coupon
+	Orders            int64        `json:"orders"`              // Orders created by those checkouts
+	CancelledOrders   int64        `json:"cancelled_orders"`    // Orders later cancelled, rejected or refunded
+	TotalDiscount     models.Money `json:"total_discount"`      // Discount given over all redemptions
+	GrossOrderValue   models.Money `json:"gross_order_value"`   // Amount paid for orders that were not cancelled
+	AverageOrderValue models.Money `json:"average_order_value"` // GrossOrderValue per order that was not cancelled
+}
+
+// NormalizeCouponCode returns the form in which coupon codes are stored
+func NormalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// CouponAppliesTo reports whether a product is in the scope of a coupon
+func CouponAppliesTo(coupon models.Coupon, product models.Product) bool {
+	if coupon.CategoryID != nil && *coupon.CategoryID != product.CategoryID {
+		return false
+	}
+	if coupon.SellerID != nil && *coupon.SellerID != product.SellerID {
+		return false
+	}
+	if coupon.ProductID != nil && *coupon.ProductID != product.ID {
+		return false
+	}
+	return true
+}
+
+// findRedeemableCoupon locks the coupon with the given code and checks that
+// the buyer may redeem it now. The lock serialises concurrent checkouts with
+// the same coupon so usage limits cannot be exceeded.
+func findRedeemableCoupon(tx *gorm.DB, code string, buyerID uint, now time.Time) (models.Coupon, error) {
+	var coupon models.Coupon
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("code = ?", NormalizeCouponCode(code)).First(&coupon).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return coupon, ErrCouponNotFound
+		}
+		return coupon, err
+	}
+
+	switch {
+	case !coupon.IsActive:
+		return coupon, ErrCouponInactive
+	case coupon.StartsAt != nil && now.Before(*coupon.StartsAt):
+		return coupon, ErrCouponNotStarted
+	case coupon.EndsAt != nil && !now.Before(*coupon.EndsAt):
+		return coupon, ErrCouponExpired
+	}
+
+	if coupon.UsageLimit > 0 {
+		var used int64
+		if err := tx.Model(&models.CouponRedemption{}).Where("coupon_id = ?", coupon.ID).Count(&used).Error; err != nil {
+			return coupon, err
+		}
+		if used >= int64(coupon.UsageLimit) {
+			return coupon, ErrCouponUsageLimit
+		}
+	}
+
+	if coupon.PerUserLimit > 0 {
+		var used int64
+		if err := tx.Model(&models.CouponRedemption{}).
+			Where("coupon_id = ? AND user_id = ?", coupon.ID, buyerID).Count(&used).Error; err != nil {
+			return coupon, err
+		}
+		if used >= int64(coupon.PerUserLimit) {
+			return coupon, ErrCouponUserLimit
+		}
+	}
+
+	if coupon.FirstOrderOnly {
+		// Orders that never went ahead do not count as an earlier order
+		var previous int64
+		if err := tx.Model(&models.Order{}).
+			Where("buyer_id = ? AND status NOT IN ?", buyerID, []string{models.OrderStatusCancelled, models.OrderStatusRejected}).
+			Count(&previous).Error; err != nil {
+			return coupon, err
+		}
+		if previous > 0 {
+			return coupon, ErrCouponFirstOrderOnly
+		}
+	}
+
+	return coupon, nil
+}
+
+// couponDiscounts works out the coupon discount for each seller's order. Only
+// lines in the scope of the coupon count towards the minimum basket and are
+// discounted. A fixed discount is capped at the eligible value and shared
+// between the orders in proportion to their eligible value; the last order
+// takes the rounding remainder so the shares add up to the discount.
+func couponDiscounts(coupon models.Coupon, linesBySeller map[uint][]PricedLine, sellerIDs []uint) (map[uint]models.Money, models.Money, error) {
+	zero := models.NewMoney(0, models.DefaultCurrency)
+	eligible := map[uint]models.Money{}
+	eligibleTotal := zero
+	for _, sellerID := range sellerIDs {
+		amount := zero
+		for _, line := range linesBySeller[sellerID] {
+			if CouponAppliesTo(coupon, line.Product) {
+				amount = amount.Add(line.LineTotal)
+			}
+		}
+		eligible[sellerID] = amount
+		eligibleTotal = eligibleTotal.Add(amount)
+	}
+
+	if !eligibleTotal.IsPositive() {
+		return nil, zero, ErrCouponNotApplicable
+	}
+	if eligibleTotal.Cmp(coupon.MinBasket) < 0 {
+		return nil, zero, ErrCouponMinBasket
+	}
+
+	discounts := map[uint]models.Money{}
+	total := zero
+	if coupon.Type == models.CouponTypePercentage {
+		for _, sellerID := range sellerIDs {
+			discounts[sellerID] = eligible[sellerID].Percent(coupon.Percent)
+			total = total.Add(discounts[sellerID])
+		}
+		return discounts, total, nil
+	}
+
+	total = coupon.Amount
+	if total.Cmp(eligibleTotal) > 0 {
+		total = eligibleTotal
+	}
+	remaining := total
+	for i, sellerID := range sellerIDs {
+		share := remaining
+		if i < len(sellerIDs)-1 {
+			share = models.NewMoney(total.Minor*eligible[sellerID].Minor/eligibleTotal.Minor, total.Currency)
+		}
+		discounts[sellerID] = share
+		remaining = remaining.Sub(share)
+	}
+	return discounts, total, nil
+}
+
+// GetCouponReport summarises the redemptions of a coupon and the orders they created
+func GetCouponReport(db *gorm.DB, coupon models.Coupon) (CouponReport, error) {
+	report := CouponReport{CouponID: coupon.ID, Code: coupon.Code}
+	redemptions := db.Model(&models.CouponRedemption{}).Where("coupon_id = ?", coupon.ID)
+
+	var totals struct {
+		Redemptions  int64
+		UniqueBuyers int64
+		Discount     int64
+	}
+	if err := redemptions.Select("COUNT(*) AS redemptions, COUNT(DISTINCT user_id) AS unique_buyers, COALESCE(SUM(discount_minor), 0) AS discount").
+		Scan(&totals).Error; err != nil {
+		return report, err
+	}
+
+	cancelled := []string{models.OrderStatusCancelled, models.OrderStatusRejected, models.OrderStatusRefunded}
+	orders := db.Model(&models.Order{}).
+		Where("coupon_redemption_id IN (?)", db.Model(&models.CouponRedemption{}).Select("id").Where("coupon_id = ?", coupon.ID))
+
+	var orderTotals struct {
+		Orders    int64
+		Cancelled int64
+		Gross     int64
+	}
+	if err := orders.Select("COUNT(*) AS orders, "+
+		"COALESCE(SUM(CASE WHEN status IN ? THEN 1 ELSE 0 END), 0) AS cancelled, "+
+		"COALESCE(SUM(CASE WHEN status IN ? THEN 0 ELSE total_price_minor END), 0) AS gross", cancelled, cancelled).
+		Scan(&orderTotals).Error; err != nil {
+		return report, err
+	}
+
+	report.Redemptions = totals.Redemptions
+	report.UniqueBuyers = totals.UniqueBuyers
+	report.Orders = orderTotals.Orders
+	report.CancelledOrders = orderTotals.Cancelled
+	report.TotalDiscount = models.NewMoney(totals.Discount, models.DefaultCurrency)
+	report.GrossOrderValue = models.NewMoney(orderTotals.Gross, models.DefaultCurrency)
+	report.AverageOrderValue = models.NewMoney(0, models.DefaultCurrency)
+	if completed := orderTotals.Orders - orderTotals.Cancelled; completed > 0 {
+		report.AverageOrderValue = models.NewMoney(orderTotals.Gross/completed, models.DefaultCurrency)
+	}
+	return report, nil
+}
@@ -0,0 +1,185 @@
+package services
+
+import (
+	"errors"
+	"farmers_market_backend/config"
+	"farmers_market_backend/models"
+	"fmt"
+	"log"
+	"math"
+
+	"gorm.io/gorm"
+)
+
+// ErrInvalidDeliveryDistrict is returned when the district to deliver to does
+// not exist
+var ErrInvalidDeliveryDistrict = errors.New("invalid delivery district")
+
+// SellerError describes why the order with one seller failed
+type SellerError struct {
+	SellerID uint   `json:"seller_id"`
+	Reason   string `json:"reason"`
+}
+
+// UndeliverableError is returned when one or more sellers do not deliver to
+// the buyer's address
+type UndeliverableError struct {
+	Sellers []SellerError `json:"sellers"`
+}
+
+func (e *UndeliverableError) Error() string {
+	return "address cannot be delivered to"
+}
+
+// DeliveryDestination is where an order is delivered
+type DeliveryDestination struct {
+	Address    string
+	DistrictID uint     // 0 when the buyer has no district
+	Latitude   *float64 // Centre of the district, nil when unknown
+	Longitude  *float64
+}
+
+// DeliveryQuote is the delivery fee of one seller's order
+type DeliveryQuote struct {
+	ZoneID *uint        // Zone that set the fee, nil for the default fee
+	Fee    models.Money // Fee for delivering the order
+}
+
+// ResolveDestination returns the delivery destination of a buyer's orders:
+// the given address and district, or the buyer's own when they are empty
+func ResolveDestination(db *gorm.DB, buyerID uint, address string, districtID uint) (DeliveryDestination, error) {
+	var buyer models.User
+	if err := db.First(&buyer, buyerID).Error; err != nil {
+		return DeliveryDestination{}, err
+	}
+
+	destination := DeliveryDestination{Address: address, DistrictID: districtID}
+	if destination.Address == "" {
+		destination.Address = buyer.DeliveryAddress
+	}
+	if destination.DistrictID == 0 && buyer.DistrictID != nil {
+		destination.DistrictID = *buyer.DistrictID
+	}
+	if destination.DistrictID == 0 {
+		return destination, nil
+	}
+
+	var district models.District
+	if err := db.First(&district, destination.DistrictID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return destination, ErrInvalidDeliveryDistrict
+		}
+		return destination, err
+	}
+	destination.Latitude, destination.Longitude = district.Latitude, district.Longitude
+	return destination, nil
+}
+
+// QuoteDelivery calculates the delivery fee of a seller's order lines to the
+// destination and returns the reason the seller cannot deliver them, or an
+// empty string. Sellers without delivery zones deliver everywhere for the
+// default fee. Otherwise the zone containing the destination district sets
+// the fee, which is waived from the zone's free delivery threshold. Both the
+// threshold and the minimum basket are compared with the goods after product
+// discounts but before any coupon.
+func QuoteDelivery(db *gorm.DB, sellerID uint, destination DeliveryDestination, lines []PricedLine) (DeliveryQuote, string, error) {
+	zero := models.NewMoney(0, models.DefaultCurrency)
+
+	var zones int64
+	if err := db.Model(&models.DeliveryZone{}).Where("seller_id = ?", sellerID).Count(&zones).Error; err != nil {
+		return DeliveryQuote{}, "", err
+	}
+	if zones == 0 {
+		return DeliveryQuote{Fee: defaultDeliveryFee()}, "", nil
+	}
+	if destination.DistrictID == 0 {
+		return DeliveryQuote{}, "a delivery district is required", nil
+	}
+
+	var zone models.DeliveryZone
+	err := db.Joins("JOIN delivery_zone_districts ON delivery_zone_districts.delivery_zone_id = delivery_zones.id").
+		Where("delivery_zones.seller_id = ? AND delivery_zone_districts.district_id = ?", sellerID, destination.DistrictID).
+		First(&zone).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return DeliveryQuote{}, "seller does not deliver to this district", nil
+	} else if err != nil {
+		return DeliveryQuote{}, "", err
+	}
+	quote := DeliveryQuote{ZoneID: &zone.ID, Fee: zero}
+
+	basket := zero
+	for _, line := range lines {
+		basket = basket.Add(line.LineTotal)
+	}
+	if zone.MinimumBasket.IsPositive() && basket.Cmp(zone.MinimumBasket) < 0 {
+		return quote, fmt.Sprintf("minimum order for delivery to %s is %s", zone.Name, zone.MinimumBasket), nil
+	}
+	if zone.FreeDeliveryFrom.IsPositive() && basket.Cmp(zone.FreeDeliveryFrom) >= 0 {
+		return quote, "", nil
+	}
+
+	quote.Fee = quote.Fee.Add(zone.BaseFee)
+	switch zone.FeeType {
+	case models.DeliveryFeePerKm:
+		distance, ok, err := deliveryDistanceKm(db, sellerID, destination)
+		if err != nil {
+			return quote, "", err
+		}
+		if !ok {
+			return quote, "distance to the delivery district is unknown", nil
+		}
+		quote.Fee = quote.Fee.Add(zone.Rate.MulQuantity(distance))
+	case models.DeliveryFeePerWeight:
+		quote.Fee = quote.Fee.Add(zone.Rate.MulQuantity(orderWeightKg(lines)))
+	}
+	return quote, "", nil
+}
+
+// deliveryDistanceKm is the distance from the seller's farm, or the centre of
+// its district, to the centre of the destination district, rounded up to a
+// tenth of a kilometre. It reports false when either location is unknown.
+func deliveryDistanceKm(db *gorm.DB, sellerID uint, destination DeliveryDestination) (float64, bool, error) {
+	if destination.Latitude == nil || destination.Longitude == nil {
+		return 0, false, nil
+	}
+
+	var seller models.User
+	if err := db.Preload("District").First(&seller, sellerID).Error; err != nil {
+		return 0, false, err
+	}
+	latitude, longitude := seller.FarmLatitude, seller.FarmLongitude
+	if latitude == nil || longitude == nil {
+		latitude, longitude = seller.District.Latitude, seller.District.Longitude
+	}
+	if latitude == nil || longitude == nil {
+		return 0, false, nil
+	}
+
+	distance := DistanceKm(*latitude, *longitude, *destination.Latitude, *destination.Longitude)
+	return math.Ceil(distance*10) / 10, true, nil
+}
+
+// orderWeightKg is the shipping weight of order lines. The variants'
+// UnitOfMeasure must be loaded.
+func orderWeightKg(lines []PricedLine) float64 {
+	weight := 0.0
+	for _, line := range lines {
+		if line.Variant.UnitOfMeasure.Dimension == models.DimensionMass {
+			weight += line.Quantity * line.Variant.UnitOfMeasure.ToBase
+		} else {
+			weight += line.Quantity * line.Variant.UnitWeightKg
+		}
+	}
+	return roundQuantity(weight)
+}
+
+// defaultDeliveryFee returns the flat delivery fee of sellers without
+// delivery zones
+func defaultDeliveryFee() models.Money {
+	fee, err := models.ParseMoney(config.GetEnv("DELIVERY_FEE", "0"), models.DefaultCurrency)
+	if err != nil || fee.Minor < 0 {
+		log.Printf("Warning: invalid DELIVERY_FEE, using 0")
+		return models.NewMoney(0, models.DefaultCurrency)
+	}
+	return fee
+}
@@ -0,0 +1,210 @@
+package services
+
+import (
+	"errors"
+	"farmers_market_backend/models"
+	"math"
+	"sort"
+
+	"gorm.io/gorm"
+)
+
+// ErrNoLocation is returned when a nearby search has neither coordinates nor a
+// district to search around
+var ErrNoLocation = errors.New("no location to search around")
+
+// How close a nearby product is known to be to the buyer
+const (
+	ProximityDistance = "distance" // The seller's location is known, DistanceKm is set
+	ProximityDistrict = "district" // The seller has no coordinates but is in the buyer's district
+	ProximityCountry  = "country"  // The seller has no coordinates but is in the buyer's country
+)
+
+// earthRadiusKm is the mean radius of the Earth
+const earthRadiusKm = 6371.0
+
+// ValidCoordinates reports whether a latitude and longitude are either both
+// unset or both set and in range
+func ValidCoordinates(latitude, longitude *float64) bool {
+	if latitude == nil || longitude == nil {
+		return latitude == nil && longitude == nil
+	}
+	return *latitude >= -90 && *latitude <= 90 && *longitude >= -180 && *longitude <= 180
+}
+
+// DistanceKm is the great-circle distance between two points in kilometres
+func DistanceKm(lat1, lng1, lat2, lng2 float64) float64 {
+	toRadians := func(degrees float64) float64 { return degrees * math.Pi / 180 }
+	dLat := toRadians(lat2 - lat1)
+	dLng := toRadians(lng2 - lng1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRadians(lat1))*math.Cos(toRadians(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// NearbySearch is where a buyer looks for produce. Coordinates are optional:
+// without them the centre of the district is used, and without that sellers
+// are ranked by district and country only.
+type NearbySearch struct {
+	Latitude    *float64
+	Longitude   *float64
+	DistrictID  uint    // The buyer's district, 0 if unknown
+	RadiusKm    float64 // Only sellers within this distance when their location is known
+	CategoryIDs []uint  // Any of these categories
+	Limit       int
+	Offset      int
+}
+
+// NearbyProduct is a product found near the buyer
+type NearbyProduct struct {
+	models.Product
+	DistanceKm *float64 `json:"distance_km"` // Distance from the buyer to the farm, nil when unknown
+	Proximity  string   `json:"proximity"`   // One of the Proximity constants
+}
+
+// NearbyResult is a page of nearby products, nearest first, with the total
+type NearbyResult struct {
+	Products []NearbyProduct
+	Total    int64
+}
+
+// nearbyCandidate is a listed product with its seller's location
+type nearbyCandidate struct {
+	ID         uint
+	Latitude   *float64
+	Longitude  *float64
+	DistrictID *uint
+	CountryID  *uint
+}
+
+// FindNearbyProducts finds in-season products near the buyer. Products of
+// sellers whose farm or district has coordinates are ranked by distance and
+// limited to the radius. Products of sellers without any coordinates follow,
+// those in the buyer's district before those elsewhere in the buyer's country.
+func FindNearbyProducts(db *gorm.DB, search NearbySearch) (NearbyResult, error) {
+	result := NearbyResult{Products: []NearbyProduct{}}
+
+	latitude, longitude := search.Latitude, search.Longitude
+	var district models.District
+	if search.DistrictID != 0 {
+		if err := db.First(&district, search.DistrictID).Error; err != nil {
+			return result, err
+		}
+		if latitude == nil {
+			latitude, longitude = district.Latitude, district.Longitude
+		}
+	}
+	if latitude == nil && search.DistrictID == 0 {
+		return result, ErrNoLocation
+	}
+
+	query := db.Table("products").
+		Joins("JOIN users sellers ON sellers.id = products.seller_id").
+		Joins("LEFT JOIN districts seller_districts ON seller_districts.id = sellers.district_id").
+		Select("products.id AS id, " +
+			"COALESCE(sellers.farm_latitude, seller_districts.latitude) AS latitude, " +
+			"COALESCE(sellers.farm_longitude, seller_districts.longitude) AS longitude, " +
+			"sellers.district_id AS district_id, seller_districts.country_id AS country_id").
+		Scopes(ListedProducts)
+	if len(search.CategoryIDs) > 0 {
+		query = query.Where("products.category_id IN ?", search.CategoryIDs)
+	}
+
+	// Without a location to measure from, sellers are ranked by district and
+	// country only. Otherwise a bounding box around the radius narrows the
+	// sellers with coordinates in the database, the exact distance is checked
+	// below, and only sellers without coordinates fall back to that ranking.
+	var fallback *gorm.DB
+	if search.DistrictID != 0 {
+		fallback = db.Where("seller_districts.country_id = ?", district.CountryID)
+		if latitude != nil {
+			fallback = fallback.Where("COALESCE(sellers.farm_latitude, seller_districts.latitude) IS NULL")
+		}
+	}
+	if latitude == nil {
+		query = query.Where(fallback)
+	} else {
+		latDelta := search.RadiusKm / 111.32
+		inRadius := db.Where("COALESCE(sellers.farm_latitude, seller_districts.latitude) BETWEEN ? AND ?",
+			*latitude-latDelta, *latitude+latDelta)
+		if cos := math.Cos(*latitude * math.Pi / 180); cos > 0.01 {
+			lngDelta := search.RadiusKm / (111.32 * cos)
+			if *longitude-lngDelta >= -180 && *longitude+lngDelta <= 180 {
+				inRadius = inRadius.Where("COALESCE(sellers.farm_longitude, seller_districts.longitude) BETWEEN ? AND ?",
+					*longitude-lngDelta, *longitude+lngDelta)
+			}
+		}
+		if fallback != nil {
+			inRadius = inRadius.Or(fallback)
+		}
+		query = query.Where(inRadius)
+	}
+
+	var candidates []nearbyCandidate
+	if err := query.Scan(&candidates).Error; err != nil {
+		return result, err
+	}
+
+	type ranked struct {
+		id         uint
+		distanceKm *float64
+		proximity  string
+	}
+	var located, sameDistrict, sameCountry []ranked
+	for _, candidate := range candidates {
+		switch {
+		case latitude != nil && candidate.Latitude != nil && candidate.Longitude != nil:
+			distance := DistanceKm(*latitude, *longitude, *candidate.Latitude, *candidate.Longitude)
+			if distance <= search.RadiusKm {
+				located = append(located, ranked{candidate.ID, &distance, ProximityDistance})
+			}
+		case candidate.DistrictID != nil && *candidate.DistrictID == search.DistrictID:
+			sameDistrict = append(sameDistrict, ranked{candidate.ID, nil, ProximityDistrict})
+		default:
+			sameCountry = append(sameCountry, ranked{candidate.ID, nil, ProximityCountry})
+		}
+	}
+	sort.Slice(located, func(i, j int) bool {
+		if *located[i].distanceKm != *located[j].distanceKm {
+			return *located[i].distanceKm < *located[j].distanceKm
+		}
+		return located[i].id > located[j].id
+	})
+	for _, group := range [][]ranked{sameDistrict, sameCountry} {
+		sort.Slice(group, func(i, j int) bool { return group[i].id > group[j].id })
+	}
+	all := append(append(located, sameDistrict...), sameCountry...)
+	result.Total = int64(len(all))
+
+	if search.Offset >= len(all) {
+		return result, nil
+	}
+	page := all[search.Offset:]
+	if len(page) > search.Limit {
+		page = page[:search.Limit]
+	}
+	ids := make([]uint, len(page))
+	for i, entry := range page {
+		ids[i] = entry.id
+	}
+
+	var products []models.Product
+	if err := db.Preload("Category").Preload("Seller").
+		Preload("Variants", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Preload("Variants.UnitOfMeasure").
+		Where("id IN ?", ids).Find(&products).Error; err != nil {
+		return result, err
+	}
+	byID := make(map[uint]models.Product, len(products))
+	for _, product := range products {
+		byID[product.ID] = product
+	}
+	for _, entry := range page {
+		result.Products = append(result.Products, NearbyProduct{
+			Product:    byID[entry.id],
+			DistanceKm: entry.distanceKm,
+			Proximity:  entry.proximity,
+		})
+	}
+	return result, nil
+}
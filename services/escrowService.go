@@ -0,0 +1,219 @@
+package services
+
+import (
+	"errors"
+	"farmers_market_backend/models"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PayOrdersFromWallet debits the buyer's wallet for the given orders and holds
+// the money in escrow until the buyer confirms delivery. Free orders, such as
+// orders fully paid by a coupon, get an empty hold and move no money. It
+// fails with ErrInsufficientFunds if the wallet cannot cover all orders.
+func PayOrdersFromWallet(tx *gorm.DB, buyerID uint, orders []models.Order) error {
+	buyerWallet, err := GetOrCreateWallet(tx, buyerID)
+	if err != nil {
+		return err
+	}
+
+	total := models.NewMoney(0, buyerWallet.Balance.Currency)
+	for _, order := range orders {
+		total = total.Add(order.TotalPrice)
+	}
+	if buyerWallet.Balance.Cmp(total) < 0 {
+		return ErrInsufficientFunds
+	}
+
+	for _, order := range orders {
+		orderID := order.ID
+		if !order.TotalPrice.IsZero() {
+			if _, err := PostJournal(tx, orderPaymentReference(order.ID), fmt.Sprintf("Payment for order %d held in escrow", order.ID), &orderID,
+				DebitWallet(buyerWallet, order.TotalPrice),
+				Posting{Account: models.LedgerAccountEscrow, Credit: order.TotalPrice},
+			); err != nil {
+				return err
+			}
+		}
+
+		hold := models.EscrowHold{
+			OrderID:  order.ID,
+			BuyerID:  buyerID,
+			SellerID: order.SellerID,
+			Amount:   order.TotalPrice,
+			Status:   models.EscrowStatusHeld,
+		}
+		if err := tx.Create(&hold).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// releaseEscrow pays the held amount to the seller minus the platform commission
+func releaseEscrow(tx *gorm.DB, hold models.EscrowHold) error {
+	if hold.Amount.IsZero() {
+		return settleEscrow(tx, hold, models.EscrowStatusReleased)
+	}
+
+	sellerWallet, err := GetOrCreateWallet(tx, hold.SellerID)
+	if err != nil {
+		return err
+	}
+
+	commission, sellerShare := hold.Amount.Split(CommissionRate())
+	orderID := hold.OrderID
+	if _, err := PostJournal(tx, orderReleaseReference(hold.OrderID), fmt.Sprintf("Escrow release for order %d", hold.OrderID), &orderID,
+		Posting{Account: models.LedgerAccountEscrow, Debit: hold.Amount},
+		CreditWallet(sellerWallet, sellerShare),
+		Posting{Account: models.LedgerAccountPlatformCommission, Credit: commission},
+	); err != nil {
+		return err
+	}
+
+	return settleEscrow(tx, hold, models.EscrowStatusReleased)
+}
+
+// refundEscrow returns the held amount to the buyer
+func refundEscrow(tx *gorm.DB, hold models.EscrowHold) error {
+	if hold.Amount.IsZero() {
+		return settleEscrow(tx, hold, models.EscrowStatusRefunded)
+	}
+
+	buyerWallet, err := GetOrCreateWallet(tx, hold.BuyerID)
+	if err != nil {
+		return err
+	}
+
+	orderID := hold.OrderID
+	if _, err := PostJournal(tx, orderRefundReference(hold.OrderID), fmt.Sprintf("Escrow refund for order %d", hold.OrderID), &orderID,
+		Posting{Account: models.LedgerAccountEscrow, Debit: hold.Amount},
+		CreditWallet(buyerWallet, hold.Amount),
+	); err != nil {
+		return err
+	}
+
+	return settleEscrow(tx, hold, models.EscrowStatusRefunded)
+}
+
+func settleEscrow(tx *gorm.DB, hold models.EscrowHold, status string) error {
+	now := time.Now()
+	return tx.Model(&hold).Updates(map[string]interface{}{"status": status, "settled_at": now}).Error
+}
+
+// applyPaymentTransition moves the money of a wallet-paid order when its
+// status changes: escrow is released to the seller on delivery and refunded to
+// the buyer on cancellation, rejection or refund
+func applyPaymentTransition(tx *gorm.DB, order models.Order, to string) error {
+	if order.PaymentMethod != models.PaymentMethodWallet {
+		return nil
+	}
+
+	var hold models.EscrowHold
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("order_id = ?", order.ID).First(&hold).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Orders paid before escrow existed paid the seller directly
+		switch to {
+		case models.OrderStatusCancelled, models.OrderStatusRejected, models.OrderStatusRefunded:
+			return ReverseJournal(tx, orderPaymentReference(order.ID), orderRefundReference(order.ID),
+				fmt.Sprintf("Refund for %s order %d", to, order.ID))
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	switch to {
+	case models.OrderStatusDelivered:
+		if hold.Status == models.EscrowStatusHeld {
+			return releaseEscrow(tx, hold)
+		}
+	case models.OrderStatusCancelled, models.OrderStatusRejected:
+		if hold.Status == models.EscrowStatusHeld {
+			return refundEscrow(tx, hold)
+		}
+	case models.OrderStatusRefunded:
+		if hold.Status == models.EscrowStatusReleased {
+			// Take the payout back from the seller into escrow before refunding the buyer
+			if err := ReverseJournal(tx, orderReleaseReference(order.ID), fmt.Sprintf("order:%d:release:reversal", order.ID),
+				fmt.Sprintf("Reversal of escrow release for refunded order %d", order.ID)); err != nil {
+				return err
+			}
+			hold.Status = models.EscrowStatusHeld
+		}
+		if hold.Status == models.EscrowStatusHeld {
+			return refundEscrow(tx, hold)
+		}
+	}
+	return nil
+}
+
+// AutoReleaseEscrow settles the escrow of wallet-paid orders still open longer
+// than after past their delivery date. Orders out for delivery are confirmed
+// delivered on behalf of the buyer, which releases their escrow to the seller.
+// Orders the seller confirmed or packed but never dispatched are cancelled,
+// which refunds their escrow to the buyer and puts the stock back.
+func AutoReleaseEscrow(db *gorm.DB, after time.Duration) error {
+	if err := settleOverdueOrders(db, after, []string{models.OrderStatusOutForDelivery},
+		models.OrderStatusDelivered, "Delivery confirmed automatically"); err != nil {
+		return err
+	}
+	return settleOverdueOrders(db, after, []string{models.OrderStatusConfirmed, models.OrderStatusPacked},
+		models.OrderStatusCancelled, "Cancelled automatically, the order was not dispatched by its delivery date")
+}
+
+// settleOverdueOrders moves wallet-paid orders with held escrow in one of the
+// statuses longer than after past their delivery date to the status to
+func settleOverdueOrders(db *gorm.DB, after time.Duration, statuses []string, to, note string) error {
+	var orderIDs []uint
+	if err := db.Model(&models.Order{}).
+		Joins("JOIN escrow_holds ON escrow_holds.order_id = orders.id").
+		Where("escrow_holds.status = ? AND orders.status IN ? AND orders.delivery_date_time < ?",
+			models.EscrowStatusHeld, statuses, time.Now().Add(-after)).
+		Pluck("orders.id", &orderIDs).Error; err != nil {
+		return err
+	}
+
+	for _, orderID := range orderIDs {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			_, err := TransitionOrder(tx, orderID, to, SystemActor, note)
+			return err
+		})
+		// The order may have moved on since it was selected
+		if err != nil && !errors.Is(err, ErrInvalidTransition) {
+			log.Printf("Failed to settle escrow of overdue order %d: %v", orderID, err)
+		}
+	}
+	return nil
+}
+
+// StartEscrowSweeper periodically settles the escrow of overdue orders in the background
+func StartEscrowSweeper(db *gorm.DB, after, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := AutoReleaseEscrow(db, after); err != nil {
+				log.Printf("Failed to auto-release escrow: %v", err)
+			}
+		}
+	}()
+}
+
+func orderPaymentReference(orderID uint) string {
+	return fmt.Sprintf("order:%d:payment", orderID)
+}
+
+func orderReleaseReference(orderID uint) string {
+	return fmt.Sprintf("order:%d:release", orderID)
+}
+
+func orderRefundReference(orderID uint) string {
+	return fmt.Sprintf("order:%d:refund", orderID)
+}
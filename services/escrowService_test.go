@@ -0,0 +1,71 @@
+package services
+
+import (
+	"testing"
+
+	"farmers_market_backend/models"
+	"farmers_market_backend/testutil"
+
+	"gorm.io/gorm"
+)
+
+// placeFreeWalletOrder places a wallet-paid order for a variant given away for free
+func placeFreeWalletOrder(t *testing.T, db *gorm.DB) models.Order {
+	t.Helper()
+	variant := testutil.CreateVariant(t, db, testutil.CreateUser(t, db, "seller").ID, 5)
+	if err := db.Model(&variant).Update("price_minor", 0).Error; err != nil {
+		t.Fatalf("make variant free: %v", err)
+	}
+	buyer := testutil.CreateUser(t, db, "buyer")
+
+	var orders []models.Order
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		orders, err = CreateOrders(tx, buyer.ID, []OrderLine{{VariantID: variant.ID, Quantity: 1}},
+			CheckoutOptions{PaymentMethod: models.PaymentMethodWallet})
+		return err
+	}); err != nil {
+		t.Fatalf("place free order: %v", err)
+	}
+	if !orders[0].TotalPrice.IsZero() {
+		t.Fatalf("order total = %s, want zero", orders[0].TotalPrice)
+	}
+	return orders[0]
+}
+
+func TestFreeWalletOrderMovesNoMoney(t *testing.T) {
+	tests := []struct {
+		name        string
+		transitions []string
+		wantEscrow  string
+	}{
+		{"placed", nil, models.EscrowStatusHeld},
+		{"cancelled", []string{models.OrderStatusCancelled}, models.EscrowStatusRefunded},
+		{"delivered", []string{models.OrderStatusConfirmed, models.OrderStatusPacked, models.OrderStatusOutForDelivery, models.OrderStatusDelivered}, models.EscrowStatusReleased},
+		{"refunded", []string{models.OrderStatusConfirmed, models.OrderStatusPacked, models.OrderStatusOutForDelivery, models.OrderStatusDelivered, models.OrderStatusRefunded}, models.EscrowStatusRefunded},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := testutil.OpenDB(t)
+			order := placeFreeWalletOrder(t, db)
+			for _, to := range test.transitions {
+				transitionTestOrder(t, db, order.ID, to)
+			}
+
+			var hold models.EscrowHold
+			if err := db.Where("order_id = ?", order.ID).First(&hold).Error; err != nil {
+				t.Fatalf("load escrow hold: %v", err)
+			}
+			if hold.Status != test.wantEscrow {
+				t.Errorf("escrow status = %s, want %s", hold.Status, test.wantEscrow)
+			}
+			var entries int64
+			if err := db.Model(&models.JournalEntry{}).Count(&entries).Error; err != nil {
+				t.Fatalf("count journal entries: %v", err)
+			}
+			if entries != 0 {
+				t.Errorf("%d journal entries posted for a free order, want none", entries)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"errors"
+	"farmers_market_backend/config"
+	"farmers_market_backend/models"
+	"fmt"
+	"log"
+	"strconv"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrUnbalancedJournal is returned when the debits and credits of a journal entry differ
+	ErrUnbalancedJournal = errors.New("journal entry debits and credits do not balance")
+	// ErrInsufficientFunds is returned when a wallet balance does not cover a payment
+	ErrInsufficientFunds = errors.New("insufficient wallet balance")
+)
+
+// Posting is one line of a journal entry to be posted. Exactly one of Debit
+// and Credit is set.
+type Posting struct {
+	Account  string
+	WalletID *uint
+	Debit    models.Money
+	Credit   models.Money
+}
+
+// DebitWallet returns a posting that takes amount out of a wallet
+func DebitWallet(wallet models.Wallet, amount models.Money) Posting {
+	return Posting{Account: models.WalletAccount(wallet.ID), WalletID: &wallet.ID, Debit: amount}
+}
+
+// CreditWallet returns a posting that puts amount into a wallet
+func CreditWallet(wallet models.Wallet, amount models.Money) Posting {
+	return Posting{Account: models.WalletAccount(wallet.ID), WalletID: &wallet.ID, Credit: amount}
+}
+
+// PostJournal appends a balanced journal entry to the ledger and updates the
+// cached balance of every wallet it touches. All postings must be in the same
+// currency. It must be called inside the same transaction as the business
+// change it records.
+func PostJournal(tx *gorm.DB, reference, description string, orderID *uint, postings ...Posting) (models.JournalEntry, error) {
+	entry := models.JournalEntry{Reference: reference, Description: description, OrderID: orderID}
+
+	var currency string
+	var debits, credits int64
+	for _, posting := range postings {
+		if posting.Debit.Minor < 0 || posting.Credit.Minor < 0 || (posting.Debit.Minor > 0 && posting.Credit.Minor > 0) {
+			return entry, fmt.Errorf("invalid posting to %s", posting.Account)
+		}
+		if posting.Debit.IsZero() && posting.Credit.IsZero() {
+			continue
+		}
+
+		amount := posting.Debit.Add(posting.Credit)
+		if currency == "" {
+			currency = amount.Currency
+		} else if amount.Currency != currency {
+			return entry, models.ErrCurrencyMismatch
+		}
+
+		debits += posting.Debit.Minor
+		credits += posting.Credit.Minor
+		entry.Lines = append(entry.Lines, models.LedgerEntry{
+			Account:     posting.Account,
+			WalletID:    posting.WalletID,
+			Currency:    currency,
+			DebitMinor:  posting.Debit.Minor,
+			CreditMinor: posting.Credit.Minor,
+		})
+	}
+	if len(entry.Lines) == 0 || debits != credits {
+		return entry, ErrUnbalancedJournal
+	}
+
+	if err := tx.Create(&entry).Error; err != nil {
+		return entry, err
+	}
+
+	for _, line := range entry.Lines {
+		if line.WalletID == nil {
+			continue
+		}
+		if err := tx.Model(&models.Wallet{}).Where("id = ?", *line.WalletID).
+			Update("balance_minor", gorm.Expr("balance_minor + ?", line.CreditMinor-line.DebitMinor)).Error; err != nil {
+			return entry, err
+		}
+	}
+
+	return entry, nil
+}
+
+// ReverseJournal posts an entry that undoes the journal entry with the given
+// reference. It does nothing if no such entry exists.
+func ReverseJournal(tx *gorm.DB, reference, reversalReference, description string) error {
+	var original models.JournalEntry
+	if err := tx.Preload("Lines").Where("reference = ?", reference).First(&original).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	postings := make([]Posting, 0, len(original.Lines))
+	for _, line := range original.Lines {
+		postings = append(postings, Posting{
+			Account:  line.Account,
+			WalletID: line.WalletID,
+			Debit:    line.Credit(),
+			Credit:   line.Debit(),
+		})
+	}
+
+	_, err := PostJournal(tx, reversalReference, description, original.OrderID, postings...)
+	return err
+}
+
+// GetOrCreateWallet returns the wallet of a user, creating an empty one if
+// needed. The wallet row is locked until the end of the transaction. When
+// another transaction creates the wallet first, the unique user index rejects
+// the second wallet and the one already created is returned instead.
+func GetOrCreateWallet(tx *gorm.DB, userID uint) (models.Wallet, error) {
+	var wallet models.Wallet
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userID).First(&wallet).Error
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return wallet, err
+	}
+
+	// The create runs in a savepoint so that a failed insert does not abort
+	// the outer transaction on PostgreSQL
+	wallet = models.Wallet{UserID: userID, Balance: models.NewMoney(0, models.DefaultCurrency)}
+	createErr := tx.Transaction(func(tx *gorm.DB) error { return tx.Create(&wallet).Error })
+	if createErr == nil {
+		return wallet, nil
+	}
+	var existing models.Wallet
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userID).First(&existing).Error; err != nil {
+		return wallet, createErr
+	}
+	return existing, nil
+}
+
+// LedgerBalance derives the balance of a wallet from its ledger entries
+func LedgerBalance(db *gorm.DB, wallet models.Wallet) (models.Money, error) {
+	var minor int64
+	err := db.Model(&models.LedgerEntry{}).
+		Select("COALESCE(SUM(credit_minor) - SUM(debit_minor), 0)").
+		Where("wallet_id = ?", wallet.ID).
+		Scan(&minor).Error
+	return models.NewMoney(minor, wallet.Balance.Currency), err
+}
+
+// CommissionRate returns the share of each wallet payment kept by the platform
+func CommissionRate() float64 {
+	rate, err := strconv.ParseFloat(config.GetEnv("PLATFORM_COMMISSION_RATE", "0.05"), 64)
+	if err != nil || rate < 0 || rate > 1 {
+		log.Printf("Warning: invalid PLATFORM_COMMISSION_RATE, using 0.05")
+		return 0.05
+	}
+	return rate
+}
@@ -0,0 +1,54 @@
+package services
+
+import (
+	"testing"
+
+	"farmers_market_backend/models"
+	"farmers_market_backend/testutil"
+
+	"gorm.io/gorm"
+)
+
+// TestGetOrCreateWalletLosesCreateRace creates the wallet of the user between
+// the lookup and the insert of GetOrCreateWallet, as a concurrent payment
+// would, and checks the wallet created first is returned instead of an error
+func TestGetOrCreateWalletLosesCreateRace(t *testing.T) {
+	db := testutil.OpenDB(t)
+	user := testutil.CreateUser(t, db, "buyer")
+
+	var first models.Wallet
+	raced := false
+	// The competing wallet is created right after the lookup finds none
+	if err := db.Callback().Query().After("gorm:query").Register("test:race_wallet", func(tx *gorm.DB) {
+		if _, ok := tx.Statement.Dest.(*models.Wallet); !ok || raced || tx.RowsAffected > 0 {
+			return
+		}
+		raced = true
+		first = models.Wallet{UserID: user.ID, Balance: models.NewMoney(0, models.DefaultCurrency)}
+		notFound := tx.Error
+		tx.Error = nil
+		if err := tx.Session(&gorm.Session{NewDB: true}).Create(&first).Error; err != nil {
+			t.Errorf("create competing wallet: %v", err)
+		}
+		tx.Error = notFound
+	}); err != nil {
+		t.Fatalf("register callback: %v", err)
+	}
+
+	var wallet models.Wallet
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		wallet, err = GetOrCreateWallet(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("get or create wallet: %v", err)
+	}
+	if !raced || wallet.ID != first.ID {
+		t.Errorf("got wallet %d, want the competing wallet %d", wallet.ID, first.ID)
+	}
+	var wallets int64
+	if err := db.Model(&models.Wallet{}).Where("user_id = ?", user.ID).Count(&wallets).Error; err != nil || wallets != 1 {
+		t.Errorf("user has %d wallets (%v), want 1", wallets, err)
+	}
+}
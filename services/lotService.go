@@ -0,0 +1,352 @@
+package services
+
+import (
+	"errors"
+	"farmers_market_backend/models"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrStockReserved is returned when a lot change would leave less sellable
+// stock than pending orders have reserved
+var ErrStockReserved = errors.New("stock is reserved by pending orders")
+
+// VariantStock is the stock view of a variant, counting only sellable lots
+type VariantStock struct {
+	VariantID      uint       `json:"variant_id"`
+	SKU            string     `json:"sku"`
+	Sellable       float64    `json:"sellable"`         // Remaining quantity of lots that are not expired
+	Reserved       float64    `json:"reserved"`         // Quantity held by pending orders
+	Available      float64    `json:"available"`        // Sellable minus reserved, what buyers can still order
+	Lots           int        `json:"lots"`             // Number of sellable lots
+	NextBestBefore *time.Time `json:"next_best_before"` // Earliest best-before date of the sellable lots
+}
+
+// sellableLots selects the lots of a variant that stock can be sold from at
+// time t, in first-expiry-first-out order. Lots without a best-before date
+// come last and lots with the same date are used oldest harvest first.
+func sellableLots(db *gorm.DB, variantID uint, t time.Time) *gorm.DB {
+	return db.Model(&models.InventoryLot{}).
+		Where("variant_id = ? AND status = ? AND remaining > 0", variantID, models.LotStatusAvailable).
+		Where("best_before IS NULL OR best_before > ?", t).
+		Order("best_before IS NULL, best_before, harvest_date, id")
+}
+
+// syncVariantStock sets the stock of a variant to the remaining quantity of
+// its sellable lots. The variant row must be locked by the caller.
+func syncVariantStock(tx *gorm.DB, variantID uint) error {
+	var stock float64
+	if err := sellableLots(tx, variantID, time.Now()).Select("COALESCE(SUM(remaining), 0)").Scan(&stock).Error; err != nil {
+		return err
+	}
+	return tx.Model(&models.ProductVariant{}).Where("id = ?", variantID).Update("stock", roundQuantity(stock)).Error
+}
+
+// lockVariant locks the row of a variant for the rest of the transaction
+func lockVariant(tx *gorm.DB, variantID uint) (models.ProductVariant, error) {
+	var variant models.ProductVariant
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&variant, variantID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return variant, ErrVariantNotFound
+		}
+		return variant, err
+	}
+	return variant, nil
+}
+
+// ReceiveLot adds a harvest lot to the stock of its variant and starts its
+// handling history. A lot code is generated when none is given.
+func ReceiveLot(tx *gorm.DB, lot *models.InventoryLot, recordedByID *uint) error {
+	if _, err := lockVariant(tx, lot.VariantID); err != nil {
+		return err
+	}
+
+	lot.Remaining = lot.Quantity
+	lot.WrittenOff = 0
+	lot.Status = models.LotStatusAvailable
+	lot.WrittenOffAt = nil
+	generated := lot.LotCode == ""
+	if generated {
+		// Placeholder until the ID is known, so the unique index is not violated
+		lot.LotCode = fmt.Sprintf("PENDING-%d-%d", lot.VariantID, time.Now().UnixNano())
+	}
+	if err := tx.Create(lot).Error; err != nil {
+		return err
+	}
+	if generated {
+		lot.LotCode = fmt.Sprintf("LOT-%s-%06d", lot.HarvestDate.Format("20060102"), lot.ID)
+		if err := tx.Model(lot).Update("lot_code", lot.LotCode).Error; err != nil {
+			return err
+		}
+	}
+
+	events := []models.LotEvent{
+		{LotID: lot.ID, Type: models.LotEventHarvested, OccurredAt: lot.HarvestDate, RecordedByID: recordedByID},
+		{LotID: lot.ID, Type: models.LotEventStored, Location: lot.StorageLocation, OccurredAt: time.Now(), RecordedByID: recordedByID},
+	}
+	if err := tx.Create(&events).Error; err != nil {
+		return err
+	}
+
+	return syncVariantStock(tx, lot.VariantID)
+}
+
+// LotChanges are the changes a seller can make to a lot. Nil fields are kept.
+type LotChanges struct {
+	BestBefore      *time.Time
+	StorageLocation *string
+	Remaining       *float64 // Corrected remaining quantity after a stock count, for example when produce was damaged
+}
+
+// UpdateLot applies changes to a lot and recalculates the stock of its
+// variant. The changes may not leave less stock than pending orders have
+// reserved.
+func UpdateLot(tx *gorm.DB, lot *models.InventoryLot, changes LotChanges) error {
+	variant, err := lockVariant(tx, lot.VariantID)
+	if err != nil {
+		return err
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(lot, lot.ID).Error; err != nil {
+		return err
+	}
+	if lot.Status == models.LotStatusExpired {
+		return fmt.Errorf("lot %s has expired and was written off", lot.LotCode)
+	}
+
+	if changes.BestBefore != nil {
+		lot.BestBefore = changes.BestBefore
+	}
+	if changes.StorageLocation != nil {
+		lot.StorageLocation = *changes.StorageLocation
+	}
+	if changes.Remaining != nil {
+		lot.Remaining = roundQuantity(*changes.Remaining)
+		lot.Status = models.LotStatusAvailable
+		if lot.Remaining <= 0 {
+			lot.Status = models.LotStatusDepleted
+		}
+	}
+	if err := tx.Save(lot).Error; err != nil {
+		return err
+	}
+	if err := syncVariantStock(tx, lot.VariantID); err != nil {
+		return err
+	}
+
+	var stock float64
+	if err := tx.Model(&models.ProductVariant{}).Where("id = ?", variant.ID).Pluck("stock", &stock).Error; err != nil {
+		return err
+	}
+	if stock < variant.ReservedStock-math.Pow10(-quantityPrecision) {
+		return ErrStockReserved
+	}
+	return nil
+}
+
+// allocateLots fulfils an order line from the sellable lots of its variant,
+// first expiry first out, and records which lots it was taken from. The
+// variant row must be locked by the caller.
+func allocateLots(tx *gorm.DB, item models.OrderItem) error {
+	var lots []models.InventoryLot
+	if err := sellableLots(tx, item.VariantID, time.Now()).
+		Clauses(clause.Locking{Strength: "UPDATE"}).Find(&lots).Error; err != nil {
+		return err
+	}
+
+	needed := item.Quantity
+	for _, lot := range lots {
+		if needed <= math.Pow10(-quantityPrecision) {
+			break
+		}
+		taken := math.Min(needed, lot.Remaining)
+		remaining := roundQuantity(lot.Remaining - taken)
+		status := models.LotStatusAvailable
+		if remaining <= 0 {
+			status = models.LotStatusDepleted
+		}
+		if err := tx.Model(&lot).Updates(map[string]interface{}{"remaining": remaining, "status": status}).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&models.LotAllocation{OrderItemID: item.ID, LotID: lot.ID, Quantity: taken}).Error; err != nil {
+			return err
+		}
+		needed = roundQuantity(needed - taken)
+	}
+	if needed > math.Pow10(-quantityPrecision) {
+		return ErrInsufficientStock
+	}
+	return nil
+}
+
+// releaseLots puts the stock of a cancelled order line back into the lots it
+// was taken from. Stock returned to a lot that has expired since is written
+// off with it. Lines fulfilled before lots were tracked are returned as a new
+// lot. Every return is recorded as an event of the lot. The variant row must
+// be locked by the caller.
+func releaseLots(tx *gorm.DB, item models.OrderItem) error {
+	var allocations []models.LotAllocation
+	if err := tx.Where("order_item_id = ?", item.ID).Find(&allocations).Error; err != nil {
+		return err
+	}
+
+	returned := 0.0
+	for _, allocation := range allocations {
+		var lot models.InventoryLot
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&lot, allocation.LotID).Error; err != nil {
+			return err
+		}
+		updates := map[string]interface{}{}
+		if lot.Status == models.LotStatusExpired {
+			updates["written_off"] = roundQuantity(lot.WrittenOff + allocation.Quantity)
+		} else {
+			updates["remaining"] = roundQuantity(lot.Remaining + allocation.Quantity)
+			updates["status"] = models.LotStatusAvailable
+		}
+		if err := tx.Model(&lot).Updates(updates).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&allocation).Error; err != nil {
+			return err
+		}
+		if err := createReturnEvent(tx, lot.ID, allocation.Quantity); err != nil {
+			return err
+		}
+		returned += allocation.Quantity
+	}
+
+	if unallocated := roundQuantity(item.Quantity - returned); unallocated > 0 {
+		// The lot code is a placeholder until the ID is known, so the unique index is not violated
+		lot := models.InventoryLot{
+			VariantID:   item.VariantID,
+			LotCode:     fmt.Sprintf("PENDING-%d-%d", item.VariantID, time.Now().UnixNano()),
+			HarvestDate: time.Now(),
+			Quantity:    unallocated,
+			Remaining:   unallocated,
+			Status:      models.LotStatusAvailable,
+		}
+		if err := tx.Create(&lot).Error; err != nil {
+			return err
+		}
+		lot.LotCode = fmt.Sprintf("RETURN-%06d", lot.ID)
+		if err := tx.Model(&lot).Update("lot_code", lot.LotCode).Error; err != nil {
+			return err
+		}
+		if err := createReturnEvent(tx, lot.ID, unallocated); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createReturnEvent records stock of an order coming back into a lot. Lot
+// events are public, so like dispatches the order is not identified.
+func createReturnEvent(tx *gorm.DB, lotID uint, quantity float64) error {
+	return tx.Create(&models.LotEvent{
+		LotID:      lotID,
+		Type:       models.LotEventReturned,
+		Note:       fmt.Sprintf("%v returned to stock", quantity),
+		OccurredAt: time.Now(),
+	}).Error
+}
+
+// ExpireLots writes off the remaining stock of lots past their best-before date
+func ExpireLots(db *gorm.DB) error {
+	var lots []models.InventoryLot
+	if err := db.Select("id, variant_id").
+		Where("status = ? AND best_before <= ?", models.LotStatusAvailable, time.Now()).
+		Find(&lots).Error; err != nil {
+		return err
+	}
+
+	for _, lot := range lots {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			variant, err := lockVariant(tx, lot.VariantID)
+			if err != nil {
+				return err
+			}
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&lot, lot.ID).Error; err != nil {
+				return err
+			}
+			// The lot may have been sold out or corrected since it was selected
+			if lot.Status != models.LotStatusAvailable {
+				return nil
+			}
+
+			writtenOff := lot.Remaining
+			if err := tx.Model(&lot).Updates(map[string]interface{}{
+				"status":         models.LotStatusExpired,
+				"written_off":    roundQuantity(lot.WrittenOff + writtenOff),
+				"remaining":      0,
+				"written_off_at": time.Now(),
+			}).Error; err != nil {
+				return err
+			}
+			if err := tx.Create(&models.LotEvent{
+				LotID:      lot.ID,
+				Type:       models.LotEventWrittenOff,
+				Note:       fmt.Sprintf("%v written off after the best-before date", writtenOff),
+				OccurredAt: time.Now(),
+			}).Error; err != nil {
+				return err
+			}
+			if err := syncVariantStock(tx, variant.ID); err != nil {
+				return err
+			}
+			log.Printf("Wrote off %v of expired lot %s", writtenOff, lot.LotCode)
+			return nil
+		})
+		if err != nil {
+			log.Printf("Failed to expire lot %d: %v", lot.ID, err)
+		}
+	}
+	return nil
+}
+
+// StartLotExpirySweeper periodically writes off expired lots in the background
+func StartLotExpirySweeper(db *gorm.DB, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := ExpireLots(db); err != nil {
+				log.Printf("Failed to expire inventory lots: %v", err)
+			}
+		}
+	}()
+}
+
+// GetProductStock returns the stock view of every variant of a product. Lots
+// past their best-before date are not counted even if they have not been
+// written off yet.
+func GetProductStock(db *gorm.DB, productID uint) ([]VariantStock, error) {
+	var variants []models.ProductVariant
+	if err := db.Where("product_id = ?", productID).Order("id").Find(&variants).Error; err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	stocks := make([]VariantStock, 0, len(variants))
+	for _, variant := range variants {
+		var lots []models.InventoryLot
+		if err := sellableLots(db, variant.ID, now).Find(&lots).Error; err != nil {
+			return nil, err
+		}
+
+		stock := VariantStock{VariantID: variant.ID, SKU: variant.SKU, Reserved: variant.ReservedStock, Lots: len(lots)}
+		for _, lot := range lots {
+			stock.Sellable += lot.Remaining
+			if lot.BestBefore != nil && (stock.NextBestBefore == nil || lot.BestBefore.Before(*stock.NextBestBefore)) {
+				stock.NextBestBefore = lot.BestBefore
+			}
+		}
+		stock.Sellable = roundQuantity(stock.Sellable)
+		stock.Available = math.Max(roundQuantity(stock.Sellable-stock.Reserved), 0)
+		stocks = append(stocks, stock)
+	}
+	return stocks, nil
+}
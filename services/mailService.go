@@ -0,0 +1,187 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+)
+
+// Email is a message with a plain text and an HTML body
+type Email struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	HTML    string `json:"html"`
+}
+
+// Mailer sends emails to users
+type Mailer interface {
+	Send(email Email) error
+}
+
+// SMTPMailer sends emails through an SMTP server, authenticating with PLAIN
+// auth when a username is set
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// Send delivers the email as a multipart/alternative message
+func (m *SMTPMailer) Send(email Email) error {
+	message, err := buildMessage(m.From, email)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	return smtp.SendMail(m.Host+":"+m.Port, auth, m.From, []string{email.To}, message)
+}
+
+// buildMessage encodes an email with its text and HTML alternatives
+func buildMessage(from string, email Email) ([]byte, error) {
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=UTF-8", email.Text},
+		{"text/html; charset=UTF-8", email.HTML},
+	} {
+		writer, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoder := quotedprintable.NewWriter(writer)
+		if _, err := encoder.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := encoder.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", from)
+	fmt.Fprintf(&message, "To: %s\r\n", email.To)
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("UTF-8", email.Subject))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	message.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&message, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", parts.Boundary())
+	message.Write(body.Bytes())
+	return message.Bytes(), nil
+}
+
+// FakeMailer is a Mailer for local development and tests. It keeps the
+// emails it is given in memory and, if Dir is set, also writes each one to a
+// file there so links in them can be followed by hand.
+type FakeMailer struct {
+	Dir  string
+	mu   sync.Mutex
+	sent []Email
+}
+
+// NewFakeMailer creates a fake mailer writing emails to dir, or only keeping
+// them in memory if dir is empty
+func NewFakeMailer(dir string) *FakeMailer {
+	return &FakeMailer{Dir: dir}
+}
+
+// Send records the email
+func (m *FakeMailer) Send(email Email) error {
+	m.mu.Lock()
+	m.sent = append(m.sent, email)
+	m.mu.Unlock()
+
+	if m.Dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	message, err := buildMessage("fake@localhost", email)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102-150405"), hex.EncodeToString(suffix))
+	return os.WriteFile(filepath.Join(m.Dir, name), message, 0o644)
+}
+
+// Sent returns the emails sent so far, oldest first
+func (m *FakeMailer) Sent() []Email {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Email(nil), m.sent...)
+}
+
+// mailer is the mailer used for account emails
+var mailer Mailer = NewFakeMailer("")
+
+// SetMailer replaces the mailer used for account emails
+func SetMailer(m Mailer) {
+	mailer = m
+}
+
+// CurrentMailer returns the mailer used for account emails
+func CurrentMailer() Mailer {
+	return mailer
+}
+
+//go:embed templates/*.tmpl
+var templateFiles embed.FS
+
+var (
+	htmlTemplates = htmltemplate.Must(htmltemplate.ParseFS(templateFiles, "templates/*.html.tmpl"))
+	textTemplates = texttemplate.Must(texttemplate.ParseFS(templateFiles, "templates/*.txt.tmpl"))
+)
+
+// renderEmail fills in the text and HTML templates called name with data
+func renderEmail(to, subject, name string, data interface{}) (Email, error) {
+	var text, html strings.Builder
+	if err := textTemplates.ExecuteTemplate(&text, name+".txt.tmpl", data); err != nil {
+		return Email{}, err
+	}
+	if err := htmlTemplates.ExecuteTemplate(&html, name+".html.tmpl", data); err != nil {
+		return Email{}, err
+	}
+	return Email{To: to, Subject: subject, Text: text.String(), HTML: html.String()}, nil
+}
+
+// sendEmail renders and sends an email, logging failures
+func sendEmail(to, subject, name string, data interface{}) error {
+	email, err := renderEmail(to, subject, name, data)
+	if err == nil {
+		err = mailer.Send(email)
+	}
+	if err != nil {
+		log.Printf("Failed to send %s email to %s: %v", name, to, err)
+	}
+	return err
+}
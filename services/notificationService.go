@@ -0,0 +1,18 @@
+package services
+
+import (
+	"farmers_market_backend/models"
+
+	"gorm.io/gorm"
+)
+
+// Notify stores an in-app notification for a user
+func Notify(db *gorm.DB, userID uint, notificationType, title, body string, productID *uint) error {
+	return db.Create(&models.Notification{
+		UserID:    userID,
+		Type:      notificationType,
+		Title:     title,
+		Body:      body,
+		ProductID: productID,
+	}).Error
+}
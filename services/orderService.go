@@ -0,0 +1,329 @@
+package services
+
+import (
+	"errors"
+	"farmers_market_backend/models"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrEmptyOrder is returned when an order or checkout has no lines
+var ErrEmptyOrder = errors.New("order has no items")
+
+// OrderLine is a product variant and quantity requested by a buyer
+type OrderLine struct {
+	VariantID         uint
+	Quantity          float64
+	UnitOfMeasureID   uint          // Unit the quantity is given in, 0 for the unit of the variant
+	ExpectedUnitPrice *models.Money // Unit price the buyer last saw, nil to accept the current price
+}
+
+// LineError describes why a single order line failed validation
+type LineError struct {
+	ProductID uint   `json:"product_id,omitempty"`
+	VariantID uint   `json:"variant_id"`
+	Reason    string `json:"reason"`
+}
+
+// OrderValidationError is returned when one or more order lines are invalid
+type OrderValidationError struct {
+	Lines []LineError `json:"lines"`
+}
+
+func (e *OrderValidationError) Error() string {
+	return "order validation failed"
+}
+
+// StockConflictError is returned when there is not enough unreserved stock
+// left for one or more order lines, usually because another order took it
+type StockConflictError struct {
+	Lines []LineError `json:"lines"`
+}
+
+func (e *StockConflictError) Error() string {
+	return "insufficient stock"
+}
+
+// ErrInvalidPaymentMethod is returned for an unknown payment method
+var ErrInvalidPaymentMethod = errors.New("invalid payment method")
+
+// CheckoutOptions are the choices a buyer makes when placing orders
+type CheckoutOptions struct {
+	PaymentMethod      string // One of the models.PaymentMethod constants
+	CouponCode         string // Coupon to apply, empty for none
+	DeliveryAddress    string // Address to deliver to, empty for the buyer's delivery address
+	DeliveryDistrictID uint   // District to deliver to, 0 for the buyer's district
+}
+
+// CreateOrders validates the lines against the current product data, reserves
+// stock for them and creates one order per seller, each with its own order
+// lines priced by the pricing engine and the delivery fee of the seller's
+// delivery zone for the destination district. A coupon is redeemed once for
+// the whole checkout and its discount shared between the orders. Orders paid from the
+// wallet are charged to the buyer's wallet. It must be called inside a
+// transaction so that either all orders are created, all stock reserved and
+// all payments posted, or nothing is.
+func CreateOrders(tx *gorm.DB, buyerID uint, lines []OrderLine, options CheckoutOptions) ([]models.Order, error) {
+	paymentMethod := options.PaymentMethod
+	if len(lines) == 0 {
+		return nil, ErrEmptyOrder
+	}
+	if paymentMethod != models.PaymentMethodWallet && paymentMethod != models.PaymentMethodCashOnDelivery {
+		return nil, ErrInvalidPaymentMethod
+	}
+
+	// Lock variants in a fixed order so concurrent checkouts cannot deadlock
+	lines = append([]OrderLine(nil), lines...)
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].VariantID < lines[j].VariantID })
+
+	variantIDs := make([]uint, 0, len(lines))
+	for _, line := range lines {
+		variantIDs = append(variantIDs, line.VariantID)
+	}
+	buyerPrices, err := LoadBuyerPrices(tx, buyerID, variantIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	linesBySeller := map[uint][]PricedLine{}
+	var sellerIDs []uint
+	var lineErrors, stockErrors []LineError
+
+	for _, line := range lines {
+		var variant models.ProductVariant
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Product").Preload("UnitOfMeasure").Preload("PriceTiers").
+			First(&variant, line.VariantID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				lineErrors = append(lineErrors, LineError{VariantID: line.VariantID, Reason: "product variant not found"})
+				continue
+			}
+			return nil, err
+		}
+		product := *variant.Product
+
+		// Orders are priced and stocked in the unit of the variant
+		quantity, err := QuantityInVariantUnit(tx, variant, line.Quantity, line.UnitOfMeasureID)
+		if errors.Is(err, ErrIncompatibleUnits) {
+			lineErrors = append(lineErrors, LineError{ProductID: product.ID, VariantID: variant.ID, Reason: "quantity cannot be converted to " + variant.UnitOfMeasure.Name})
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		line.Quantity, line.UnitOfMeasureID = quantity, variant.UnitOfMeasureID
+
+		priced := PriceLine(product, variant, line.Quantity, buyerPrices)
+		if reason := validateOrderLine(variant, line, priced.UnitPrice); reason != "" {
+			lineErrors = append(lineErrors, LineError{ProductID: product.ID, VariantID: variant.ID, Reason: reason})
+			continue
+		}
+
+		if err := ReserveStock(tx, variant, line.Quantity); err != nil {
+			if errors.Is(err, ErrInsufficientStock) {
+				stockErrors = append(stockErrors, LineError{
+					ProductID: product.ID,
+					VariantID: variant.ID,
+					Reason:    fmt.Sprintf("only %v %s left in stock", roundQuantity(variant.Stock-variant.ReservedStock), variant.UnitOfMeasure.Abbreviation),
+				})
+				continue
+			}
+			return nil, err
+		}
+
+		// Group lines by seller so each seller receives its own order
+		if _, ok := linesBySeller[product.SellerID]; !ok {
+			sellerIDs = append(sellerIDs, product.SellerID)
+		}
+		linesBySeller[product.SellerID] = append(linesBySeller[product.SellerID], priced)
+	}
+
+	if len(lineErrors) > 0 {
+		return nil, &OrderValidationError{Lines: lineErrors}
+	}
+	if len(stockErrors) > 0 {
+		return nil, &StockConflictError{Lines: stockErrors}
+	}
+
+	destination, err := ResolveDestination(tx, buyerID, options.DeliveryAddress, options.DeliveryDistrictID)
+	if err != nil {
+		return nil, err
+	}
+	quotes := map[uint]DeliveryQuote{}
+	var sellerErrors []SellerError
+	for _, sellerID := range sellerIDs {
+		quote, reason, err := QuoteDelivery(tx, sellerID, destination, linesBySeller[sellerID])
+		if err != nil {
+			return nil, err
+		}
+		if reason != "" {
+			sellerErrors = append(sellerErrors, SellerError{SellerID: sellerID, Reason: reason})
+			continue
+		}
+		quotes[sellerID] = quote
+	}
+	if len(sellerErrors) > 0 {
+		return nil, &UndeliverableError{Sellers: sellerErrors}
+	}
+
+	now := time.Now()
+	discounts := map[uint]models.Money{}
+	var redemptionID *uint
+	if options.CouponCode != "" {
+		coupon, err := findRedeemableCoupon(tx, options.CouponCode, buyerID, now)
+		if err != nil {
+			return nil, err
+		}
+		var total models.Money
+		discounts, total, err = couponDiscounts(coupon, linesBySeller, sellerIDs)
+		if err != nil {
+			return nil, err
+		}
+
+		redemption := models.CouponRedemption{CouponID: coupon.ID, UserID: buyerID, Discount: total}
+		if err := tx.Create(&redemption).Error; err != nil {
+			return nil, err
+		}
+		redemptionID = &redemption.ID
+	}
+
+	orders := make([]models.Order, 0, len(sellerIDs))
+	for _, sellerID := range sellerIDs {
+		quote := quotes[sellerID]
+		order := buildOrder(buyerID, sellerID, paymentMethod, now, PriceOrder(linesBySeller[sellerID], discounts[sellerID], quote.Fee))
+		order.CouponRedemptionID = redemptionID
+		order.DeliveryAddress = destination.Address
+		if destination.DistrictID != 0 {
+			order.DeliveryDistrictID = &destination.DistrictID
+		}
+		order.DeliveryZoneID = quote.ZoneID
+		if err := tx.Create(&order).Error; err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+
+	if paymentMethod == models.PaymentMethodWallet {
+		if err := PayOrdersFromWallet(tx, buyerID, orders); err != nil {
+			return nil, err
+		}
+	}
+
+	return orders, nil
+}
+
+// buildOrder creates a pending order with its lines from a price breakdown
+func buildOrder(buyerID, sellerID uint, paymentMethod string, now time.Time, breakdown PriceBreakdown) models.Order {
+	order := models.Order{
+		BuyerID:          buyerID,
+		SellerID:         sellerID,
+		Subtotal:         breakdown.Subtotal,
+		DiscountTotal:    breakdown.DiscountTotal,
+		CouponDiscount:   breakdown.CouponDiscount,
+		DeliveryFee:      breakdown.DeliveryFee,
+		TaxTotal:         breakdown.TaxTotal,
+		TotalPrice:       breakdown.Total,
+		Status:           models.OrderStatusPending,
+		PaymentMethod:    paymentMethod,
+		StockReserved:    true,
+		OrderDateTime:    now,
+		DeliveryDateTime: now,
+		Events: []models.OrderEvent{
+			{ToStatus: models.OrderStatusPending, ActorID: &buyerID, ActorRole: OrderRoleBuyer},
+		},
+	}
+
+	for _, line := range breakdown.Lines {
+		order.Items = append(order.Items, models.OrderItem{
+			ProductID: line.Product.ID,
+			VariantID: line.Variant.ID,
+			Quantity:  line.Quantity,
+			ListPrice: line.ListPrice,
+			UnitPrice: line.UnitPrice,
+			Discount:  line.Discount,
+			PriceRule: line.PriceRule,
+			LineTotal: line.LineTotal,
+		})
+
+		// The order is delivered once its slowest product is delivered
+		deliveryDateTime := now.Add(time.Duration(line.Product.DeliveryTime) * time.Hour)
+		if deliveryDateTime.After(order.DeliveryDateTime) {
+			order.DeliveryDateTime = deliveryDateTime
+		}
+	}
+
+	return order
+}
+
+// validateOrderLine checks quantity, minimum order quantity, step size and
+// price of a single line and returns the reason it is invalid, or an empty string.
+func validateOrderLine(variant models.ProductVariant, line OrderLine, unitPrice models.Money) string {
+	if variant.Product != nil && (variant.Product.DelistedAt != nil || !InSeason(*variant.Product, time.Now())) {
+		return "product is out of season"
+	}
+	if reason := CheckQuantity(variant, line.Quantity); reason != "" {
+		return reason
+	}
+	if line.ExpectedUnitPrice != nil && line.ExpectedUnitPrice.Cmp(unitPrice) != 0 {
+		return fmt.Sprintf("price changed from %s to %s", line.ExpectedUnitPrice, unitPrice)
+	}
+	return ""
+}
+
+// CheckoutCart converts the user's cart into orders paid with the given
+// payment method and empties the cart. Cart prices are checked against the
+// current product prices.
+func CheckoutCart(tx *gorm.DB, userID uint, options CheckoutOptions) ([]models.Order, error) {
+	var cart models.Cart
+	if err := tx.Preload("Items").Where("user_id = ?", userID).First(&cart).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrEmptyOrder
+		}
+		return nil, err
+	}
+
+	lines := make([]OrderLine, 0, len(cart.Items))
+	for i := range cart.Items {
+		lines = append(lines, OrderLine{
+			VariantID:         cart.Items[i].VariantID,
+			Quantity:          cart.Items[i].Quantity,
+			ExpectedUnitPrice: &cart.Items[i].UnitPrice,
+		})
+	}
+
+	orders, err := CreateOrders(tx, userID, lines, options)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Unscoped().Where("cart_id = ?", cart.ID).Delete(&models.CartItem{}).Error; err != nil {
+		return nil, err
+	}
+
+	return orders, nil
+}
+
+// RefreshCartPrices updates the unit prices stored in the user's cart to the
+// current prices for the buyer, so the buyer can review them before checking out again.
+func RefreshCartPrices(db *gorm.DB, userID uint) error {
+	var cart models.Cart
+	if err := db.Preload("Items.Product").Preload("Items.Variant").Where("user_id = ?", userID).First(&cart).Error; err != nil {
+		return err
+	}
+
+	for _, item := range cart.Items {
+		price, err := QuoteUnitPrice(db, userID, item.Product, item.Variant, item.Quantity)
+		if err != nil {
+			return err
+		}
+		if err := db.Model(&models.CartItem{}).Where("id = ?", item.ID).Updates(map[string]interface{}{
+			"unit_price_minor":    price.Minor,
+			"unit_price_currency": price.Currency,
+		}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"errors"
+	"farmers_market_backend/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Roles in which a user can act on an order
+const (
+	OrderRoleBuyer    = "buyer"
+	OrderRoleSeller   = "seller"
+	OrderRoleDelivery = "delivery_agent"
+	OrderRoleAdmin    = "admin"
+	OrderRoleSystem   = "system"
+)
+
+var (
+	// ErrOrderNotFound is returned when the order to transition does not exist
+	ErrOrderNotFound = errors.New("order not found")
+	// ErrInvalidTransition is returned when the order cannot move from its current status to the requested one
+	ErrInvalidTransition = errors.New("invalid order status transition")
+	// ErrTransitionForbidden is returned when the actor is not allowed to make the transition
+	ErrTransitionForbidden = errors.New("not allowed to change the status of this order")
+)
+
+// OrderActor identifies who is changing the status of an order
+type OrderActor struct {
+	UserID          uint // Zero for the system
+	IsAdmin         bool // May make any allowed move on any order
+	IsDeliveryAgent bool // May take any order out for delivery and hand it over
+}
+
+// SystemActor is used by background jobs that change order statuses
+var SystemActor = OrderActor{}
+
+// orderTransition lists the statuses an order can move from into a target
+// status and the roles allowed to make that move. Admins and the system may
+// make any allowed move, and may also move orders from the staffFrom statuses,
+// for example to cancel an order the seller packed but never dispatched.
+type orderTransition struct {
+	from      []string
+	staffFrom []string
+	roles     []string
+}
+
+var orderTransitions = map[string]orderTransition{
+	models.OrderStatusConfirmed: {
+		from:  []string{models.OrderStatusPending},
+		roles: []string{OrderRoleSeller},
+	},
+	models.OrderStatusRejected: {
+		from:  []string{models.OrderStatusPending},
+		roles: []string{OrderRoleSeller},
+	},
+	models.OrderStatusPacked: {
+		from:  []string{models.OrderStatusConfirmed},
+		roles: []string{OrderRoleSeller},
+	},
+	models.OrderStatusOutForDelivery: {
+		from:  []string{models.OrderStatusPacked},
+		roles: []string{OrderRoleSeller, OrderRoleDelivery},
+	},
+	models.OrderStatusDelivered: {
+		from:  []string{models.OrderStatusOutForDelivery},
+		roles: []string{OrderRoleBuyer, OrderRoleDelivery},
+	},
+	models.OrderStatusCancelled: {
+		from:      []string{models.OrderStatusPending, models.OrderStatusConfirmed},
+		staffFrom: []string{models.OrderStatusPacked},
+		roles:     []string{OrderRoleBuyer},
+	},
+	models.OrderStatusRefunded: {
+		from:  []string{models.OrderStatusDelivered},
+		roles: []string{},
+	},
+}
+
+// TransitionOrder moves an order to a new status if the transition is allowed
+// for the actor, and records it in the order's event history. It must be
+// called inside a transaction; the order row is locked for the duration.
+func TransitionOrder(tx *gorm.DB, orderID uint, to string, actor OrderActor, note string) (models.Order, error) {
+	var order models.Order
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, orderID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return order, ErrOrderNotFound
+		}
+		return order, err
+	}
+
+	role := actorRole(order, actor)
+	staff := role == OrderRoleAdmin || role == OrderRoleSystem
+	transition, ok := orderTransitions[to]
+	if !ok || !containsString(transition.from, order.Status) && !(staff && containsString(transition.staffFrom, order.Status)) {
+		return order, ErrInvalidTransition
+	}
+	if !staff && !containsString(transition.roles, role) {
+		return order, ErrTransitionForbidden
+	}
+
+	from := order.Status
+	order.Status = to
+	if err := tx.Model(&order).Update("status", to).Error; err != nil {
+		return order, err
+	}
+
+	event := models.OrderEvent{
+		OrderID:    order.ID,
+		FromStatus: from,
+		ToStatus:   to,
+		ActorRole:  role,
+		Note:       note,
+	}
+	if actor.UserID != 0 {
+		event.ActorID = &actor.UserID
+	}
+	if err := tx.Create(&event).Error; err != nil {
+		return order, err
+	}
+
+	if err := applyStockTransition(tx, order, from, to); err != nil {
+		return order, err
+	}
+
+	if to == models.OrderStatusOutForDelivery {
+		if err := recordDispatch(tx, order); err != nil {
+			return order, err
+		}
+	}
+
+	if err := applyPaymentTransition(tx, order, to); err != nil {
+		return order, err
+	}
+
+	return order, nil
+}
+
+// actorRole returns the role in which the actor acts on the order. Being the
+// buyer or seller of the order takes precedence over being an admin.
+func actorRole(order models.Order, actor OrderActor) string {
+	switch {
+	case actor.UserID == 0:
+		return OrderRoleSystem
+	case actor.UserID == order.SellerID:
+		return OrderRoleSeller
+	case actor.UserID == order.BuyerID:
+		return OrderRoleBuyer
+	case actor.IsAdmin:
+		return OrderRoleAdmin
+	case actor.IsDeliveryAgent:
+		return OrderRoleDelivery
+	}
+	return ""
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,252 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"farmers_market_backend/config"
+	"farmers_market_backend/models"
+	"fmt"
+	"log"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrInvalidPhoneNumber is returned for a number that cannot be written in E.164 format
+	ErrInvalidPhoneNumber = errors.New("invalid phone number")
+	// ErrInvalidOTP is returned for a wrong, expired or already used code
+	ErrInvalidOTP = errors.New("invalid or expired code")
+	// ErrOTPAttemptsExceeded is returned once too many wrong codes were entered; a new code must be requested
+	ErrOTPAttemptsExceeded = errors.New("too many wrong codes")
+)
+
+// OTPRateLimitError is returned when a number has been sent too many codes
+type OTPRateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e OTPRateLimitError) Error() string {
+	return fmt.Sprintf("too many codes requested, retry after %s", e.RetryAfter)
+}
+
+// otpCodeLength is the number of digits of a one-time code
+const otpCodeLength = 6
+
+// otpLimit reads a positive whole number setting of one-time codes
+func otpLimit(key string, fallback int) int {
+	value, err := strconv.Atoi(config.GetEnv(key, strconv.Itoa(fallback)))
+	if err != nil || value < 1 {
+		log.Printf("Warning: invalid %s, using %d", key, fallback)
+		return fallback
+	}
+	return value
+}
+
+// NormalizePhoneNumber writes a mobile number in E.164 format. Numbers
+// starting with + or 00 are taken as international; others are national
+// numbers of the country, whose trunk prefix is dropped and calling code
+// added. Spaces, dashes, dots and brackets are ignored.
+func NormalizePhoneNumber(number string, country *models.Country) (string, error) {
+	number = strings.TrimSpace(number)
+	international := false
+	switch {
+	case strings.HasPrefix(number, "+"):
+		number, international = number[1:], true
+	case strings.HasPrefix(number, "00"):
+		number, international = number[2:], true
+	}
+
+	var digits strings.Builder
+	for _, r := range number {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case strings.ContainsRune(" -.()", r):
+		default:
+			return "", ErrInvalidPhoneNumber
+		}
+	}
+
+	e164 := digits.String()
+	if !international {
+		if country == nil || country.CallingCode == "" {
+			return "", ErrInvalidPhoneNumber
+		}
+		if country.TrunkPrefix != "" {
+			e164 = strings.TrimPrefix(e164, country.TrunkPrefix)
+		}
+		e164 = country.CallingCode + e164
+	}
+
+	// E.164 numbers have at most 15 digits and never start with 0
+	if len(e164) < 8 || len(e164) > 15 || e164[0] == '0' {
+		return "", ErrInvalidPhoneNumber
+	}
+	return "+" + e164, nil
+}
+
+// hashOTP returns the stored hash of a code sent to a number
+func hashOTP(phoneNumber, code string) string {
+	return hashToken(phoneNumber + ":" + code)
+}
+
+// generateOTP returns a random numeric code
+func generateOTP() (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < otpCodeLength; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", otpCodeLength, n), nil
+}
+
+// RequestOTP sends a one-time code to a number in E.164 format, valid for
+// OTP_TTL. A number is sent at most OTP_MAX_PER_WINDOW codes per
+// OTP_RATE_WINDOW and one per OTP_RESEND_INTERVAL; beyond that an
+// OTPRateLimitError is returned. Earlier codes stop working.
+func RequestOTP(db *gorm.DB, phoneNumber string) error {
+	now := time.Now()
+	window := config.GetEnvDuration("OTP_RATE_WINDOW", time.Hour)
+	resendInterval := config.GetEnvDuration("OTP_RESEND_INTERVAL", time.Minute)
+	maxPerWindow := otpLimit("OTP_MAX_PER_WINDOW", 5)
+	ttl := config.GetEnvDuration("OTP_TTL", 5*time.Minute)
+
+	code, err := generateOTP()
+	if err != nil {
+		return err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// Locking the codes already sent locks nothing for a new number, so
+		// requests lock a row of their own for the number first
+		lock := models.PhoneOTPLock{PhoneNumber: phoneNumber}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&lock).Error; err != nil {
+			return err
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&lock, "phone_number = ?", phoneNumber).Error; err != nil {
+			return err
+		}
+
+		var recent []models.PhoneOTP
+		if err := tx.Where("phone_number = ? AND created_at > ?", phoneNumber, now.Add(-window)).
+			Order("created_at").Find(&recent).Error; err != nil {
+			return err
+		}
+		if len(recent) > 0 {
+			if wait := recent[len(recent)-1].CreatedAt.Add(resendInterval).Sub(now); wait > 0 {
+				return OTPRateLimitError{RetryAfter: wait}
+			}
+		}
+		if len(recent) >= maxPerWindow {
+			return OTPRateLimitError{RetryAfter: recent[len(recent)-maxPerWindow].CreatedAt.Add(window).Sub(now)}
+		}
+
+		if err := tx.Model(&models.PhoneOTP{}).Where("phone_number = ? AND consumed_at IS NULL", phoneNumber).
+			Update("consumed_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.PhoneOTP{
+			PhoneNumber: phoneNumber,
+			CodeHash:    hashOTP(phoneNumber, code),
+			ExpiresAt:   now.Add(ttl),
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("Your Farmers Market code is %s. It expires in %s. Do not share it with anyone.", code, describeDuration(ttl))
+	return smsProvider.Send(phoneNumber, message)
+}
+
+// VerifyOTP checks a code sent to a number and returns the user with that
+// mobile number, signing them up if there is none. created reports whether
+// the user is new. After OTP_MAX_ATTEMPTS wrong codes the code stops working
+// and ErrOTPAttemptsExceeded is returned.
+func VerifyOTP(db *gorm.DB, phoneNumber, code, name string) (user models.User, created bool, err error) {
+	maxAttempts := otpLimit("OTP_MAX_ATTEMPTS", 5)
+	wrongCode, exhausted := false, false
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		var otp models.PhoneOTP
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("phone_number = ? AND consumed_at IS NULL AND expires_at > ?", phoneNumber, now).
+			Order("created_at DESC").First(&otp).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvalidOTP
+			}
+			return err
+		}
+		if otp.Attempts >= maxAttempts {
+			return ErrOTPAttemptsExceeded
+		}
+
+		// Wrong codes are counted, so the transaction commits before reporting them
+		if subtle.ConstantTimeCompare([]byte(otp.CodeHash), []byte(hashOTP(phoneNumber, code))) != 1 {
+			wrongCode, exhausted = true, otp.Attempts+1 >= maxAttempts
+			return tx.Model(&otp).Update("attempts", gorm.Expr("attempts + 1")).Error
+		}
+		if err := tx.Model(&otp).Update("consumed_at", now).Error; err != nil {
+			return err
+		}
+
+		err := tx.Preload("Roles").Where("mobile_number = ?", phoneNumber).Order("id").First(&user).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			username, err := availableUsername(tx, "u"+strings.TrimPrefix(phoneNumber, "+"))
+			if err != nil {
+				return err
+			}
+			user = models.User{
+				Name:             name,
+				Username:         username,
+				MobileNumber:     phoneNumber,
+				MobileVerifiedAt: &now,
+			}
+			created = true
+			return tx.Create(&user).Error
+		case err != nil:
+			return err
+		case user.MobileVerifiedAt == nil:
+			user.MobileVerifiedAt = &now
+			return tx.Model(&user).Update("mobile_verified_at", now).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return models.User{}, false, err
+	}
+	if wrongCode {
+		if exhausted {
+			return models.User{}, false, ErrOTPAttemptsExceeded
+		}
+		return models.User{}, false, ErrInvalidOTP
+	}
+	return user, created, nil
+}
+
+// availableUsername returns base, or base with the first free numeric suffix
+// if a user already has that username
+func availableUsername(tx *gorm.DB, base string) (string, error) {
+	username := base
+	for suffix := 2; ; suffix++ {
+		var taken int64
+		if err := tx.Model(&models.User{}).Unscoped().Where("username = ?", username).Count(&taken).Error; err != nil {
+			return "", err
+		}
+		if taken == 0 {
+			return username, nil
+		}
+		username = fmt.Sprintf("%s-%d", base, suffix)
+	}
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"errors"
+	"regexp"
+	"sync"
+	"testing"
+
+	"farmers_market_backend/models"
+	"farmers_market_backend/testutil"
+)
+
+// useFakeSMS sends codes through a fake provider for the rest of the test
+func useFakeSMS(t *testing.T) *FakeSMSProvider {
+	t.Helper()
+	previous := smsProvider
+	provider := NewFakeSMSProvider("")
+	SetSMSProvider(provider)
+	t.Cleanup(func() { SetSMSProvider(previous) })
+	return provider
+}
+
+// sentOTP returns the code in the last text message sent by the fake provider
+func sentOTP(t *testing.T, provider *FakeSMSProvider) string {
+	t.Helper()
+	sent := provider.Sent()
+	if len(sent) == 0 {
+		t.Fatal("no code was sent")
+	}
+	code := regexp.MustCompile(`\d{6}`).FindString(sent[len(sent)-1].Message)
+	if code == "" {
+		t.Fatalf("no code in %q", sent[len(sent)-1].Message)
+	}
+	return code
+}
+
+// TestRequestOTPConcurrently requests codes for a number that was never sent
+// one from many transactions at once and checks only one gets past the resend
+// interval. The requests only contend for the lock on MySQL or PostgreSQL; see
+// testutil.OpenDB.
+func TestRequestOTPConcurrently(t *testing.T) {
+	db := testutil.OpenDB(t)
+	useFakeSMS(t)
+	const requests = 5
+
+	results := make(chan error, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- RequestOTP(db, "+8801712345678")
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	sent, limited := 0, 0
+	for err := range results {
+		var rateLimit OTPRateLimitError
+		switch {
+		case err == nil:
+			sent++
+		case errors.As(err, &rateLimit):
+			limited++
+		default:
+			t.Errorf("request code: %v", err)
+		}
+	}
+	if sent != 1 || limited != requests-1 {
+		t.Errorf("%d codes sent and %d requests limited, want 1 and %d", sent, limited, requests-1)
+	}
+}
+
+func TestVerifyOTPSuffixesTakenUsername(t *testing.T) {
+	db := testutil.OpenDB(t)
+	provider := useFakeSMS(t)
+	const phoneNumber = "+8801712345678"
+
+	// Someone already uses the username a sign up with the number would get
+	testutil.CreateUser(t, db, "u8801712345678")
+
+	if err := RequestOTP(db, phoneNumber); err != nil {
+		t.Fatalf("request code: %v", err)
+	}
+	user, created, err := VerifyOTP(db, phoneNumber, sentOTP(t, provider), "Rahim")
+	if err != nil {
+		t.Fatalf("verify code: %v", err)
+	}
+	if !created || user.Username != "u8801712345678-2" || user.MobileNumber != phoneNumber {
+		t.Errorf("user = %q with %q (created %v), want new user u8801712345678-2", user.Username, user.MobileNumber, created)
+	}
+
+	var users int64
+	if err := db.Model(&models.User{}).Count(&users).Error; err != nil || users != 2 {
+		t.Errorf("%d users (%v), want 2", users, err)
+	}
+}
@@ -1,22 +1,270 @@
 package services
 
 import (
-	"farmers_market_backend/config"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"farmers_market_backend/models"
+	"fmt"
+	"log"
+	"sync"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-func TopUpWallet(userID uint, amount float64) error {
-	var wallet models.Wallet
-	if err := config.DB.Where("user_id = ?", userID).First(&wallet).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			wallet = models.Wallet{UserID: userID, Balance: amount}
-			return config.DB.Create(&wallet).Error
+// Payment statuses reported by a payment gateway
+const (
+	PaymentStatusSucceeded = "succeeded"
+	PaymentStatusFailed    = "failed"
+)
+
+var (
+	// ErrInvalidSignature is returned when a gateway callback is not signed by the gateway
+	ErrInvalidSignature = errors.New("invalid payment callback signature")
+	// ErrTopUpNotFound is returned when a callback refers to an unknown top-up
+	ErrTopUpNotFound = errors.New("top-up not found")
+	// ErrPaymentMismatch is returned when the paid amount differs from the top-up amount
+	ErrPaymentMismatch = errors.New("paid amount does not match top-up")
+	// ErrNoPaymentGateway is returned when no payment gateway is configured
+	ErrNoPaymentGateway = errors.New("no payment gateway configured")
+)
+
+// PaymentIntent is a payment created at a gateway that the user still has to complete
+type PaymentIntent struct {
+	GatewayReference string
+	CheckoutURL      string
+}
+
+// PaymentEvent is the verified outcome of a payment reported by a gateway
+type PaymentEvent struct {
+	GatewayReference string       `json:"gateway_reference"`
+	Status           string       `json:"status"` // One of the PaymentStatus constants
+	Amount           models.Money `json:"amount"`
+	FailureReason    string       `json:"failure_reason,omitempty"`
+}
+
+// PaymentGateway takes payments from users on behalf of the platform
+type PaymentGateway interface {
+	// Name identifies the gateway in stored top-ups
+	Name() string
+	// CreateIntent starts a payment of amount identified by our reference
+	CreateIntent(reference string, amount models.Money) (PaymentIntent, error)
+	// ParseCallback verifies the signature of a callback sent by the gateway and returns its event
+	ParseCallback(payload []byte, signature string) (PaymentEvent, error)
+	// VerifyPayment asks the gateway for the current state of a payment
+	VerifyPayment(gatewayReference string) (PaymentEvent, error)
+}
+
+// MockGateway is a PaymentGateway for local development and tests. Payments
+// are completed with SimulatePayment, which produces a callback signed with
+// the gateway secret exactly like a real gateway would send.
+type MockGateway struct {
+	secret   []byte
+	mu       sync.Mutex
+	payments map[string]PaymentEvent
+}
+
+// NewMockGateway creates a mock gateway signing callbacks with secret
+func NewMockGateway(secret string) *MockGateway {
+	return &MockGateway{secret: []byte(secret), payments: map[string]PaymentEvent{}}
+}
+
+// Name returns "mock"
+func (g *MockGateway) Name() string {
+	return "mock"
+}
+
+// CreateIntent records a pending payment
+func (g *MockGateway) CreateIntent(reference string, amount models.Money) (PaymentIntent, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	gatewayReference := "mock_" + reference
+	g.payments[gatewayReference] = PaymentEvent{GatewayReference: gatewayReference, Amount: amount}
+	return PaymentIntent{
+		GatewayReference: gatewayReference,
+		CheckoutURL:      "/api/payments/mock/" + gatewayReference + "/complete",
+	}, nil
+}
+
+// ParseCallback checks the HMAC-SHA256 signature of the payload
+func (g *MockGateway) ParseCallback(payload []byte, signature string) (PaymentEvent, error) {
+	var event PaymentEvent
+	if !hmac.Equal([]byte(g.sign(payload)), []byte(signature)) {
+		return event, ErrInvalidSignature
+	}
+	err := json.Unmarshal(payload, &event)
+	return event, err
+}
+
+// VerifyPayment returns the recorded state of a payment
+func (g *MockGateway) VerifyPayment(gatewayReference string) (PaymentEvent, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	event, ok := g.payments[gatewayReference]
+	if !ok {
+		return event, ErrTopUpNotFound
+	}
+	return event, nil
+}
+
+// SimulatePayment completes a pending payment with the given status and
+// returns the signed callback the gateway would send
+func (g *MockGateway) SimulatePayment(gatewayReference string, status string) ([]byte, string, error) {
+	g.mu.Lock()
+	event, ok := g.payments[gatewayReference]
+	if ok {
+		event.Status = status
+		if status == PaymentStatusFailed {
+			event.FailureReason = "payment declined by mock gateway"
 		}
-		return err
+		g.payments[gatewayReference] = event
 	}
+	g.mu.Unlock()
 
-	wallet.Balance += amount
-	return config.DB.Save(&wallet).Error
+	if !ok {
+		return nil, "", ErrTopUpNotFound
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, "", err
+	}
+	return payload, g.sign(payload), nil
+}
+
+func (g *MockGateway) sign(payload []byte) string {
+	mac := hmac.New(sha256.New, g.secret)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// paymentGateway is the gateway used for wallet top-ups, nil until one is
+// configured with SetPaymentGateway
+var paymentGateway PaymentGateway
+
+// SetPaymentGateway replaces the gateway used for wallet top-ups
+func SetPaymentGateway(gateway PaymentGateway) {
+	paymentGateway = gateway
+}
+
+// CurrentPaymentGateway returns the gateway used for wallet top-ups
+func CurrentPaymentGateway() PaymentGateway {
+	return paymentGateway
+}
+
+// StartTopUp records a pending top-up of the user's wallet and creates a
+// payment intent for it at the gateway. The wallet is not credited yet.
+func StartTopUp(db *gorm.DB, userID uint, amount models.Money) (models.TopUp, error) {
+	if paymentGateway == nil {
+		return models.TopUp{}, ErrNoPaymentGateway
+	}
+	topUp := models.TopUp{UserID: userID, Amount: amount, Status: models.TopUpStatusPending, Gateway: paymentGateway.Name()}
+	if err := db.Create(&topUp).Error; err != nil {
+		return topUp, err
+	}
+
+	intent, err := paymentGateway.CreateIntent(fmt.Sprintf("topup_%d", topUp.ID), amount)
+	if err != nil {
+		db.Model(&topUp).Updates(map[string]interface{}{"status": models.TopUpStatusFailed, "failure_reason": err.Error()})
+		return topUp, err
+	}
+
+	topUp.GatewayReference = &intent.GatewayReference
+	topUp.CheckoutURL = intent.CheckoutURL
+	err = db.Model(&topUp).Updates(map[string]interface{}{
+		"gateway_reference": intent.GatewayReference,
+		"checkout_url":      intent.CheckoutURL,
+	}).Error
+	return topUp, err
+}
+
+// HandleTopUpCallback verifies a gateway callback and settles the top-up it
+// refers to. Callbacks may be delivered more than once; a top-up is only
+// credited to the wallet the first time it succeeds.
+func HandleTopUpCallback(db *gorm.DB, payload []byte, signature string) (models.TopUp, error) {
+	var topUp models.TopUp
+	if paymentGateway == nil {
+		return topUp, ErrNoPaymentGateway
+	}
+
+	event, err := paymentGateway.ParseCallback(payload, signature)
+	if err != nil {
+		return topUp, err
+	}
+
+	// Confirm the reported outcome with the gateway before moving any money
+	verified, err := paymentGateway.VerifyPayment(event.GatewayReference)
+	if err != nil {
+		return topUp, err
+	}
+	if verified.Status != event.Status || verified.Amount != event.Amount {
+		return topUp, ErrPaymentMismatch
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("gateway_reference = ?", event.GatewayReference).First(&topUp).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrTopUpNotFound
+			}
+			return err
+		}
+
+		// Late callbacks for abandoned top-ups are still honoured, the user has paid
+		if topUp.Status != models.TopUpStatusPending && topUp.Status != models.TopUpStatusAbandoned {
+			return nil
+		}
+
+		now := time.Now()
+		topUp.CompletedAt = &now
+		switch event.Status {
+		case PaymentStatusSucceeded:
+			if event.Amount != topUp.Amount {
+				return ErrPaymentMismatch
+			}
+			wallet, err := GetOrCreateWallet(tx, topUp.UserID)
+			if err != nil {
+				return err
+			}
+			if _, err := PostJournal(tx, fmt.Sprintf("topup:%d", topUp.ID), "Wallet top-up", nil,
+				Posting{Account: models.LedgerAccountTopUps, Debit: topUp.Amount},
+				CreditWallet(wallet, topUp.Amount),
+			); err != nil {
+				return err
+			}
+			topUp.Status = models.TopUpStatusSucceeded
+		case PaymentStatusFailed:
+			topUp.Status = models.TopUpStatusFailed
+			topUp.FailureReason = event.FailureReason
+		default:
+			return nil
+		}
+		return tx.Save(&topUp).Error
+	})
+	return topUp, err
+}
+
+// AbandonStaleTopUps marks top-ups still pending after the given duration as abandoned
+func AbandonStaleTopUps(db *gorm.DB, after time.Duration) error {
+	return db.Model(&models.TopUp{}).
+		Where("status = ? AND created_at < ?", models.TopUpStatusPending, time.Now().Add(-after)).
+		Update("status", models.TopUpStatusAbandoned).Error
+}
+
+// StartTopUpSweeper periodically marks stale top-ups as abandoned in the background
+func StartTopUpSweeper(db *gorm.DB, after, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := AbandonStaleTopUps(db, after); err != nil {
+				log.Printf("Failed to abandon stale top-ups: %v", err)
+			}
+		}
+	}()
 }
@@ -0,0 +1,285 @@
+package services
+
+import (
+	"errors"
+	"farmers_market_backend/models"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrWithdrawalNotFound is returned when a withdrawal request does not exist
+	ErrWithdrawalNotFound = errors.New("withdrawal request not found")
+	// ErrWithdrawalNotPending is returned when reviewing a request that was already reviewed
+	ErrWithdrawalNotPending = errors.New("withdrawal request is not pending")
+)
+
+// PayoutRequest describes a payout to be made by a PayoutProvider
+type PayoutRequest struct {
+	Reference       string // Unique reference of the payout on our side
+	Amount          models.Money
+	DestinationType string
+	AccountName     string
+	AccountNumber   string
+	BankName        string
+}
+
+// PayoutResult is the outcome of a payout
+type PayoutResult struct {
+	ProviderReference string
+	Succeeded         bool
+	FailureReason     string
+}
+
+// ErrPayoutNotFound is returned by PayoutProvider.VerifyPayout when the
+// provider never received a payout with the reference
+var ErrPayoutNotFound = errors.New("payout not found")
+
+// PayoutProvider sends money from the platform to a bank or mobile money
+// account. The request reference is an idempotency key: sending a payout
+// again with the same reference must not pay twice. Payout returns an error
+// when the outcome is unknown, such as on a timeout, and a result with
+// Succeeded false only when the provider definitely did not pay.
+type PayoutProvider interface {
+	Payout(request PayoutRequest) (PayoutResult, error)
+	// VerifyPayout asks for the outcome of an earlier payout, returning
+	// ErrPayoutNotFound if the provider has no payout with the reference
+	VerifyPayout(reference string) (PayoutResult, error)
+}
+
+// FakePayoutProvider is a PayoutProvider for local development that does not
+// move any money. Payouts to account numbers starting with "FAIL" fail, so the
+// failure path can be exercised.
+type FakePayoutProvider struct {
+	mu      sync.Mutex
+	payouts map[string]PayoutResult
+}
+
+// NewFakePayoutProvider creates a fake payout provider
+func NewFakePayoutProvider() *FakePayoutProvider {
+	return &FakePayoutProvider{payouts: map[string]PayoutResult{}}
+}
+
+// Payout logs the payout and reports it as succeeded. A payout sent again
+// with the same reference returns the first result.
+func (p *FakePayoutProvider) Payout(request PayoutRequest) (PayoutResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if result, ok := p.payouts[request.Reference]; ok {
+		return result, nil
+	}
+
+	log.Printf("Fake payout %s: %s %s to %s account %s", request.Reference, request.Amount, request.Amount.Currency, request.DestinationType, request.AccountNumber)
+	result := PayoutResult{ProviderReference: "fake-" + request.Reference, Succeeded: true}
+	if strings.HasPrefix(strings.ToUpper(request.AccountNumber), "FAIL") {
+		result = PayoutResult{Succeeded: false, FailureReason: "account rejected by fake provider"}
+	}
+	p.payouts[request.Reference] = result
+	return result, nil
+}
+
+// VerifyPayout returns the result of an earlier payout
+func (p *FakePayoutProvider) VerifyPayout(reference string) (PayoutResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	result, ok := p.payouts[reference]
+	if !ok {
+		return result, ErrPayoutNotFound
+	}
+	return result, nil
+}
+
+// payoutProvider is the provider used to process approved withdrawals
+var payoutProvider PayoutProvider = NewFakePayoutProvider()
+
+// SetPayoutProvider replaces the provider used to process approved withdrawals
+func SetPayoutProvider(provider PayoutProvider) {
+	payoutProvider = provider
+}
+
+// RequestWithdrawal moves amount from the user's wallet into pending
+// withdrawals and records a withdrawal request for admin review
+func RequestWithdrawal(db *gorm.DB, request models.WithdrawalRequest) (models.WithdrawalRequest, error) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		wallet, err := GetOrCreateWallet(tx, request.UserID)
+		if err != nil {
+			return err
+		}
+		if request.Amount.Currency != wallet.Balance.Currency {
+			return models.ErrCurrencyMismatch
+		}
+		if wallet.Balance.Cmp(request.Amount) < 0 {
+			return ErrInsufficientFunds
+		}
+
+		request.WalletID = wallet.ID
+		request.Status = models.WithdrawalStatusPending
+		if err := tx.Create(&request).Error; err != nil {
+			return err
+		}
+
+		_, err = PostJournal(tx, withdrawalReference(request.ID), fmt.Sprintf("Withdrawal request %d", request.ID), nil,
+			DebitWallet(wallet, request.Amount),
+			Posting{Account: models.LedgerAccountPendingWithdrawals, Credit: request.Amount},
+		)
+		return err
+	})
+	return request, err
+}
+
+// ApproveWithdrawal sends a pending withdrawal to the payout provider and
+// finalises or rolls back its ledger entries depending on the result. If the
+// outcome is unknown the request stays processing until
+// ReconcileWithdrawals learns it from the provider.
+func ApproveWithdrawal(db *gorm.DB, withdrawalID, adminID uint) (models.WithdrawalRequest, error) {
+	request, err := reviewWithdrawal(db, withdrawalID, adminID, models.WithdrawalStatusProcessing, "")
+	if err != nil {
+		return request, err
+	}
+
+	// The provider is called outside of a transaction so no rows stay locked
+	// while waiting for it
+	result, err := payoutProvider.Payout(payoutRequest(request))
+	if err != nil {
+		log.Printf("Outcome of payout of withdrawal %d unknown, it will be reconciled: %v", request.ID, err)
+		return request, nil
+	}
+	return settleWithdrawal(db, request.ID, result)
+}
+
+// payoutRequest describes the payout of a withdrawal request
+func payoutRequest(request models.WithdrawalRequest) PayoutRequest {
+	return PayoutRequest{
+		Reference:       withdrawalReference(request.ID),
+		Amount:          request.Amount,
+		DestinationType: request.DestinationType,
+		AccountName:     request.AccountName,
+		AccountNumber:   request.AccountNumber,
+		BankName:        request.BankName,
+	}
+}
+
+// settleWithdrawal completes a processing withdrawal whose payout succeeded,
+// or returns its funds to the wallet if the provider definitely did not pay.
+// A request that is no longer processing was settled already and is left as is.
+func settleWithdrawal(db *gorm.DB, withdrawalID uint, result PayoutResult) (models.WithdrawalRequest, error) {
+	var request models.WithdrawalRequest
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&request, withdrawalID).Error; err != nil {
+			return err
+		}
+		if request.Status != models.WithdrawalStatusProcessing {
+			return nil
+		}
+
+		if result.Succeeded {
+			request.Status = models.WithdrawalStatusCompleted
+			request.ProviderReference = result.ProviderReference
+			if _, err := PostJournal(tx, withdrawalReference(request.ID)+":payout", fmt.Sprintf("Payout of withdrawal %d", request.ID), nil,
+				Posting{Account: models.LedgerAccountPendingWithdrawals, Debit: request.Amount},
+				Posting{Account: models.LedgerAccountPayouts, Credit: request.Amount},
+			); err != nil {
+				return err
+			}
+		} else {
+			request.Status = models.WithdrawalStatusFailed
+			request.FailureReason = result.FailureReason
+			if err := ReverseJournal(tx, withdrawalReference(request.ID), withdrawalReference(request.ID)+":reversal",
+				fmt.Sprintf("Failed payout of withdrawal %d", request.ID)); err != nil {
+				return err
+			}
+		}
+		return tx.Save(&request).Error
+	})
+	return request, err
+}
+
+// ReconcileWithdrawals settles withdrawals left processing for longer than
+// after, such as when the provider timed out or the server stopped before
+// the result was saved. Each payout's outcome is asked from the provider; a
+// payout the provider never received is sent again under the same reference.
+func ReconcileWithdrawals(db *gorm.DB, after time.Duration) error {
+	var requests []models.WithdrawalRequest
+	if err := db.Where("status = ? AND updated_at < ?", models.WithdrawalStatusProcessing, time.Now().Add(-after)).
+		Find(&requests).Error; err != nil {
+		return err
+	}
+
+	for _, request := range requests {
+		result, err := payoutProvider.VerifyPayout(withdrawalReference(request.ID))
+		if errors.Is(err, ErrPayoutNotFound) {
+			result, err = payoutProvider.Payout(payoutRequest(request))
+		}
+		if err != nil {
+			log.Printf("Outcome of payout of withdrawal %d still unknown: %v", request.ID, err)
+			continue
+		}
+		if _, err := settleWithdrawal(db, request.ID, result); err != nil {
+			log.Printf("Failed to settle withdrawal %d: %v", request.ID, err)
+		}
+	}
+	return nil
+}
+
+// StartPayoutReconciler periodically reconciles withdrawals whose payout
+// outcome is unknown
+func StartPayoutReconciler(db *gorm.DB, after, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := ReconcileWithdrawals(db, after); err != nil {
+				log.Printf("Failed to reconcile withdrawals: %v", err)
+			}
+		}
+	}()
+}
+
+// RejectWithdrawal rejects a pending withdrawal and returns its funds to the wallet
+func RejectWithdrawal(db *gorm.DB, withdrawalID, adminID uint, reason string) (models.WithdrawalRequest, error) {
+	var request models.WithdrawalRequest
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		request, err = reviewWithdrawal(tx, withdrawalID, adminID, models.WithdrawalStatusRejected, reason)
+		if err != nil {
+			return err
+		}
+		return ReverseJournal(tx, withdrawalReference(request.ID), withdrawalReference(request.ID)+":reversal",
+			fmt.Sprintf("Rejected withdrawal %d", request.ID))
+	})
+	return request, err
+}
+
+// reviewWithdrawal moves a pending withdrawal request to status on behalf of an admin
+func reviewWithdrawal(db *gorm.DB, withdrawalID, adminID uint, status, reason string) (models.WithdrawalRequest, error) {
+	var request models.WithdrawalRequest
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&request, withdrawalID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrWithdrawalNotFound
+			}
+			return err
+		}
+		if request.Status != models.WithdrawalStatusPending {
+			return ErrWithdrawalNotPending
+		}
+
+		now := time.Now()
+		request.Status = status
+		request.FailureReason = reason
+		request.ReviewedByID = &adminID
+		request.ReviewedAt = &now
+		return tx.Save(&request).Error
+	})
+	return request, err
+}
+
+func withdrawalReference(withdrawalID uint) string {
+	return fmt.Sprintf("withdrawal:%d", withdrawalID)
+}
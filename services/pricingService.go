@@ -0,0 +1,145 @@
+package services
+
+import (
+	"farmers_market_backend/config"
+	"farmers_market_backend/models"
+	"log"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+// PricedLine is an order line priced by the pricing engine
+type PricedLine struct {
+	Product      models.Product
+	Variant      models.ProductVariant
+	Quantity     float64      // Quantity in the unit of the variant
+	ListPrice    models.Money // Variant price per unit before discounts
+	Discount     float64      // Promotional discount percentage, when the promotion set the price
+	PriceRule    string       // Price rule that set the unit price, one of the models.PriceRule constants
+	UnitPrice    models.Money // Price per unit after discounts
+	LineDiscount models.Money // Discount over the whole line
+	LineTotal    models.Money // UnitPrice * Quantity
+}
+
+// PriceBreakdown is the itemised price of a single order
+type PriceBreakdown struct {
+	Lines          []PricedLine
+	Subtotal       models.Money // Sum of list prices times quantities
+	DiscountTotal  models.Money // Sum of product discounts
+	CouponDiscount models.Money // Share of the coupon discount applied to this order
+	DeliveryFee    models.Money // Fee for delivering the order
+	TaxTotal       models.Money // Tax on the goods after all discounts, delivery is not taxed
+	Total          models.Money // Subtotal - DiscountTotal - CouponDiscount + DeliveryFee + TaxTotal
+}
+
+// BuyerPrices holds the buyer group price list entries available to a buyer, by variant ID
+type BuyerPrices map[uint][]models.BuyerGroupPrice
+
+// LoadBuyerPrices loads the price list entries of all buyer groups the buyer
+// belongs to for the given product variants
+func LoadBuyerPrices(db *gorm.DB, buyerID uint, variantIDs []uint) (BuyerPrices, error) {
+	prices := BuyerPrices{}
+	if buyerID == 0 || len(variantIDs) == 0 {
+		return prices, nil
+	}
+
+	var entries []models.BuyerGroupPrice
+	if err := db.Joins("JOIN buyer_group_members ON buyer_group_members.buyer_group_id = buyer_group_prices.buyer_group_id").
+		Where("buyer_group_members.user_id = ? AND buyer_group_prices.variant_id IN ?", buyerID, variantIDs).
+		Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		prices[entry.VariantID] = append(prices[entry.VariantID], entry)
+	}
+	return prices, nil
+}
+
+// PriceLine prices quantity units of a product variant for a buyer. The
+// variant's PriceTiers must be loaded. Every price rule the line qualifies for
+// is considered and the lowest unit price wins; discounts never stack:
+//   - the list price of the variant
+//   - the list price less the product's discount percentage, while the
+//     product is on promotional sale, rounded to the minor unit per unit
+//   - the quantity price tier reached by the quantity
+//   - the buyer group price list entries reached by the quantity
+func PriceLine(product models.Product, variant models.ProductVariant, quantity float64, buyerPrices BuyerPrices) PricedLine {
+	line := PricedLine{
+		Product:   product,
+		Variant:   variant,
+		Quantity:  quantity,
+		ListPrice: variant.Price,
+		UnitPrice: variant.Price,
+		PriceRule: models.PriceRuleList,
+	}
+
+	if product.IsPromoSale && product.Discount > 0 {
+		promoPrice := variant.Price.Sub(variant.Price.Percent(product.Discount))
+		if promoPrice.Cmp(line.UnitPrice) < 0 {
+			line.UnitPrice, line.PriceRule, line.Discount = promoPrice, models.PriceRulePromo, product.Discount
+		}
+	}
+
+	var tierPrice *models.Money
+	tierQuantity := 0.0
+	for i, tier := range variant.PriceTiers {
+		if quantity >= tier.MinQuantity && tier.MinQuantity >= tierQuantity {
+			tierPrice, tierQuantity = &variant.PriceTiers[i].Price, tier.MinQuantity
+		}
+	}
+	if tierPrice != nil && tierPrice.Cmp(line.UnitPrice) < 0 {
+		line.UnitPrice, line.PriceRule, line.Discount = *tierPrice, models.PriceRuleTier, 0
+	}
+
+	for _, entry := range buyerPrices[variant.ID] {
+		if quantity >= entry.MinQuantity && entry.Price.Cmp(line.UnitPrice) < 0 {
+			line.UnitPrice, line.PriceRule, line.Discount = entry.Price, models.PriceRuleBuyerGroup, 0
+		}
+	}
+
+	line.LineTotal = line.UnitPrice.MulQuantity(quantity)
+	line.LineDiscount = line.ListPrice.MulQuantity(quantity).Sub(line.LineTotal)
+	return line
+}
+
+// QuoteUnitPrice returns the unit price a buyer currently pays for quantity
+// units of a product variant
+func QuoteUnitPrice(db *gorm.DB, buyerID uint, product models.Product, variant models.ProductVariant, quantity float64) (models.Money, error) {
+	if err := db.Model(&variant).Association("PriceTiers").Find(&variant.PriceTiers); err != nil {
+		return models.Money{}, err
+	}
+	buyerPrices, err := LoadBuyerPrices(db, buyerID, []uint{variant.ID})
+	if err != nil {
+		return models.Money{}, err
+	}
+	return PriceLine(product, variant, quantity, buyerPrices).UnitPrice, nil
+}
+
+// PriceOrder adds up the priced lines of one order and applies the coupon
+// discount, the delivery fee quoted for the order and tax
+func PriceOrder(lines []PricedLine, couponDiscount, deliveryFee models.Money) PriceBreakdown {
+	zero := models.NewMoney(0, models.DefaultCurrency)
+	breakdown := PriceBreakdown{Lines: lines, Subtotal: zero, DiscountTotal: zero, CouponDiscount: zero.Add(couponDiscount)}
+
+	for _, line := range lines {
+		breakdown.Subtotal = breakdown.Subtotal.Add(line.ListPrice.MulQuantity(line.Quantity))
+		breakdown.DiscountTotal = breakdown.DiscountTotal.Add(line.LineDiscount)
+	}
+
+	goods := breakdown.Subtotal.Sub(breakdown.DiscountTotal).Sub(breakdown.CouponDiscount)
+	breakdown.DeliveryFee = zero.Add(deliveryFee)
+	breakdown.TaxTotal = goods.Percent(taxRate())
+	breakdown.Total = goods.Add(breakdown.DeliveryFee).Add(breakdown.TaxTotal)
+	return breakdown
+}
+
+// taxRate returns the tax percentage charged on goods
+func taxRate() float64 {
+	rate, err := strconv.ParseFloat(config.GetEnv("TAX_RATE", "0"), 64)
+	if err != nil || rate < 0 {
+		log.Printf("Warning: invalid TAX_RATE, using 0")
+		return 0
+	}
+	return rate
+}
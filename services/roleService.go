@@ -0,0 +1,67 @@
+package services
+
+import (
+	"errors"
+	"farmers_market_backend/models"
+
+	"gorm.io/gorm"
+)
+
+// ErrUnknownRole is returned when assigning a role that does not exist
+var ErrUnknownRole = errors.New("unknown role")
+
+// ValidRole reports whether role is one of the models.Roles
+func ValidRole(role string) bool {
+	return containsString(models.Roles, role)
+}
+
+// SetUserRoles replaces the roles of a user. The admin and seller roles set
+// IsAdmin and IsSeller, the others are stored as UserRole rows. Every user
+// stays a buyer whether or not it is listed. The user is returned with the
+// new roles loaded.
+func SetUserRoles(db *gorm.DB, userID uint, roles []string) (models.User, error) {
+	var user models.User
+	for _, role := range roles {
+		if !ValidRole(role) {
+			return user, ErrUnknownRole
+		}
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&user, userID).Error; err != nil {
+			return err
+		}
+		user.IsAdmin = containsString(roles, models.RoleAdmin)
+		user.IsSeller = containsString(roles, models.RoleSeller)
+		if err := tx.Model(&user).Updates(map[string]interface{}{"is_admin": user.IsAdmin, "is_seller": user.IsSeller}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("user_id = ?", userID).Delete(&models.UserRole{}).Error; err != nil {
+			return err
+		}
+		user.Roles = nil
+		for _, role := range roles {
+			if role == models.RoleAdmin || role == models.RoleSeller || role == models.RoleBuyer || containsRole(user.Roles, role) {
+				continue
+			}
+			assigned := models.UserRole{UserID: userID, Role: role}
+			if err := tx.Create(&assigned).Error; err != nil {
+				return err
+			}
+			user.Roles = append(user.Roles, assigned)
+		}
+		return nil
+	})
+	return user, err
+}
+
+// containsRole reports whether role is among the assigned roles
+func containsRole(assigned []models.UserRole, role string) bool {
+	for _, r := range assigned {
+		if r.Role == role {
+			return true
+		}
+	}
+	return false
+}
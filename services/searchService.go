@@ -0,0 +1,303 @@
+package services
+
+import (
+	"farmers_market_backend/models"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Product search sort orders
+const (
+	SortRelevance    = "relevance"     // Best keyword match first, newest without keywords
+	SortPriceAsc     = "price_asc"     // Cheapest variant first
+	SortPriceDesc    = "price_desc"    // Most expensive cheapest variant first
+	SortRating       = "rating"        // Highest rated first
+	SortNewest       = "newest"        // Most recently listed first
+	SortDeliveryTime = "delivery_time" // Fastest delivery first
+)
+
+// SearchSorts lists the valid sort orders
+var SearchSorts = []string{SortRelevance, SortPriceAsc, SortPriceDesc, SortRating, SortNewest, SortDeliveryTime}
+
+// Search facets, each named after the filter it counts
+const (
+	facetCategory = "category"
+	facetDistrict = "district"
+	facetPrice    = "price"
+	facetPromo    = "promo"
+	facetInStock  = "in_stock"
+	facetRating   = "rating"
+)
+
+// ProductSearch holds the keywords, filters and sort order of a product search
+type ProductSearch struct {
+	Query       string        // Keywords matched against name, description, category and seller
+	CategoryIDs []uint        // Any of these categories
+	DistrictIDs []uint        // Sold by a seller in any of these districts
+	MinPrice    *models.Money // A variant costs at least this much
+	MaxPrice    *models.Money // A variant costs at most this much
+	PromoOnly   bool          // Only products on promotional sale
+	InStock     bool          // Only products with a variant that can be ordered
+	MinRating   float64       // Minimum product rating
+	Sort        string        // One of the Sort constants, SortRelevance by default
+	Limit       int
+	Offset      int
+}
+
+// FacetCount is the number of matching products for one value of a filter
+type FacetCount struct {
+	Value uint   `json:"value"`
+	Label string `json:"label"`
+	Count int64  `json:"count"`
+}
+
+// PriceRange is the lowest and highest variant price of the matching products
+type PriceRange struct {
+	Min *models.Money `json:"min"`
+	Max *models.Money `json:"max"`
+}
+
+// SearchFacets counts the matching products per filter value. Each facet is
+// counted with all other filters applied but not its own, so the counts show
+// what selecting another value would return.
+type SearchFacets struct {
+	Categories []FacetCount `json:"categories"`
+	Districts  []FacetCount `json:"districts"`
+	Ratings    []FacetCount `json:"ratings"` // Products rated at least Value
+	Price      PriceRange   `json:"price"`
+	Promo      int64        `json:"promo"`
+	InStock    int64        `json:"in_stock"`
+}
+
+// SearchResult is a page of matching products with the total and facets
+type SearchResult struct {
+	Products []models.Product `json:"products"`
+	Total    int64            `json:"total"`
+	Facets   SearchFacets     `json:"facets"`
+}
+
+// searchTerms splits keywords into words, dropping the operators of the
+// database's full-text query syntax
+func searchTerms(query string) []string {
+	return strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// fullTextMatch returns the index-backed condition matching products that
+// contain every term as a word prefix: a FULLTEXT index on MySQL and a GIN
+// tsvector index on PostgreSQL
+func fullTextMatch(db *gorm.DB, terms []string) clause.Expr {
+	if db.Dialector.Name() == "postgres" {
+		query := strings.Join(terms, ":* & ") + ":*"
+		return gorm.Expr("to_tsvector('simple', products.search_text) @@ to_tsquery('simple', ?)", query)
+	}
+	query := "+" + strings.Join(terms, "* +") + "*"
+	return gorm.Expr("MATCH(products.search_text) AGAINST (? IN BOOLEAN MODE)", query)
+}
+
+// relevanceOrder orders products by how well they match the terms
+func relevanceOrder(db *gorm.DB, terms []string) clause.OrderBy {
+	if db.Dialector.Name() == "postgres" {
+		query := strings.Join(terms, ":* & ") + ":*"
+		return clause.OrderBy{Expression: gorm.Expr("ts_rank(to_tsvector('simple', products.search_text), to_tsquery('simple', ?)) DESC, products.id DESC", query)}
+	}
+	query := "+" + strings.Join(terms, "* +") + "*"
+	return clause.OrderBy{Expression: gorm.Expr("MATCH(products.search_text) AGAINST (? IN BOOLEAN MODE) DESC, products.id DESC", query)}
+}
+
+// filterProducts selects the in-season products matching the search, without
+// the filter of the facet being counted
+func filterProducts(db *gorm.DB, search ProductSearch, terms []string, skipFacet string) *gorm.DB {
+	query := db.Table("products").
+		Joins("JOIN users sellers ON sellers.id = products.seller_id").
+		Joins("LEFT JOIN (SELECT product_id, MIN(price_minor) AS min_price, MAX(price_minor) AS max_price FROM product_variants WHERE deleted_at IS NULL GROUP BY product_id) variant_prices ON variant_prices.product_id = products.id").
+		Scopes(ListedProducts)
+
+	if len(terms) > 0 {
+		query = query.Where(fullTextMatch(db, terms))
+	}
+	if len(search.CategoryIDs) > 0 && skipFacet != facetCategory {
+		query = query.Where("products.category_id IN ?", search.CategoryIDs)
+	}
+	if len(search.DistrictIDs) > 0 && skipFacet != facetDistrict {
+		query = query.Where("sellers.district_id IN ?", search.DistrictIDs)
+	}
+	if (search.MinPrice != nil || search.MaxPrice != nil) && skipFacet != facetPrice {
+		variants := db.Table("product_variants").Select("1").
+			Where("product_variants.product_id = products.id AND product_variants.deleted_at IS NULL")
+		if search.MinPrice != nil {
+			variants = variants.Where("product_variants.price_minor >= ?", search.MinPrice.Minor)
+		}
+		if search.MaxPrice != nil {
+			variants = variants.Where("product_variants.price_minor <= ?", search.MaxPrice.Minor)
+		}
+		query = query.Where("EXISTS (?)", variants)
+	}
+	if search.PromoOnly && skipFacet != facetPromo {
+		query = query.Where("products.is_promo_sale = ?", true)
+	}
+	if search.InStock && skipFacet != facetInStock {
+		query = query.Where("EXISTS (?)", inStockVariants(db))
+	}
+	if search.MinRating > 0 && skipFacet != facetRating {
+		query = query.Where("products.rating >= ?", search.MinRating)
+	}
+	return query
+}
+
+// inStockVariants selects the variants of a product that can still be ordered
+func inStockVariants(db *gorm.DB) *gorm.DB {
+	return db.Table("product_variants").Select("1").
+		Where("product_variants.product_id = products.id AND product_variants.deleted_at IS NULL AND product_variants.stock - product_variants.reserved_stock > 0")
+}
+
+// SearchProducts finds in-season products by keywords and filters and counts
+// the facets of the search
+func SearchProducts(db *gorm.DB, search ProductSearch) (SearchResult, error) {
+	terms := searchTerms(search.Query)
+	result := SearchResult{Products: []models.Product{}}
+
+	if err := filterProducts(db, search, terms, "").Count(&result.Total).Error; err != nil {
+		return result, err
+	}
+
+	query := filterProducts(db, search, terms, "")
+	switch search.Sort {
+	case SortPriceAsc:
+		query = query.Order("variant_prices.min_price ASC, products.id DESC")
+	case SortPriceDesc:
+		query = query.Order("variant_prices.min_price DESC, products.id DESC")
+	case SortRating:
+		query = query.Order("products.rating DESC, products.id DESC")
+	case SortDeliveryTime:
+		query = query.Order("products.delivery_time ASC, products.id DESC")
+	case SortNewest:
+		query = query.Order("products.created_at DESC, products.id DESC")
+	default:
+		if len(terms) > 0 {
+			query = query.Clauses(relevanceOrder(db, terms))
+		} else {
+			query = query.Order("products.created_at DESC, products.id DESC")
+		}
+	}
+
+	var ids []uint
+	if err := query.Limit(search.Limit).Offset(search.Offset).Pluck("products.id", &ids).Error; err != nil {
+		return result, err
+	}
+	if len(ids) > 0 {
+		var products []models.Product
+		if err := db.Preload("Category").Preload("Seller").
+			Preload("Variants", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+			Preload("Variants.UnitOfMeasure").
+			Where("id IN ?", ids).Find(&products).Error; err != nil {
+			return result, err
+		}
+		byID := make(map[uint]models.Product, len(products))
+		for _, product := range products {
+			byID[product.ID] = product
+		}
+		for _, id := range ids {
+			result.Products = append(result.Products, byID[id])
+		}
+	}
+
+	facets, err := searchFacets(db, search, terms)
+	if err != nil {
+		return result, err
+	}
+	result.Facets = facets
+	return result, nil
+}
+
+// searchFacets counts the matching products for each filter
+func searchFacets(db *gorm.DB, search ProductSearch, terms []string) (SearchFacets, error) {
+	facets := SearchFacets{Categories: []FacetCount{}, Districts: []FacetCount{}, Ratings: []FacetCount{}}
+
+	if err := filterProducts(db, search, terms, facetCategory).
+		Joins("JOIN categories ON categories.id = products.category_id").
+		Select("products.category_id AS value, categories.name AS label, COUNT(*) AS count").
+		Group("products.category_id, categories.name").Order("count DESC, label").
+		Scan(&facets.Categories).Error; err != nil {
+		return facets, err
+	}
+
+	if err := filterProducts(db, search, terms, facetDistrict).
+		Joins("JOIN districts ON districts.id = sellers.district_id").
+		Select("sellers.district_id AS value, districts.name AS label, COUNT(*) AS count").
+		Group("sellers.district_id, districts.name").Order("count DESC, label").
+		Scan(&facets.Districts).Error; err != nil {
+		return facets, err
+	}
+
+	for _, rating := range []uint{4, 3, 2, 1} {
+		facet := FacetCount{Value: rating, Label: fmt.Sprintf("%d stars & up", rating)}
+		if err := filterProducts(db, search, terms, facetRating).
+			Where("products.rating >= ?", rating).Count(&facet.Count).Error; err != nil {
+			return facets, err
+		}
+		facets.Ratings = append(facets.Ratings, facet)
+	}
+
+	var prices struct {
+		MinPrice *int64
+		MaxPrice *int64
+	}
+	if err := filterProducts(db, search, terms, facetPrice).
+		Select("MIN(variant_prices.min_price) AS min_price, MAX(variant_prices.max_price) AS max_price").
+		Scan(&prices).Error; err != nil {
+		return facets, err
+	}
+	if prices.MinPrice != nil && prices.MaxPrice != nil {
+		min := models.NewMoney(*prices.MinPrice, models.DefaultCurrency)
+		max := models.NewMoney(*prices.MaxPrice, models.DefaultCurrency)
+		facets.Price = PriceRange{Min: &min, Max: &max}
+	}
+
+	if err := filterProducts(db, search, terms, facetPromo).
+		Where("products.is_promo_sale = ?", true).Count(&facets.Promo).Error; err != nil {
+		return facets, err
+	}
+	if err := filterProducts(db, search, terms, facetInStock).
+		Where("EXISTS (?)", inStockVariants(db)).Count(&facets.InStock).Error; err != nil {
+		return facets, err
+	}
+	return facets, nil
+}
+
+// productSearchText is the text a product is found by
+func productSearchText(product models.Product) string {
+	parts := []string{product.Name, product.Description, product.Category.Name, product.Seller.FarmName, product.Seller.Name}
+	text := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part = strings.TrimSpace(part); part != "" {
+			text = append(text, part)
+		}
+	}
+	return strings.Join(text, " ")
+}
+
+// RefreshSearchText rebuilds the indexed search text of the products matching
+// the conditions, after a product, its category or its seller changed
+func RefreshSearchText(db *gorm.DB, query interface{}, args ...interface{}) error {
+	var products []models.Product
+	if err := db.Preload("Category").Preload("Seller").Where(query, args...).Find(&products).Error; err != nil {
+		return err
+	}
+	for _, product := range products {
+		text := productSearchText(product)
+		if text == product.SearchText {
+			continue
+		}
+		// UpdateColumn keeps updated_at, the product itself did not change
+		if err := db.Model(&models.Product{}).Where("id = ?", product.ID).UpdateColumn("search_text", text).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"farmers_market_backend/models"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// InSeason reports whether a product's season has not ended at time t
+func InSeason(product models.Product, t time.Time) bool {
+	return product.SeasonExpiryDate == nil || product.SeasonExpiryDate.After(t)
+}
+
+// ListedProducts is a scope selecting the products shown to buyers: listed
+// and not past their season, even if the scheduler has not delisted them yet
+func ListedProducts(db *gorm.DB) *gorm.DB {
+	return db.Where("products.delisted_at IS NULL AND (products.season_expiry_date IS NULL OR products.season_expiry_date > ?)", time.Now())
+}
+
+// nextAnniversary moves date forward by whole years until it is after t
+func nextAnniversary(date, t time.Time) time.Time {
+	for !date.After(t) {
+		date = date.AddDate(1, 0, 0)
+	}
+	return date
+}
+
+// DelistProduct hides a product whose season has ended. When it has a season
+// start date in the past, the start date moves to its next anniversary so the
+// product is listed again next season.
+func DelistProduct(tx *gorm.DB, product *models.Product, now time.Time) error {
+	product.DelistedAt = &now
+	if product.SeasonStartDate != nil && !product.SeasonStartDate.After(now) {
+		start := nextAnniversary(*product.SeasonStartDate, now)
+		product.SeasonStartDate = &start
+	}
+	if err := tx.Model(product).Updates(map[string]interface{}{
+		"delisted_at":       product.DelistedAt,
+		"season_start_date": product.SeasonStartDate,
+	}).Error; err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("%s is out of season and no longer shown to buyers.", product.Name)
+	if product.SeasonStartDate != nil {
+		body += fmt.Sprintf(" It will be listed again on %s.", product.SeasonStartDate.Format("2 January 2006"))
+	}
+	return Notify(tx, product.SellerID, models.NotificationDelisted, "Product delisted", body, &product.ID)
+}
+
+// RelistProduct shows an out-of-season product again and notifies the buyers
+// who asked to be told when it is back in season. A season expiry date in
+// the past moves to its next anniversary.
+func RelistProduct(tx *gorm.DB, product *models.Product, now time.Time) error {
+	product.DelistedAt = nil
+	product.SeasonNoticeAt = nil
+	if product.SeasonExpiryDate != nil && !product.SeasonExpiryDate.After(now) {
+		expiry := nextAnniversary(*product.SeasonExpiryDate, now)
+		product.SeasonExpiryDate = &expiry
+	}
+	if err := tx.Model(product).Updates(map[string]interface{}{
+		"delisted_at":        nil,
+		"season_notice_at":   nil,
+		"season_expiry_date": product.SeasonExpiryDate,
+	}).Error; err != nil {
+		return err
+	}
+
+	var subscriptions []models.SeasonSubscription
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("product_id = ?", product.ID).Find(&subscriptions).Error; err != nil {
+		return err
+	}
+	body := fmt.Sprintf("%s is back in season and can be ordered again.", product.Name)
+	for _, subscription := range subscriptions {
+		if err := Notify(tx, subscription.UserID, models.NotificationBackInSeason, "Back in season", body, &product.ID); err != nil {
+			return err
+		}
+	}
+	return tx.Where("product_id = ?", product.ID).Delete(&models.SeasonSubscription{}).Error
+}
+
+// RunSeasonSchedule delists products whose season has ended, relists
+// products whose next season has started and warns sellers whose products go
+// out of season within notice
+func RunSeasonSchedule(db *gorm.DB, notice time.Duration) error {
+	now := time.Now()
+
+	var ending []models.Product
+	if err := db.Where("delisted_at IS NULL AND season_expiry_date <= ?", now).Find(&ending).Error; err != nil {
+		return err
+	}
+	for i := range ending {
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return DelistProduct(tx, &ending[i], now)
+		}); err != nil {
+			log.Printf("Failed to delist product %d: %v", ending[i].ID, err)
+		}
+	}
+
+	var starting []models.Product
+	if err := db.Where("delisted_at IS NOT NULL AND season_start_date <= ?", now).Find(&starting).Error; err != nil {
+		return err
+	}
+	for i := range starting {
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return RelistProduct(tx, &starting[i], now)
+		}); err != nil {
+			log.Printf("Failed to relist product %d: %v", starting[i].ID, err)
+		}
+	}
+
+	var endingSoon []models.Product
+	if err := db.Where("delisted_at IS NULL AND season_notice_at IS NULL AND season_expiry_date > ? AND season_expiry_date <= ?", now, now.Add(notice)).
+		Find(&endingSoon).Error; err != nil {
+		return err
+	}
+	for _, product := range endingSoon {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&product).Update("season_notice_at", now).Error; err != nil {
+				return err
+			}
+			body := fmt.Sprintf("%s goes out of season on %s and will then be hidden from buyers.",
+				product.Name, product.SeasonExpiryDate.Format("2 January 2006"))
+			return Notify(tx, product.SellerID, models.NotificationSeasonEnding, "Season ending soon", body, &product.ID)
+		})
+		if err != nil {
+			log.Printf("Failed to send season notice for product %d: %v", product.ID, err)
+		}
+	}
+	return nil
+}
+
+// StartSeasonScheduler runs the season schedule at startup and then
+// periodically in the background
+func StartSeasonScheduler(db *gorm.DB, notice, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := RunSeasonSchedule(db, notice); err != nil {
+				log.Printf("Failed to run season schedule: %v", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"farmers_market_backend/models"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrRefreshTokenReused is returned when a refresh token that was already
+// exchanged is presented again. Its session has been revoked.
+var ErrRefreshTokenReused = errors.New("refresh token reused")
+
+// ErrSessionNotFound is returned when a user has no active session with an ID
+var ErrSessionNotFound = errors.New("session not found")
+
+// TokenPair is an access token and the refresh token to renew it with
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	SessionID    uint
+}
+
+// hashToken returns the hex SHA-256 of a token, as stored in the database
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueTokenPair signs a new access and refresh token for the session and
+// stores the refresh token
+func issueTokenPair(tx *gorm.DB, user models.User, session *models.Session) (TokenPair, error) {
+	accessToken, _, err := IssueAccessToken(user, session.ID)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	refreshToken, claims, err := IssueRefreshToken(user, session.ID)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	stored := models.RefreshToken{SessionID: session.ID, TokenHash: hashToken(refreshToken), ExpiresAt: claims.ExpiresAt.Time}
+	if err := tx.Create(&stored).Error; err != nil {
+		return TokenPair{}, err
+	}
+	session.ExpiresAt = stored.ExpiresAt
+	if err := tx.Model(session).Updates(map[string]interface{}{
+		"expires_at":   session.ExpiresAt,
+		"last_used_at": session.LastUsedAt,
+		"ip_address":   session.IPAddress,
+	}).Error; err != nil {
+		return TokenPair{}, err
+	}
+	return TokenPair{AccessToken: accessToken, RefreshToken: refreshToken, SessionID: session.ID}, nil
+}
+
+// StartSession logs the user in on a device and returns the session's first
+// token pair
+func StartSession(db *gorm.DB, user models.User, device, ipAddress string) (TokenPair, error) {
+	var pair TokenPair
+	err := db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		session := models.Session{UserID: user.ID, Device: device, IPAddress: ipAddress, LastUsedAt: now, ExpiresAt: now}
+		if err := tx.Create(&session).Error; err != nil {
+			return err
+		}
+		var err error
+		pair, err = issueTokenPair(tx, user, &session)
+		return err
+	})
+	return pair, err
+}
+
+// RotateRefreshToken exchanges a refresh token for a new token pair of the
+// same session. Each refresh token can be exchanged once; presenting it again
+// revokes the session, since either the client or someone who stole the
+// token already used it, and returns ErrRefreshTokenReused.
+func RotateRefreshToken(db *gorm.DB, refreshToken, ipAddress string) (TokenPair, error) {
+	claims, err := ParseToken(refreshToken, TokenTypeRefresh)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	var pair TokenPair
+	reused := false
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var stored models.RefreshToken
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", hashToken(refreshToken)).First(&stored).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvalidToken
+			}
+			return err
+		}
+
+		var session models.Session
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&session, stored.SessionID).Error; err != nil {
+			return err
+		}
+		if session.ID != claims.SessionID || session.RevokedAt != nil {
+			return ErrInvalidToken
+		}
+
+		now := time.Now()
+		if stored.UsedAt != nil {
+			reused = true
+			return revokeSession(tx, &session, models.SessionRevokedReuse, now)
+		}
+		if err := tx.Model(&stored).Update("used_at", now).Error; err != nil {
+			return err
+		}
+
+		var user models.User
+		if err := tx.Preload("Roles").First(&user, session.UserID).Error; err != nil {
+			return err
+		}
+		session.LastUsedAt, session.IPAddress = now, ipAddress
+		pair, err = issueTokenPair(tx, user, &session)
+		return err
+	})
+	if err != nil {
+		return TokenPair{}, err
+	}
+	if reused {
+		log.Printf("Refresh token of session %d was reused, session revoked", claims.SessionID)
+		return TokenPair{}, ErrRefreshTokenReused
+	}
+	return pair, nil
+}
+
+// revokeSession marks a session revoked so neither its refresh tokens nor its
+// access tokens are accepted any more
+func revokeSession(tx *gorm.DB, session *models.Session, reason string, now time.Time) error {
+	session.RevokedAt, session.RevokedReason = &now, reason
+	return tx.Model(session).Updates(map[string]interface{}{"revoked_at": now, "revoked_reason": reason}).Error
+}
+
+// RevokeSession revokes an active session of the user
+func RevokeSession(db *gorm.DB, userID, sessionID uint, reason string) error {
+	var session models.Session
+	if err := db.Where("user_id = ? AND revoked_at IS NULL", userID).First(&session, sessionID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrSessionNotFound
+		}
+		return err
+	}
+	return revokeSession(db, &session, reason, time.Now())
+}
+
+// RevokeAllSessions revokes every active session of the user, logging the
+// user out on all devices
+func RevokeAllSessions(db *gorm.DB, userID uint, reason string) error {
+	return db.Model(&models.Session{}).Where("user_id = ? AND revoked_at IS NULL", userID).
+		Updates(map[string]interface{}{"revoked_at": time.Now(), "revoked_reason": reason}).Error
+}
+
+// SessionActive reports whether the session of an access token has not been
+// revoked
+func SessionActive(db *gorm.DB, sessionID uint) (bool, error) {
+	var count int64
+	err := db.Model(&models.Session{}).Where("id = ? AND revoked_at IS NULL", sessionID).Count(&count).Error
+	return count > 0, err
+}
+
+// PurgeExpiredSessions deletes sessions whose refresh token expired before
+// cutoff, together with their refresh tokens
+func PurgeExpiredSessions(db *gorm.DB, cutoff time.Time) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		expired := tx.Model(&models.Session{}).Select("id").Where("expires_at < ?", cutoff)
+		if err := tx.Where("session_id IN (?)", expired).Delete(&models.RefreshToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("expires_at < ?", cutoff).Delete(&models.Session{}).Error
+	})
+}
+
+// StartSessionSweeper periodically deletes sessions that expired more than
+// retention ago
+func StartSessionSweeper(db *gorm.DB, retention, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := PurgeExpiredSessions(db, time.Now().Add(-retention)); err != nil {
+				log.Printf("Failed to purge expired sessions: %v", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// SMSProvider sends text messages to mobile numbers in E.164 format
+type SMSProvider interface {
+	Send(to, message string) error
+}
+
+// SMS is a text message sent through the FakeSMSProvider
+type SMS struct {
+	To      string
+	Message string
+}
+
+// FakeSMSProvider is an SMSProvider for local development and tests. It logs
+// each message, keeps it in memory and, if Path is set, appends it to that
+// file so codes can be read back by hand.
+type FakeSMSProvider struct {
+	Path string
+	mu   sync.Mutex
+	sent []SMS
+}
+
+// NewFakeSMSProvider creates a fake provider appending messages to path, or
+// only logging them if path is empty
+func NewFakeSMSProvider(path string) *FakeSMSProvider {
+	return &FakeSMSProvider{Path: path}
+}
+
+// Send records the message
+func (p *FakeSMSProvider) Send(to, message string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sent = append(p.sent, SMS{To: to, Message: message})
+	log.Printf("SMS to %s: %s", to, message)
+
+	if p.Path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(p.Path), 0o755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(p.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = fmt.Fprintf(file, "%s %s %s\n", time.Now().Format(time.RFC3339), to, message)
+	return err
+}
+
+// Sent returns the messages sent so far, oldest first
+func (p *FakeSMSProvider) Sent() []SMS {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]SMS(nil), p.sent...)
+}
+
+// smsProvider is the provider used to send one-time codes
+var smsProvider SMSProvider = NewFakeSMSProvider("")
+
+// SetSMSProvider replaces the provider used to send one-time codes
+func SetSMSProvider(provider SMSProvider) {
+	smsProvider = provider
+}
+
+// CurrentSMSProvider returns the provider used to send one-time codes
+func CurrentSMSProvider() SMSProvider {
+	return smsProvider
+}
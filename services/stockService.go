@@ -0,0 +1,140 @@
+package services
+
+import (
+	"errors"
+	"farmers_market_backend/models"
+	"log"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrInsufficientStock is returned when a variant has less unreserved stock than requested
+var ErrInsufficientStock = errors.New("insufficient stock")
+
+// ReserveStock holds quantity units of a product variant for a pending order.
+// The variant row must already be locked by the caller's transaction; the
+// update is additionally guarded so stock can never be reserved twice.
+func ReserveStock(tx *gorm.DB, variant models.ProductVariant, quantity float64) error {
+	// Allow for float rounding noise in fractional stock
+	required := quantity - math.Pow10(-quantityPrecision)
+	if variant.Stock-variant.ReservedStock < required {
+		return ErrInsufficientStock
+	}
+
+	result := tx.Model(&models.ProductVariant{}).
+		Where("id = ? AND stock - reserved_stock >= ?", variant.ID, required).
+		Update("reserved_stock", gorm.Expr("reserved_stock + ?", quantity))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInsufficientStock
+	}
+	return nil
+}
+
+// releaseReservation returns quantity units reserved by a pending order to
+// the variant's available stock. The reservation never drops below zero.
+func releaseReservation(tx *gorm.DB, variantID uint, quantity float64) error {
+	return tx.Model(&models.ProductVariant{}).Where("id = ?", variantID).
+		Update("reserved_stock", gorm.Expr("CASE WHEN reserved_stock > ? THEN reserved_stock - ? ELSE 0 END", quantity, quantity)).Error
+}
+
+// applyStockTransition adjusts variant stock for an order moving between
+// statuses: confirming a pending order turns its reservation into a sale
+// fulfilled from the variant's lots, cancelling or rejecting a pending order
+// releases the reservation, and cancelling a confirmed or packed order puts
+// the sold stock back into its lots. Pending orders placed before reservations
+// existed hold none, so there is nothing to release for them.
+func applyStockTransition(tx *gorm.DB, order models.Order, from, to string) error {
+	var update func(item models.OrderItem) error
+	switch {
+	case from == models.OrderStatusPending && to == models.OrderStatusConfirmed:
+		update = func(item models.OrderItem) error {
+			if _, err := lockVariant(tx, item.VariantID); err != nil {
+				return err
+			}
+			if err := allocateLots(tx, item); err != nil {
+				return err
+			}
+			if order.StockReserved {
+				if err := releaseReservation(tx, item.VariantID, item.Quantity); err != nil {
+					return err
+				}
+			}
+			return syncVariantStock(tx, item.VariantID)
+		}
+	case from == models.OrderStatusPending && (to == models.OrderStatusCancelled || to == models.OrderStatusRejected):
+		if !order.StockReserved {
+			return nil
+		}
+		update = func(item models.OrderItem) error {
+			return releaseReservation(tx, item.VariantID, item.Quantity)
+		}
+	case (from == models.OrderStatusConfirmed || from == models.OrderStatusPacked) && to == models.OrderStatusCancelled:
+		update = func(item models.OrderItem) error {
+			if _, err := lockVariant(tx, item.VariantID); err != nil {
+				return err
+			}
+			if err := releaseLots(tx, item); err != nil {
+				return err
+			}
+			return syncVariantStock(tx, item.VariantID)
+		}
+	default:
+		return nil
+	}
+
+	var items []models.OrderItem
+	if err := tx.Where("order_id = ?", order.ID).Order("variant_id").Find(&items).Error; err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := update(item); err != nil {
+			return err
+		}
+	}
+	if from == models.OrderStatusPending && order.StockReserved {
+		return tx.Model(&order).Update("stock_reserved", false).Error
+	}
+	return nil
+}
+
+// ExpireReservations cancels pending orders that reserved stock more than ttl
+// ago so the stock becomes available again. Pending orders placed before
+// reservations existed are left for their seller to confirm or reject.
+func ExpireReservations(db *gorm.DB, ttl time.Duration) error {
+	var orderIDs []uint
+	if err := db.Model(&models.Order{}).
+		Where("status = ? AND stock_reserved = ? AND order_date_time < ?", models.OrderStatusPending, true, time.Now().Add(-ttl)).
+		Pluck("id", &orderIDs).Error; err != nil {
+		return err
+	}
+
+	for _, orderID := range orderIDs {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			_, err := TransitionOrder(tx, orderID, models.OrderStatusCancelled, SystemActor, "Stock reservation expired")
+			return err
+		})
+		// The order may have been confirmed or cancelled since it was selected
+		if err != nil && !errors.Is(err, ErrInvalidTransition) {
+			log.Printf("Failed to expire order %d: %v", orderID, err)
+		}
+	}
+	return nil
+}
+
+// StartReservationSweeper periodically expires stale stock reservations in the background
+func StartReservationSweeper(db *gorm.DB, ttl, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := ExpireReservations(db, ttl); err != nil {
+				log.Printf("Failed to expire stock reservations: %v", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,222 @@
+package services
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"farmers_market_backend/models"
+	"farmers_market_backend/testutil"
+
+	"gorm.io/gorm"
+)
+
+func reloadVariant(t *testing.T, db *gorm.DB, variantID uint) models.ProductVariant {
+	t.Helper()
+	var variant models.ProductVariant
+	if err := db.First(&variant, variantID).Error; err != nil {
+		t.Fatalf("load variant: %v", err)
+	}
+	return variant
+}
+
+// placeTestOrder places a cash on delivery order for quantity units of the variant
+func placeTestOrder(t *testing.T, db *gorm.DB, buyerID, variantID uint, quantity float64) models.Order {
+	t.Helper()
+	var orders []models.Order
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		orders, err = CreateOrders(tx, buyerID, []OrderLine{{VariantID: variantID, Quantity: quantity}},
+			CheckoutOptions{PaymentMethod: models.PaymentMethodCashOnDelivery})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("place order: %v", err)
+	}
+	return orders[0]
+}
+
+func transitionTestOrder(t *testing.T, db *gorm.DB, orderID uint, to string) {
+	t.Helper()
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		_, err := TransitionOrder(tx, orderID, to, SystemActor, "")
+		return err
+	}); err != nil {
+		t.Fatalf("transition order %d to %s: %v", orderID, to, err)
+	}
+}
+
+func TestReserveStock(t *testing.T) {
+	db := testutil.OpenDB(t)
+	variant := testutil.CreateVariant(t, db, testutil.CreateUser(t, db, "seller").ID, 5)
+
+	if err := ReserveStock(db, variant, 3); err != nil {
+		t.Fatalf("reserve 3 of 5: %v", err)
+	}
+	if got := reloadVariant(t, db, variant.ID).ReservedStock; got != 3 {
+		t.Errorf("reserved stock = %v, want 3", got)
+	}
+
+	// A stale copy of the variant still shows 5 available; the guarded update
+	// must not reserve stock that another order already holds
+	if err := ReserveStock(db, variant, 3); !errors.Is(err, ErrInsufficientStock) {
+		t.Errorf("reserve 3 more with a stale variant: got %v, want ErrInsufficientStock", err)
+	}
+
+	variant = reloadVariant(t, db, variant.ID)
+	if err := ReserveStock(db, variant, 3); !errors.Is(err, ErrInsufficientStock) {
+		t.Errorf("reserve 3 of the 2 left: got %v, want ErrInsufficientStock", err)
+	}
+	if err := ReserveStock(db, variant, 2); err != nil {
+		t.Fatalf("reserve the 2 left: %v", err)
+	}
+	if got := reloadVariant(t, db, variant.ID).ReservedStock; got != 5 {
+		t.Errorf("reserved stock = %v, want 5", got)
+	}
+}
+
+// TestReserveStockConcurrently reserves stock from many transactions at once,
+// each holding a copy of the variant read before any reservation was made, so
+// only the guarded update keeps them from reserving more than is in stock
+func TestReserveStockConcurrently(t *testing.T) {
+	db := testutil.OpenDB(t)
+	const stock, orders = 3, 10
+	stale := testutil.CreateVariant(t, db, testutil.CreateUser(t, db, "seller").ID, stock)
+
+	results := make(chan error, orders)
+	var wg sync.WaitGroup
+	for i := 0; i < orders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- db.Transaction(func(tx *gorm.DB) error { return ReserveStock(tx, stale, 1) })
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	reserved, refused := 0, 0
+	for err := range results {
+		switch {
+		case err == nil:
+			reserved++
+		case errors.Is(err, ErrInsufficientStock):
+			refused++
+		default:
+			t.Errorf("reserve: %v", err)
+		}
+	}
+	if reserved != stock || refused != orders-stock {
+		t.Errorf("%d reservations made and %d refused, want %d and %d", reserved, refused, stock, orders-stock)
+	}
+	if got := reloadVariant(t, db, stale.ID).ReservedStock; got != stock {
+		t.Errorf("reserved stock = %v, want %v", got, stock)
+	}
+}
+
+func TestApplyStockTransition(t *testing.T) {
+	tests := []struct {
+		name         string
+		transitions  []string
+		wantStock    float64
+		wantReserved float64
+		wantLot      float64
+	}{
+		{"pending", nil, 5, 2, 5},
+		{"cancelled while pending", []string{models.OrderStatusCancelled}, 5, 0, 5},
+		{"rejected", []string{models.OrderStatusRejected}, 5, 0, 5},
+		{"confirmed", []string{models.OrderStatusConfirmed}, 3, 0, 3},
+		{"cancelled after confirming", []string{models.OrderStatusConfirmed, models.OrderStatusCancelled}, 5, 0, 5},
+		{"cancelled after packing", []string{models.OrderStatusConfirmed, models.OrderStatusPacked, models.OrderStatusCancelled}, 5, 0, 5},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := testutil.OpenDB(t)
+			variant := testutil.CreateVariant(t, db, testutil.CreateUser(t, db, "seller").ID, 5)
+			buyer := testutil.CreateUser(t, db, "buyer")
+
+			order := placeTestOrder(t, db, buyer.ID, variant.ID, 2)
+			if !order.StockReserved {
+				t.Fatal("new order did not reserve stock")
+			}
+			for _, to := range test.transitions {
+				transitionTestOrder(t, db, order.ID, to)
+			}
+
+			variant = reloadVariant(t, db, variant.ID)
+			if variant.Stock != test.wantStock || variant.ReservedStock != test.wantReserved {
+				t.Errorf("stock = %v reserved %v, want %v reserved %v", variant.Stock, variant.ReservedStock, test.wantStock, test.wantReserved)
+			}
+			var remaining float64
+			if err := db.Model(&models.InventoryLot{}).Where("variant_id = ?", variant.ID).
+				Select("SUM(remaining)").Scan(&remaining).Error; err != nil {
+				t.Fatalf("sum lots: %v", err)
+			}
+			if remaining != test.wantLot {
+				t.Errorf("lots hold %v, want %v", remaining, test.wantLot)
+			}
+			if err := db.First(&order, order.ID).Error; err != nil {
+				t.Fatalf("load order: %v", err)
+			}
+			if len(test.transitions) > 0 && order.StockReserved {
+				t.Error("order still holds a reservation after leaving pending")
+			}
+		})
+	}
+}
+
+func TestApplyStockTransitionWithoutReservation(t *testing.T) {
+	db := testutil.OpenDB(t)
+	variant := testutil.CreateVariant(t, db, testutil.CreateUser(t, db, "seller").ID, 5)
+	buyer := testutil.CreateUser(t, db, "buyer")
+
+	// Another order holds 2 units while an order placed before reservations
+	// existed, which holds none, is cancelled
+	placeTestOrder(t, db, buyer.ID, variant.ID, 2)
+	legacy := placeTestOrder(t, db, buyer.ID, variant.ID, 1)
+	if err := db.Model(&models.ProductVariant{}).Where("id = ?", variant.ID).
+		Update("reserved_stock", 2).Error; err != nil {
+		t.Fatalf("drop reservation: %v", err)
+	}
+	if err := db.Model(&legacy).Update("stock_reserved", false).Error; err != nil {
+		t.Fatalf("mark order as legacy: %v", err)
+	}
+
+	transitionTestOrder(t, db, legacy.ID, models.OrderStatusCancelled)
+	if got := reloadVariant(t, db, variant.ID).ReservedStock; got != 2 {
+		t.Errorf("reserved stock = %v, want the other order's 2", got)
+	}
+}
+
+func TestReleaseReservationStopsAtZero(t *testing.T) {
+	db := testutil.OpenDB(t)
+	variant := testutil.CreateVariant(t, db, testutil.CreateUser(t, db, "seller").ID, 5)
+	if err := ReserveStock(db, variant, 1); err != nil {
+		t.Fatalf("reserve: %v", err)
+	}
+
+	if err := releaseReservation(db, variant.ID, 3); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	if got := reloadVariant(t, db, variant.ID).ReservedStock; got != 0 {
+		t.Errorf("reserved stock = %v, want 0", got)
+	}
+}
+
+func TestReleaseLotsRecordsReturn(t *testing.T) {
+	db := testutil.OpenDB(t)
+	variant := testutil.CreateVariant(t, db, testutil.CreateUser(t, db, "seller").ID, 5)
+	buyer := testutil.CreateUser(t, db, "buyer")
+
+	order := placeTestOrder(t, db, buyer.ID, variant.ID, 2)
+	transitionTestOrder(t, db, order.ID, models.OrderStatusConfirmed)
+	transitionTestOrder(t, db, order.ID, models.OrderStatusCancelled)
+
+	var events []models.LotEvent
+	if err := db.Where("type = ?", models.LotEventReturned).Find(&events).Error; err != nil {
+		t.Fatalf("load events: %v", err)
+	}
+	if want := "2 returned to stock"; len(events) != 1 || events[0].Note != want {
+		t.Errorf("return events = %+v, want one noting %q", events, want)
+	}
+}
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"farmers_market_backend/config"
+	"farmers_market_backend/models"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Token types. Each token is accepted only where its type is expected, so a
+// refresh token cannot be used as an access token.
+const (
+	TokenTypeAccess            = "access"
+	TokenTypeRefresh           = "refresh"
+	TokenTypeEmailVerification = "email_verification" // Sent by email to confirm the address
+	TokenTypePasswordReset     = "password_reset"     // Sent by email to set a new password
+)
+
+// sessionTokenType reports whether tokens of a type belong to a login session
+func sessionTokenType(tokenType string) bool {
+	return tokenType == TokenTypeAccess || tokenType == TokenTypeRefresh
+}
+
+// tokenSigningMethod is the only algorithm tokens are signed and accepted with
+var tokenSigningMethod = jwt.SigningMethodHS256
+
+// minKeyLength is the shortest secret accepted for HS256, 256 bits
+const minKeyLength = 32
+
+// ErrInvalidToken is returned for a token that is malformed, expired, signed
+// with an unknown key or of the wrong type
+var ErrInvalidToken = errors.New("invalid token")
+
+// TokenClaims are the claims of every token the API issues
+type TokenClaims struct {
+	Role      string `json:"role"`       // Role of the user when the token was issued, one of the models.Role constants
+	Type      string `json:"token_type"` // One of the TokenType constants
+	SessionID uint   `json:"sid"`        // Session the token belongs to, zero for email tokens
+	jwt.RegisteredClaims
+}
+
+// UserID returns the ID of the user the token was issued to
+func (c TokenClaims) UserID() (uint, error) {
+	id, err := strconv.ParseUint(c.Subject, 10, 64)
+	if err != nil || id == 0 {
+		return 0, ErrInvalidToken
+	}
+	return uint(id), nil
+}
+
+// tokenKeyRing holds the keys tokens are signed and verified with, by key ID
+type tokenKeyRing struct {
+	signingKeyID string
+	keys         map[string][]byte
+}
+
+var tokenKeys *tokenKeyRing
+
+// LoadTokenKeys reads the token keys from the environment. JWT_KEYS is a
+// comma-separated list of keyID:secret pairs; every key verifies tokens and
+// JWT_SIGNING_KEY_ID, the first key by default, signs new ones. To rotate,
+// add a new key, make it the signing key and remove the old key once the
+// tokens it signed have expired. Without JWT_KEYS, JWT_SECRET is the only key.
+func LoadTokenKeys() error {
+	ring := &tokenKeyRing{keys: map[string][]byte{}}
+
+	if value := strings.TrimSpace(config.GetEnv("JWT_KEYS", "")); value != "" {
+		for _, pair := range strings.Split(value, ",") {
+			id, secret, ok := strings.Cut(strings.TrimSpace(pair), ":")
+			if !ok || id == "" {
+				return fmt.Errorf("JWT_KEYS entries must be keyID:secret")
+			}
+			if _, exists := ring.keys[id]; exists {
+				return fmt.Errorf("JWT_KEYS has key ID %s more than once", id)
+			}
+			if len(secret) < minKeyLength {
+				return fmt.Errorf("JWT key %s must be at least %d bytes", id, minKeyLength)
+			}
+			ring.keys[id] = []byte(secret)
+			if ring.signingKeyID == "" {
+				ring.signingKeyID = id
+			}
+		}
+		ring.signingKeyID = config.GetEnv("JWT_SIGNING_KEY_ID", ring.signingKeyID)
+		if _, ok := ring.keys[ring.signingKeyID]; !ok {
+			return fmt.Errorf("JWT_SIGNING_KEY_ID %s is not in JWT_KEYS", ring.signingKeyID)
+		}
+	} else {
+		secret := config.GetEnv("JWT_SECRET", "")
+		if len(secret) < minKeyLength {
+			return fmt.Errorf("JWT_SECRET must be at least %d bytes", minKeyLength)
+		}
+		ring.signingKeyID = "default"
+		ring.keys[ring.signingKeyID] = []byte(secret)
+	}
+
+	tokenKeys = ring
+	return nil
+}
+
+// IssueToken signs a token of the given type for a session of the user,
+// valid for ttl. Email tokens have no session.
+func IssueToken(user models.User, tokenType string, sessionID uint, ttl time.Duration) (string, TokenClaims, error) {
+	if tokenKeys == nil {
+		return "", TokenClaims{}, fmt.Errorf("token keys are not loaded")
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", TokenClaims{}, err
+	}
+	now := time.Now()
+	claims := TokenClaims{
+		Role:      user.Role(),
+		Type:      tokenType,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.FormatUint(uint64(user.ID), 10),
+			ID:        hex.EncodeToString(id),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+
+	token := jwt.NewWithClaims(tokenSigningMethod, claims)
+	token.Header["kid"] = tokenKeys.signingKeyID
+	signed, err := token.SignedString(tokenKeys.keys[tokenKeys.signingKeyID])
+	if err != nil {
+		return "", TokenClaims{}, err
+	}
+	return signed, claims, nil
+}
+
+// IssueAccessToken signs an access token for a session of the user, valid
+// for JWT_ACCESS_TTL
+func IssueAccessToken(user models.User, sessionID uint) (string, TokenClaims, error) {
+	return IssueToken(user, TokenTypeAccess, sessionID, config.GetEnvDuration("JWT_ACCESS_TTL", 15*time.Minute))
+}
+
+// IssueRefreshToken signs a refresh token for a session of the user, valid
+// for JWT_REFRESH_TTL. Use StartSession and RotateRefreshToken, which also
+// store it, rather than calling it directly.
+func IssueRefreshToken(user models.User, sessionID uint) (string, TokenClaims, error) {
+	return IssueToken(user, TokenTypeRefresh, sessionID, config.GetEnvDuration("JWT_REFRESH_TTL", 7*24*time.Hour))
+}
+
+// ParseToken verifies a token's algorithm, key, expiry and type and returns
+// its claims. Any failure is reported as ErrInvalidToken.
+func ParseToken(tokenString, tokenType string) (TokenClaims, error) {
+	var claims TokenClaims
+	if tokenKeys == nil {
+		return claims, fmt.Errorf("token keys are not loaded")
+	}
+
+	token, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (interface{}, error) {
+		keyID, _ := token.Header["kid"].(string)
+		key, ok := tokenKeys.keys[keyID]
+		if !ok {
+			return nil, fmt.Errorf("unknown key ID %q", keyID)
+		}
+		return key, nil
+	}, jwt.WithValidMethods([]string{tokenSigningMethod.Alg()}))
+	if err != nil || !token.Valid {
+		return claims, ErrInvalidToken
+	}
+
+	if claims.Type != tokenType || (sessionTokenType(tokenType) && claims.SessionID == 0) || claims.ID == "" || claims.IssuedAt == nil || claims.ExpiresAt == nil {
+		return claims, ErrInvalidToken
+	}
+	if _, err := claims.UserID(); err != nil {
+		return claims, ErrInvalidToken
+	}
+	return claims, nil
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"errors"
+	"farmers_market_backend/models"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrLotNotFound is returned when no lot has the requested lot code
+	ErrLotNotFound = errors.New("lot not found")
+	// ErrInvalidLotEvent is returned when a seller records an event type they may not record
+	ErrInvalidLotEvent = errors.New("invalid lot event type")
+)
+
+// TraceReport is the public traceability page of a lot. It only holds what
+// buyers may see: the farm, but not the seller's account or contact details.
+type TraceReport struct {
+	LotCode        string             `json:"lot_code"`
+	Product        string             `json:"product"`
+	Variant        string             `json:"variant"`
+	Grade          string             `json:"grade,omitempty"`
+	Farm           TraceFarm          `json:"farm"`
+	HarvestDate    time.Time          `json:"harvest_date"`
+	BestBefore     *time.Time         `json:"best_before"`
+	Status         string             `json:"status"`
+	Certifications []TraceCertificate `json:"certifications"`
+	Events         []models.LotEvent  `json:"events"`
+}
+
+// TraceFarm is the public information of the farm a lot was harvested on
+type TraceFarm struct {
+	Name     string `json:"name"`
+	District string `json:"district,omitempty"`
+	Country  string `json:"country,omitempty"`
+}
+
+// TraceCertificate is a certification of the farm that was valid at harvest
+type TraceCertificate struct {
+	Name              string     `json:"name"`
+	Issuer            string     `json:"issuer,omitempty"`
+	CertificateNumber string     `json:"certificate_number,omitempty"`
+	ValidUntil        *time.Time `json:"valid_until"`
+}
+
+// GetTrace builds the traceability report of the lot with the given code
+func GetTrace(db *gorm.DB, lotCode string) (TraceReport, error) {
+	var lot models.InventoryLot
+	if err := db.Preload("Variant.Product.Seller.District.Country").
+		Preload("Events", func(db *gorm.DB) *gorm.DB {
+			return db.Order("occurred_at, id")
+		}).
+		Where("lot_code = ?", lotCode).First(&lot).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return TraceReport{}, ErrLotNotFound
+		}
+		return TraceReport{}, err
+	}
+	if lot.Variant == nil || lot.Variant.Product == nil {
+		return TraceReport{}, ErrLotNotFound
+	}
+
+	variant := lot.Variant
+	seller := variant.Product.Seller
+	report := TraceReport{
+		LotCode:        lot.LotCode,
+		Product:        variant.Product.Name,
+		Variant:        variant.Name,
+		Grade:          variant.Grade,
+		Farm:           TraceFarm{Name: seller.FarmName},
+		HarvestDate:    lot.HarvestDate,
+		BestBefore:     lot.BestBefore,
+		Status:         lot.Status,
+		Certifications: []TraceCertificate{},
+		Events:         lot.Events,
+	}
+	if report.Farm.Name == "" {
+		report.Farm.Name = "Unnamed farm"
+	}
+	if seller.DistrictID != nil {
+		report.Farm.District = seller.District.Name
+		report.Farm.Country = seller.District.Country.Name
+	}
+	if report.Events == nil {
+		report.Events = []models.LotEvent{}
+	}
+
+	var certifications []models.Certification
+	if err := db.Where("seller_id = ?", seller.ID).
+		Where("valid_from IS NULL OR valid_from <= ?", lot.HarvestDate).
+		Where("valid_until IS NULL OR valid_until >= ?", lot.HarvestDate).
+		Order("name").Find(&certifications).Error; err != nil {
+		return TraceReport{}, err
+	}
+	for _, certification := range certifications {
+		report.Certifications = append(report.Certifications, TraceCertificate{
+			Name:              certification.Name,
+			Issuer:            certification.Issuer,
+			CertificateNumber: certification.CertificateNumber,
+			ValidUntil:        certification.ValidUntil,
+		})
+	}
+	return report, nil
+}
+
+// RecordLotEvent adds a handling step recorded by a seller to a lot
+func RecordLotEvent(db *gorm.DB, event *models.LotEvent) error {
+	if !containsString(models.LotEventTypes, event.Type) {
+		return ErrInvalidLotEvent
+	}
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = time.Now()
+	}
+	return db.Create(event).Error
+}
+
+// recordDispatch adds a dispatched event to every lot an order was fulfilled
+// from. The buyer is not named as events are public.
+func recordDispatch(tx *gorm.DB, order models.Order) error {
+	var allocations []models.LotAllocation
+	if err := tx.Joins("JOIN order_items ON order_items.id = lot_allocations.order_item_id").
+		Where("order_items.order_id = ?", order.ID).Order("lot_allocations.id").
+		Find(&allocations).Error; err != nil {
+		return err
+	}
+
+	for _, allocation := range allocations {
+		event := models.LotEvent{
+			LotID:      allocation.LotID,
+			Type:       models.LotEventDispatched,
+			Note:       fmt.Sprintf("%v dispatched to a buyer", allocation.Quantity),
+			OccurredAt: time.Now(),
+		}
+		if err := tx.Create(&event).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
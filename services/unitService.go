@@ -0,0 +1,73 @@
+package services
+
+import (
+	"errors"
+	"farmers_market_backend/models"
+	"fmt"
+	"math"
+
+	"gorm.io/gorm"
+)
+
+// quantityPrecision is the number of decimal places quantities are rounded to
+// after conversion, so float rounding noise does not fail step checks
+const quantityPrecision = 6
+
+// ErrIncompatibleUnits is returned when converting between units of different dimensions
+var ErrIncompatibleUnits = errors.New("units have different dimensions")
+
+// ConvertQuantity converts a quantity from one unit to another of the same dimension
+func ConvertQuantity(quantity float64, from, to models.UnitOfMeasure) (float64, error) {
+	if from.ID == to.ID {
+		return quantity, nil
+	}
+	if from.Dimension != to.Dimension || from.ToBase <= 0 || to.ToBase <= 0 {
+		return 0, ErrIncompatibleUnits
+	}
+	return roundQuantity(quantity * from.ToBase / to.ToBase), nil
+}
+
+// QuantityInVariantUnit converts a quantity given in unitID to the unit the
+// variant is sold in. A zero unitID means the quantity is already in that
+// unit. The variant's UnitOfMeasure must be loaded.
+func QuantityInVariantUnit(db *gorm.DB, variant models.ProductVariant, quantity float64, unitID uint) (float64, error) {
+	if unitID == 0 || unitID == variant.UnitOfMeasureID {
+		return quantity, nil
+	}
+
+	var unit models.UnitOfMeasure
+	if err := db.First(&unit, unitID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, ErrIncompatibleUnits
+		}
+		return 0, err
+	}
+	return ConvertQuantity(quantity, unit, variant.UnitOfMeasure)
+}
+
+// CheckQuantity returns why a quantity in the variant's unit cannot be
+// ordered, or an empty string. Quantities must be positive, reach the
+// minimum order quantity and be a multiple of the unit's step size. The
+// variant's UnitOfMeasure must be loaded.
+func CheckQuantity(variant models.ProductVariant, quantity float64) string {
+	unit := variant.UnitOfMeasure
+	if quantity <= 0 {
+		return "quantity must be greater than zero"
+	}
+	if quantity < variant.MinOrderQty {
+		return fmt.Sprintf("minimum order quantity is %v %s", variant.MinOrderQty, unit.Abbreviation)
+	}
+	if unit.StepSize > 0 {
+		steps := quantity / unit.StepSize
+		if math.Abs(steps-math.Round(steps)) > math.Pow10(-quantityPrecision) {
+			return fmt.Sprintf("quantity must be a multiple of %v %s", unit.StepSize, unit.Abbreviation)
+		}
+	}
+	return ""
+}
+
+// roundQuantity rounds a quantity to quantityPrecision decimal places
+func roundQuantity(quantity float64) float64 {
+	scale := math.Pow10(quantityPrecision)
+	return math.Round(quantity*scale) / scale
+}
@@ -0,0 +1,45 @@
+package services
+
+import (
+	"errors"
+	"farmers_market_backend/models"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrVariantNotFound is returned when a variant does not exist or does not belong to the product
+	ErrVariantNotFound = errors.New("product variant not found")
+	// ErrVariantRequired is returned when a product has several variants and none was chosen
+	ErrVariantRequired = errors.New("product has several variants, choose one")
+)
+
+// ResolveVariant returns the variant a buyer chose, with its product and unit loaded.
+// When no variant is given and the product has exactly one variant, that
+// variant is used so single-variant products can be ordered by product ID.
+func ResolveVariant(db *gorm.DB, productID, variantID uint) (models.ProductVariant, error) {
+	var variants []models.ProductVariant
+	query := db.Preload("Product").Preload("UnitOfMeasure")
+	switch {
+	case variantID != 0:
+		query = query.Where("id = ?", variantID)
+		if productID != 0 {
+			query = query.Where("product_id = ?", productID)
+		}
+	case productID != 0:
+		query = query.Where("product_id = ?", productID)
+	default:
+		return models.ProductVariant{}, ErrVariantNotFound
+	}
+
+	if err := query.Limit(2).Find(&variants).Error; err != nil {
+		return models.ProductVariant{}, err
+	}
+	switch {
+	case len(variants) == 0:
+		return models.ProductVariant{}, ErrVariantNotFound
+	case len(variants) > 1:
+		return models.ProductVariant{}, ErrVariantRequired
+	}
+	return variants[0], nil
+}